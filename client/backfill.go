@@ -0,0 +1,203 @@
+package main
+
+import (
+	"context"
+	"flag"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// Defaults of the log backfill. Providers commonly cap eth_getLogs at a few
+// thousand blocks or 10000 results, so the first chunk stays well below that
+// and grows while the provider keeps up.
+const (
+	defaultLogChunk    = 2000
+	defaultLogParallel = 4
+	maxLogChunk        = 100000
+	maxLogRetries      = 3
+	logRetryBackoff    = time.Second
+)
+
+// logFilterer is the part of a node client a backfill needs.
+type logFilterer interface {
+	FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error)
+}
+
+// logBackfill reads the logs of a query over a long block range. The range is
+// split into chunks that are fetched concurrently and delivered in order. A
+// chunk the provider rejects as too large is split in half and the chunk size
+// shrinks with it; every accepted chunk grows the size again.
+type logBackfill struct {
+	client   logFilterer
+	parallel int
+
+	mu    sync.Mutex
+	chunk uint64
+}
+
+func newLogBackfill(client logFilterer, chunk uint64, parallel int) *logBackfill {
+	if chunk == 0 {
+		chunk = defaultLogChunk
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	return &logBackfill{client: client, parallel: parallel, chunk: chunk}
+}
+
+// registerLogFlags adds the flags that tune the backfill of commands that scan
+// ranges of blocks.
+func (c *connFlags) registerLogFlags(fs *flag.FlagSet) {
+	fs.Uint64Var(&c.logChunk, "chunk", defaultLogChunk, "blocks per eth_getLogs request to start with; adapts to the provider")
+	fs.IntVar(&c.logParallel, "parallel", defaultLogParallel, "eth_getLogs requests in flight")
+}
+
+// run fetches the logs matching q in the blocks from..to and hands them to
+// deliver chunk by chunk, in chain order. It stops at the first error of a
+// request that cannot be retried or of deliver.
+func (b *logBackfill) run(ctx context.Context, q ethereum.FilterQuery, from, to uint64, deliver func(from, to uint64, logs []types.Log) error) error {
+	if from > to {
+		return nil
+	}
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	type result struct {
+		from, to uint64
+		logs     []types.Log
+		err      error
+	}
+	// The queue keeps the results in dispatch order; its capacity together
+	// with the one being delivered bounds the requests in flight.
+	queue := make(chan chan result, b.parallel-1)
+	go func() {
+		defer close(queue)
+		for start := from; ; {
+			end := to
+			if size := b.size(); to-start >= size {
+				end = start + size - 1
+			}
+			res := make(chan result, 1)
+			select {
+			case queue <- res:
+			case <-ctx.Done():
+				return
+			}
+			go func(start, end uint64) {
+				logs, err := b.fetch(ctx, q, start, end)
+				res <- result{from: start, to: end, logs: logs, err: err}
+			}(start, end)
+			if end == to {
+				return
+			}
+			start = end + 1
+		}
+	}()
+
+	for res := range queue {
+		r := <-res
+		if r.err != nil {
+			return r.err
+		}
+		if err := deliver(r.from, r.to, r.logs); err != nil {
+			return err
+		}
+	}
+	return ctx.Err()
+}
+
+// fetch reads a single chunk, splitting it while the provider rejects it as
+// too large and retrying transient failures.
+func (b *logBackfill) fetch(ctx context.Context, q ethereum.FilterQuery, from, to uint64) ([]types.Log, error) {
+	for attempt := 0; ; attempt++ {
+		q.FromBlock, q.ToBlock = new(big.Int).SetUint64(from), new(big.Int).SetUint64(to)
+		logs, err := b.client.FilterLogs(ctx, q)
+		switch {
+		case err == nil:
+			b.grow(to - from + 1)
+			return logs, nil
+		case rangeTooLarge(err) && to > from:
+			b.shrink(to - from + 1)
+			mid := from + (to-from)/2
+			left, err := b.fetch(ctx, q, from, mid)
+			if err != nil {
+				return nil, err
+			}
+			right, err := b.fetch(ctx, q, mid+1, to)
+			if err != nil {
+				return nil, err
+			}
+			return append(left, right...), nil
+		case txerr.Retryable(err) && attempt < maxLogRetries:
+			select {
+			case <-time.After(logRetryBackoff << attempt):
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			}
+		default:
+			return nil, txerr.Classify(err)
+		}
+	}
+}
+
+func (b *logBackfill) size() uint64 {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.chunk
+}
+
+// grow raises the chunk size by half after a chunk of the current size or
+// larger went through.
+func (b *logBackfill) grow(accepted uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if accepted >= b.chunk {
+		b.chunk += b.chunk/2 + 1
+		if b.chunk > maxLogChunk {
+			b.chunk = maxLogChunk
+		}
+	}
+}
+
+// shrink halves the chunk size below a rejected one.
+func (b *logBackfill) shrink(rejected uint64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if half := rejected / 2; half < b.chunk {
+		b.chunk = half
+	}
+	if b.chunk == 0 {
+		b.chunk = 1
+	}
+}
+
+// rangeTooLarge reports whether the provider refused an eth_getLogs request
+// because of its block range or the number of results, which a smaller range
+// fixes. The messages are those of geth, Infura, Alchemy and QuickNode.
+func rangeTooLarge(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{
+		"query returned more than",
+		"block range",
+		"range is too large",
+		"range too large",
+		"exceed maximum block range",
+		"response size exceeded",
+		"log response size",
+		"query timeout exceeded",
+		"too many logs",
+		"is limited to",
+	} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
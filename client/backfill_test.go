@@ -0,0 +1,187 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// limitedLogs is a provider that refuses eth_getLogs over more than limit
+// blocks and otherwise returns one log per block.
+type limitedLogs struct {
+	limit uint64
+
+	mu       sync.Mutex
+	requests []string
+}
+
+func (l *limitedLogs) FilterLogs(_ context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	from, to := q.FromBlock.Uint64(), q.ToBlock.Uint64()
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if to-from+1 > l.limit {
+		l.requests = append(l.requests, fmt.Sprintf("%d-%d!", from, to))
+		return nil, errors.New("query returned more than 10000 results")
+	}
+	l.requests = append(l.requests, fmt.Sprintf("%d-%d", from, to))
+	logs := make([]types.Log, 0, to-from+1)
+	for n := from; n <= to; n++ {
+		logs = append(logs, types.Log{BlockNumber: n})
+	}
+	return logs, nil
+}
+
+// TestBackfillSplit checks how a rejected range is halved, depth first; a
+// rejected request is marked with "!".
+func TestBackfillSplit(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, limit uint64
+		requests        string
+		wantErr         bool
+	}{
+		{5, 5, 1, "5-5", false},
+		{0, 9, 10, "0-9", false},
+		{0, 9, 4, "0-9! 0-4! 0-2 3-4 5-9! 5-7 8-9", false},
+		{10, 13, 1, "10-13! 10-11! 10-10 11-11 12-13! 12-12 13-13", false},
+		{
+			0, 99, 16,
+			"0-99! 0-49! 0-24! 0-12 13-24 25-49! 25-37 38-49 50-99! 50-74! 50-62 63-74 75-99! 75-87 88-99",
+			false,
+		},
+		{7, 7, 0, "7-7!", true},
+	} {
+		t.Run(fmt.Sprintf("%d-%d/%d", tc.from, tc.to, tc.limit), func(t *testing.T) {
+			provider := &limitedLogs{limit: tc.limit}
+			b := newLogBackfill(provider, 0, 1)
+			logs, err := b.fetch(context.Background(), ethereum.FilterQuery{}, tc.from, tc.to)
+			if got := strings.Join(provider.requests, " "); got != tc.requests {
+				t.Errorf("requests %s, want %s", got, tc.requests)
+			}
+			if tc.wantErr {
+				if err == nil {
+					t.Error("fetch succeeded, want an error")
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if uint64(len(logs)) != tc.to-tc.from+1 {
+				t.Fatalf("%d logs, want %d", len(logs), tc.to-tc.from+1)
+			}
+			for i, log := range logs {
+				if log.BlockNumber != tc.from+uint64(i) {
+					t.Fatalf("log %d is from block %d, want %d", i, log.BlockNumber, tc.from+uint64(i))
+				}
+			}
+		})
+	}
+}
+
+func TestBackfillChunkSize(t *testing.T) {
+	for _, tc := range []struct {
+		chunk  uint64
+		op     string
+		blocks uint64
+		want   uint64
+	}{
+		{2000, "grow", 2000, 3001},
+		{2000, "grow", 5000, 3001},
+		{2000, "grow", 1999, 2000},
+		{1, "grow", 1, 2},
+		{90000, "grow", 90000, maxLogChunk},
+		{2000, "shrink", 2000, 1000},
+		{2000, "shrink", 5000, 2000},
+		{2000, "shrink", 1001, 500},
+		{2000, "shrink", 3, 1},
+		{2000, "shrink", 1, 1},
+	} {
+		b := newLogBackfill(nil, tc.chunk, 1)
+		if tc.op == "grow" {
+			b.grow(tc.blocks)
+		} else {
+			b.shrink(tc.blocks)
+		}
+		if got := b.size(); got != tc.want {
+			t.Errorf("chunk %d, %s after %d blocks: %d, want %d", tc.chunk, tc.op, tc.blocks, got, tc.want)
+		}
+	}
+}
+
+func TestBackfillRun(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, chunk, limit uint64
+		parallel               int
+	}{
+		{0, 0, 10, 10, 1},
+		{0, 999, 100, 1000, 1},
+		{0, 999, 100, 64, 1},
+		{0, 999, 100, 64, 4},
+		{500, 12345, 2000, 700, 8},
+	} {
+		name := fmt.Sprintf("%d-%d chunk %d limit %d parallel %d", tc.from, tc.to, tc.chunk, tc.limit, tc.parallel)
+		t.Run(name, func(t *testing.T) {
+			provider := &limitedLogs{limit: tc.limit}
+			b := newLogBackfill(provider, tc.chunk, tc.parallel)
+			next := tc.from
+			err := b.run(context.Background(), ethereum.FilterQuery{}, tc.from, tc.to, func(from, to uint64, logs []types.Log) error {
+				if from != next || to < from {
+					return fmt.Errorf("delivered %d-%d after %d", from, to, next-1)
+				}
+				for i, log := range logs {
+					if log.BlockNumber != from+uint64(i) {
+						return fmt.Errorf("chunk %d-%d: log %d is from block %d", from, to, i, log.BlockNumber)
+					}
+				}
+				next = to + 1
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if next != tc.to+1 {
+				t.Errorf("delivered up to %d, want %d", next-1, tc.to)
+			}
+		})
+	}
+}
+
+func TestBackfillDeliverError(t *testing.T) {
+	stop := errors.New("stop")
+	b := newLogBackfill(&limitedLogs{limit: 100}, 10, 4)
+	chunks := 0
+	err := b.run(context.Background(), ethereum.FilterQuery{}, 0, 999, func(uint64, uint64, []types.Log) error {
+		chunks++
+		if chunks == 3 {
+			return stop
+		}
+		return nil
+	})
+	if !errors.Is(err, stop) || chunks != 3 {
+		t.Errorf("run returned %v after %d chunks, want %v after 3", err, chunks, stop)
+	}
+}
+
+func TestRangeTooLarge(t *testing.T) {
+	for msg, want := range map[string]bool{
+		"query returned more than 10000 results":                        true,
+		"eth_getLogs block range is too large, max is 2000":             true,
+		"Log response size exceeded. You can make eth_getLogs requests": true,
+		"exceed maximum block range: 5000":                              true,
+		"eth_getLogs is limited to a 10,000 range":                      true,
+		"query timeout exceeded":                                        true,
+		"nonce too low":                                                 false,
+		"429 Too Many Requests":                                         false,
+		"connection refused":                                            false,
+	} {
+		if got := rangeTooLarge(errors.New(msg)); got != want {
+			t.Errorf("rangeTooLarge(%q) = %v, want %v", msg, got, want)
+		}
+	}
+}
@@ -0,0 +1,159 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Block tags accepted by --tag.
+const (
+	tagSafe      = "safe"
+	tagFinalized = "finalized"
+)
+
+// timeLayouts are the layouts --at accepts, tried in order. Layouts without a
+// zone are UTC.
+var timeLayouts = []string{
+	time.RFC3339,
+	"2006-01-02T15:04Z07:00",
+	"2006-01-02T15:04:05",
+	"2006-01-02T15:04",
+	"2006-01-02",
+}
+
+// blockFlags select the block a read command queries: a number, the last
+// block at a point in time, or a tag. Without any the latest block is used.
+type blockFlags struct {
+	number int64
+	at     string
+	tag    string
+}
+
+func (b *blockFlags) register(fs *flag.FlagSet) {
+	fs.Int64Var(&b.number, "block", -1, "block number to query (default: the latest block)")
+	fs.StringVar(&b.at, "at", "", "query the last block at or before this time, e.g. 2026-01-01T00:00Z (UTC unless a zone is given)")
+	fs.StringVar(&b.tag, "tag", "", "query the "+tagSafe+" or "+tagFinalized+" block")
+}
+
+// parseTime parses the value of --at, also accepting a unix timestamp.
+func parseTime(value string) (time.Time, error) {
+	for _, layout := range timeLayouts {
+		if t, err := time.Parse(layout, value); err == nil {
+			return t, nil
+		}
+	}
+	if seconds, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return time.Unix(seconds, 0), nil
+	}
+	return time.Time{}, fmt.Errorf("--at: invalid time %q (want e.g. 2026-01-01T00:00Z or 2026-01-01)", value)
+}
+
+// callOpts resolves the selected block and returns the options that query
+// it. The resolved block is reported to w, unless it is the latest one.
+func (b *blockFlags) callOpts(ctx context.Context, chain chainReader, w io.Writer) (*bind.CallOpts, error) {
+	set := 0
+	for _, given := range []bool{b.number >= 0, b.at != "", b.tag != ""} {
+		if given {
+			set++
+		}
+	}
+	if set > 1 {
+		return nil, fmt.Errorf("--block, --at and --tag are mutually exclusive")
+	}
+
+	var header *types.Header
+	var err error
+	switch {
+	case b.number >= 0:
+		return &bind.CallOpts{Context: ctx, BlockNumber: big.NewInt(b.number)}, nil
+	case b.tag != "":
+		header, err = headerByTag(ctx, chain, b.tag)
+	case b.at != "":
+		var t time.Time
+		if t, err = parseTime(b.at); err == nil {
+			header, err = headerAt(ctx, chain, t)
+		}
+	default:
+		return &bind.CallOpts{Context: ctx}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fmt.Fprintf(w, "block %d (%s)\n", header.Number, time.Unix(int64(header.Time), 0).UTC().Format(time.RFC3339))
+	return &bind.CallOpts{Context: ctx, BlockNumber: header.Number}, nil
+}
+
+// headerByTag returns the header of the safe or finalized block.
+func headerByTag(ctx context.Context, chain chainReader, tag string) (*types.Header, error) {
+	var number rpc.BlockNumber
+	switch tag {
+	case tagSafe:
+		number = rpc.SafeBlockNumber
+	case tagFinalized:
+		number = rpc.FinalizedBlockNumber
+	default:
+		return nil, fmt.Errorf("--tag: unknown tag %q (want %s or %s)", tag, tagSafe, tagFinalized)
+	}
+	header, err := chain.HeaderByNumber(ctx, big.NewInt(int64(number)))
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, fmt.Errorf("the node knows no %s block yet; it needs a consensus client to track it", tag)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%s block: %w", tag, err)
+	}
+	return header, nil
+}
+
+// headerAt returns the header of the last block mined at or before t, found
+// by binary search over the block timestamps, which never decrease. The head
+// is that block for any t from its timestamp on, however long ago it was
+// mined; only a t after the wall clock is refused.
+func headerAt(ctx context.Context, chain chainReader, t time.Time) (*types.Header, error) {
+	if t.After(time.Now()) {
+		return nil, fmt.Errorf("--at: %s is in the future", t.UTC().Format(time.RFC3339))
+	}
+	if t.Unix() < 0 {
+		return nil, fmt.Errorf("--at: %s is before the first block", t.UTC().Format(time.RFC3339))
+	}
+	target := uint64(t.Unix())
+	head, err := chain.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.Time <= target {
+		return head, nil
+	}
+	lo, hi := uint64(0), head.Number.Uint64()
+	var best *types.Header
+	for lo <= hi {
+		mid := lo + (hi-lo)/2
+		header, err := chain.HeaderByNumber(ctx, new(big.Int).SetUint64(mid))
+		if err != nil {
+			return nil, err
+		}
+		if header.Time > target {
+			if mid == 0 {
+				break
+			}
+			hi = mid - 1
+		} else {
+			best = header
+			lo = mid + 1
+		}
+	}
+	if best == nil {
+		return nil, fmt.Errorf("--at: %s is before the first block", t.UTC().Format(time.RFC3339))
+	}
+	return best, nil
+}
@@ -0,0 +1,60 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// datedChain is a chain whose block n is mined at times[n] seconds after
+// 1970, like a devnet's.
+type datedChain []uint64
+
+func (c datedChain) BlockNumber(context.Context) (uint64, error) {
+	return uint64(len(c) - 1), nil
+}
+
+func (c datedChain) HeaderByNumber(_ context.Context, number *big.Int) (*types.Header, error) {
+	if number == nil {
+		number = big.NewInt(int64(len(c) - 1))
+	}
+	if !number.IsUint64() || number.Uint64() >= uint64(len(c)) {
+		return nil, ethereum.NotFound
+	}
+	return &types.Header{Number: number, Time: c[number.Uint64()]}, nil
+}
+
+func TestHeaderAt(t *testing.T) {
+	chain := datedChain{0, 10, 20, 20, 40, 3600}
+	for _, tc := range []struct {
+		at    int64
+		block int64 // -1 for an error
+	}{
+		{-1, -1},
+		{0, 0},
+		{9, 0},
+		{10, 1},
+		{20, 3},
+		{39, 3},
+		{40, 4},
+		{3599, 4},
+		{3600, 5},
+		{3601, 5},
+		{time.Now().Unix(), 5},
+		{time.Now().Add(time.Hour).Unix(), -1},
+	} {
+		header, err := headerAt(context.Background(), chain, time.Unix(tc.at, 0))
+		switch {
+		case tc.block < 0 && err == nil:
+			t.Errorf("headerAt(%d) = block %d, want an error", tc.at, header.Number)
+		case tc.block >= 0 && err != nil:
+			t.Errorf("headerAt(%d): %v", tc.at, err)
+		case tc.block >= 0 && header.Number.Int64() != tc.block:
+			t.Errorf("headerAt(%d) = block %d, want %d", tc.at, header.Number, tc.block)
+		}
+	}
+}
@@ -0,0 +1,443 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/event"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// filmChange is a FilmAdded or FilmDeleted event.
+type filmChange struct {
+	Title     string
+	Year      *big.Int // nil for deletions
+	Genre     uint8
+	Deleted   bool
+	Overwrite bool // an addition that replaced a film in the catalog
+	Raw       types.Log
+}
+
+func filmAddedChange(ev *MainFilmAdded) filmChange {
+	return filmChange{Title: ev.Title, Year: ev.Year, Genre: ev.Genre, Raw: ev.Raw}
+}
+
+func filmDeletedChange(ev *MainFilmDeleted) filmChange {
+	return filmChange{Title: ev.Title, Deleted: true, Raw: ev.Raw}
+}
+
+func (c filmChange) String() string {
+	if c.Deleted {
+		return fmt.Sprintf("deleted %q", c.Title)
+	}
+	s := fmt.Sprintf("added   %q year=%s genre=%s", c.Title, c.Year, genreName(c.Genre))
+	if c.Overwrite {
+		s += " (overwrite)"
+	}
+	return s
+}
+
+// logBefore orders logs the way the chain does.
+func logBefore(a, b types.Log) bool {
+	if a.BlockNumber != b.BlockNumber {
+		return a.BlockNumber < b.BlockNumber
+	}
+	return a.Index < b.Index
+}
+
+// parseFilmLog decodes a FilmAdded or FilmDeleted log.
+func parseFilmLog(filterer *MainFilterer, log types.Log) (filmChange, error) {
+	if len(log.Topics) > 0 {
+		switch log.Topics[0] {
+		case mainEventID("FilmAdded"):
+			ev, err := filterer.ParseFilmAdded(log)
+			if err != nil {
+				return filmChange{}, err
+			}
+			return filmAddedChange(ev), nil
+		case mainEventID("FilmDeleted"):
+			ev, err := filterer.ParseFilmDeleted(log)
+			if err != nil {
+				return filmChange{}, err
+			}
+			return filmDeletedChange(ev), nil
+		}
+	}
+	return filmChange{}, fmt.Errorf("log %d of %s is not a film event", log.Index, log.TxHash.Hex())
+}
+
+// filmRecord is one film event as stored in the index. The catalog keeps the
+// record of the latest event of every title: the film as it stands, or a
+// tombstone once deleted.
+type filmRecord struct {
+	logRef
+	Title     string   `json:"title"`
+	Year      *big.Int `json:"year,omitempty"`
+	Genre     uint8    `json:"genre"`
+	Deleted   bool     `json:"deleted,omitempty"`
+	Overwrite bool     `json:"overwrite,omitempty"`
+}
+
+// logRefOf returns the position of log.
+func logRefOf(log types.Log) logRef {
+	return logRef{Block: log.BlockNumber, BlockHash: log.BlockHash, TxHash: log.TxHash, LogIndex: log.Index}
+}
+
+func (c filmChange) record() *filmRecord {
+	return &filmRecord{
+		logRef:    logRefOf(c.Raw),
+		Title:     c.Title,
+		Year:      c.Year,
+		Genre:     c.Genre,
+		Deleted:   c.Deleted,
+		Overwrite: c.Overwrite,
+	}
+}
+
+// newerThan reports whether c happened after the event recorded in r.
+func (c filmChange) newerThan(r *filmRecord) bool {
+	return logBefore(types.Log{BlockNumber: r.Block, Index: r.LogIndex}, c.Raw)
+}
+
+// filmsCheckpoint is the checkpoint name of the film indexer.
+const filmsCheckpoint = "films"
+
+// putFilmChange stores c in the event history and makes it the catalog entry
+// of its title unless the entry already comes from a later event, so applying
+// an event twice or out of order is harmless. An addition that replaces a film
+// is tagged as an overwrite. It reports whether the catalog changed.
+func putFilmChange(tx storeTx, c *filmChange) (bool, error) {
+	current, err := tx.film(c.Title)
+	if err != nil {
+		return false, err
+	}
+	if current != nil && !c.newerThan(current) {
+		if current.logRef == logRefOf(c.Raw) {
+			return false, nil
+		}
+		// An older event pushed out of order; the next sync replays it in
+		// place and tags it.
+		return false, tx.putFilmEvent(c.record())
+	}
+	c.Overwrite = !c.Deleted && current != nil && !current.Deleted
+	record := c.record()
+	if err := tx.putFilmEvent(record); err != nil {
+		return false, err
+	}
+	return true, tx.putFilm(record)
+}
+
+// rollbackFilms forgets the film events of block from and later ones and
+// restores the catalog entries they touched from the remaining history.
+func rollbackFilms(tx storeTx, from uint64) error {
+	removed, err := tx.deleteFilmEvents(from)
+	if err != nil {
+		return err
+	}
+	touched := make(map[string]bool)
+	for _, r := range removed {
+		touched[r.Title] = true
+	}
+	for title := range touched {
+		latest, err := tx.lastFilmEvent(title)
+		if err != nil {
+			return err
+		}
+		if latest == nil {
+			err = tx.deleteFilm(title)
+		} else {
+			err = tx.putFilm(latest)
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// catalogAsOf folds film events, in chain order, into the catalog they leave
+// behind: the latest event of every title.
+func catalogAsOf(events []*filmRecord) map[string]*filmRecord {
+	catalog := make(map[string]*filmRecord)
+	for _, r := range events {
+		catalog[r.Title] = r
+	}
+	return catalog
+}
+
+// filmDiff is the change one film event made to the catalog. Old is nil for
+// an added film and New for a deleted one.
+type filmDiff struct {
+	Title string
+	Block uint64
+	Old   *filmRecord
+	New   *filmRecord
+}
+
+func (d filmDiff) String() string {
+	switch {
+	case d.Old == nil:
+		return fmt.Sprintf("block %d: added       %q year=%s genre=%s", d.Block, d.Title, d.New.Year, genreName(d.New.Genre))
+	case d.New == nil:
+		return fmt.Sprintf("block %d: deleted     %q year=%s genre=%s", d.Block, d.Title, d.Old.Year, genreName(d.Old.Genre))
+	default:
+		return fmt.Sprintf("block %d: overwritten %q year=%s->%s genre=%s->%s", d.Block, d.Title, d.Old.Year, d.New.Year, genreName(d.Old.Genre), genreName(d.New.Genre))
+	}
+}
+
+// diffFilmEvents lists, in chain order, every addition, deletion and
+// overwrite the film events make to the catalog before, which is left
+// unchanged. A title added and deleted again within the events shows up
+// twice, and one added again after being deleted counts as added. Adding a
+// title again with the same year and genre changes nothing and is left out.
+func diffFilmEvents(before map[string]*filmRecord, events []*filmRecord) []filmDiff {
+	catalog := make(map[string]*filmRecord, len(before))
+	for title, r := range before {
+		if !r.Deleted {
+			catalog[title] = r
+		}
+	}
+	var diffs []filmDiff
+	for _, r := range events {
+		old := catalog[r.Title]
+		if r.Deleted {
+			if old == nil {
+				continue
+			}
+			delete(catalog, r.Title)
+			diffs = append(diffs, filmDiff{Title: r.Title, Block: r.Block, Old: old})
+			continue
+		}
+		catalog[r.Title] = r
+		if old != nil && old.Year.Cmp(r.Year) == 0 && old.Genre == r.Genre {
+			continue
+		}
+		diffs = append(diffs, filmDiff{Title: r.Title, Block: r.Block, Old: old, New: r})
+	}
+	return diffs
+}
+
+// filmEvents returns the film events of blocks from..to in chain order.
+func (ix *index) filmEvents(from, to uint64) (events []*filmRecord, err error) {
+	err = ix.view(func(tx storeTx) error {
+		events, err = tx.filmEvents(from, to)
+		return err
+	})
+	return events, err
+}
+
+// catalogAt returns the catalog as it stood at the end of block, tombstones
+// included.
+func (ix *index) catalogAt(block uint64) (map[string]*filmRecord, error) {
+	var events []*filmRecord
+	err := ix.view(func(tx storeTx) error {
+		var err error
+		events, err = tx.filmEvents(0, block)
+		return err
+	})
+	return catalogAsOf(events), err
+}
+
+// currentFilm returns the latest event of title up to the chain head, or nil
+// when the title was never added. It starts from the index when one of the
+// same contract can be read and only scans the blocks the index has not
+// processed yet; otherwise it scans every film event since deploy_block.
+func currentFilm(ctx context.Context, s *session, idx *indexFlags, title string, w io.Writer) (*filmRecord, error) {
+	var current *filmRecord
+	start := s.network.DeployBlock
+	if ix, err := idx.open(true); err != nil {
+		fmt.Fprintf(w, "index unavailable (%v), scanning film events from block %d\n", err, start)
+	} else {
+		defer ix.Close()
+		err = ix.view(func(tx storeTx) error {
+			source, _, err := tx.meta("source")
+			if err != nil {
+				return err
+			}
+			if source != sourceID(s.chainID.Uint64(), s.address) {
+				fmt.Fprintf(w, "index belongs to another contract, scanning film events from block %d\n", start)
+				return nil
+			}
+			next, found, err := tx.checkpoint(filmsCheckpoint)
+			if err != nil || !found {
+				return err
+			}
+			start = next
+			current, err = tx.film(title)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	head, err := s.client.BlockNumber(ctx)
+	if err != nil {
+		return nil, err
+	}
+	films := &filmConsumer{filterer: &s.token.MainFilterer, address: s.address}
+	err = s.logs.run(ctx, films.query(), start, head, func(_, _ uint64, logs []types.Log) error {
+		for _, log := range logs {
+			c, err := parseFilmLog(films.filterer, log)
+			if err != nil {
+				return err
+			}
+			if c.Title == title && (current == nil || c.newerThan(current)) {
+				current = c.record()
+			}
+		}
+		return nil
+	})
+	return current, err
+}
+
+// filmConsumer feeds FilmAdded and FilmDeleted logs into the catalog.
+type filmConsumer struct {
+	filterer *MainFilterer
+	address  common.Address
+	out      io.Writer // every catalog change is reported here
+}
+
+func (fc *filmConsumer) name() string {
+	return filmsCheckpoint
+}
+
+func (fc *filmConsumer) query() ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{fc.address},
+		Topics:    [][]common.Hash{{mainEventID("FilmAdded"), mainEventID("FilmDeleted")}},
+	}
+}
+
+func (fc *filmConsumer) apply(tx storeTx, logs []types.Log) error {
+	for _, log := range logs {
+		c, err := parseFilmLog(fc.filterer, log)
+		if err != nil {
+			return err
+		}
+		changed, err := putFilmChange(tx, &c)
+		if err != nil {
+			return err
+		}
+		if changed {
+			fc.report(c)
+		}
+	}
+	return nil
+}
+
+func (fc *filmConsumer) rollback(tx storeTx, from uint64) error {
+	return rollbackFilms(tx, from)
+}
+
+func (fc *filmConsumer) report(c filmChange) {
+	fmt.Fprintf(fc.out, "block %d tx %s: %s\n", c.Raw.BlockNumber, c.Raw.TxHash.Hex(), c)
+}
+
+// filmIndexer keeps the film catalog of an index in sync with the chain.
+type filmIndexer struct {
+	*ingester
+	films *filmConsumer
+}
+
+func newFilmIndexer(ix *index, s *session, out io.Writer) *filmIndexer {
+	films := &filmConsumer{filterer: &s.token.MainFilterer, address: s.address, out: out}
+	return &filmIndexer{
+		ingester: &ingester{index: ix, chain: s.client, logs: s.logs, consumer: films, out: out},
+		films:    films,
+	}
+}
+
+// follow keeps the catalog at the chain head until ctx is done. Events are
+// applied as soon as the node pushes them when it supports subscriptions; the
+// periodic sync catches up on anything a subscription missed, handles
+// reorganizations and is the only way forward over plain HTTP.
+func (fi *filmIndexer) follow(ctx context.Context, poll time.Duration) error {
+	added := make(chan *MainFilmAdded)
+	deleted := make(chan *MainFilmDeleted)
+	var addedErr, deletedErr <-chan error
+
+	subs, err := fi.watch(ctx, added, deleted)
+	switch {
+	case errors.Is(err, rpc.ErrNotificationsUnsupported):
+		fmt.Fprintf(fi.out, "node does not support subscriptions, polling every %s\n", poll)
+	case err != nil:
+		return err
+	default:
+		defer subs[0].Unsubscribe()
+		defer subs[1].Unsubscribe()
+		addedErr, deletedErr = subs[0].Err(), subs[1].Err()
+	}
+
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		var pushed *filmChange
+		select {
+		case <-ctx.Done():
+			return nil
+		case ev := <-added:
+			c := filmAddedChange(ev)
+			pushed = &c
+		case ev := <-deleted:
+			c := filmDeletedChange(ev)
+			pushed = &c
+		case err := <-addedErr:
+			fmt.Fprintf(fi.out, "subscription dropped (%v), polling every %s\n", err, poll)
+			addedErr, deletedErr = nil, nil
+		case err := <-deletedErr:
+			fmt.Fprintf(fi.out, "subscription dropped (%v), polling every %s\n", err, poll)
+			addedErr, deletedErr = nil, nil
+		case <-ticker.C:
+			if err := fi.sync(ctx, 0); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+		if pushed == nil {
+			continue
+		}
+		if pushed.Raw.Removed {
+			// The node dropped the event's block; the sync finds the fork
+			// point and replays from there.
+			if err := fi.sync(ctx, 0); err != nil && ctx.Err() == nil {
+				return err
+			}
+			continue
+		}
+		// Pushed events leave the checkpoint alone: the two subscriptions
+		// are not ordered against each other, so only the sync knows when a
+		// block is complete, and it replaces these events with its own.
+		err := fi.index.update(func(tx storeTx) error {
+			changed, err := putFilmChange(tx, pushed)
+			if err == nil && changed {
+				fi.films.report(*pushed)
+			}
+			return err
+		})
+		if err != nil {
+			return err
+		}
+	}
+}
+
+func (fi *filmIndexer) watch(ctx context.Context, added chan<- *MainFilmAdded, deleted chan<- *MainFilmDeleted) ([2]event.Subscription, error) {
+	var subs [2]event.Subscription
+	opts := &bind.WatchOpts{Context: ctx}
+	var err error
+	if subs[0], err = fi.films.filterer.WatchFilmAdded(opts, added); err != nil {
+		return subs, err
+	}
+	if subs[1], err = fi.films.filterer.WatchFilmDeleted(opts, deleted); err != nil {
+		subs[0].Unsubscribe()
+		return subs, err
+	}
+	return subs, nil
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+// filmEvent returns the record of a film event; year 0 makes it a deletion.
+func filmEvent(block uint64, title string, year int64) *filmRecord {
+	r := &filmRecord{logRef: logRef{Block: block}, Title: title}
+	if year == 0 {
+		r.Deleted = true
+	} else {
+		r.Year = big.NewInt(year)
+	}
+	return r
+}
+
+func TestDiffFilmEvents(t *testing.T) {
+	alien := filmEvent(1, "Alien", 1979)
+	brazil := filmEvent(2, "Brazil", 1985)
+	gone := filmEvent(3, "Casablanca", 0)
+	before := catalogAsOf([]*filmRecord{alien, brazil, filmEvent(2, "Casablanca", 1942), gone})
+
+	alien2 := filmEvent(5, "Alien", 1986)
+	dune := filmEvent(6, "Dune", 1984)
+	dune2 := filmEvent(8, "Dune", 2021)
+	casablanca := filmEvent(9, "Casablanca", 1942)
+	brazil2 := filmEvent(12, "Brazil", 1985)
+	brazil2.Genre = 2
+	events := []*filmRecord{
+		alien2,
+		dune,
+		filmEvent(7, "Alien", 0),
+		dune2,
+		casablanca,
+		filmEvent(10, "Dune", 0),
+		filmEvent(11, "Metropolis", 0), // never in the catalog
+		filmEvent(11, "Brazil", 1985),  // the same data again
+		brazil2,
+	}
+	want := []filmDiff{
+		{Title: "Alien", Block: 5, Old: alien, New: alien2},
+		{Title: "Dune", Block: 6, New: dune},
+		{Title: "Alien", Block: 7, Old: alien2},
+		{Title: "Dune", Block: 8, Old: dune, New: dune2},
+		{Title: "Casablanca", Block: 9, New: casablanca},
+		{Title: "Dune", Block: 10, Old: dune2},
+		{Title: "Brazil", Block: 12, Old: events[7], New: brazil2},
+	}
+	got := diffFilmEvents(before, events)
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("diffFilmEvents =\n%v\nwant\n%v", got, want)
+	}
+	if before["Alien"] != alien || len(before) != 3 {
+		t.Error("the catalog before the events changed")
+	}
+	if got := diffFilmEvents(before, nil); len(got) != 0 {
+		t.Errorf("diff without events %v", got)
+	}
+}
+
+func TestFilmDiffString(t *testing.T) {
+	alien, alien2 := filmEvent(1, "Alien", 1979), filmEvent(5, "Alien", 1986)
+	for _, tc := range []struct {
+		diff filmDiff
+		want string
+	}{
+		{filmDiff{Title: "Alien", Block: 1, New: alien}, `block 1: added       "Alien" year=1979 genre=horror`},
+		{filmDiff{Title: "Alien", Block: 5, Old: alien, New: alien2}, `block 5: overwritten "Alien" year=1979->1986 genre=horror->horror`},
+		{filmDiff{Title: "Alien", Block: 7, Old: alien2}, `block 7: deleted     "Alien" year=1986 genre=horror`},
+	} {
+		if got := tc.diff.String(); got != tc.want {
+			t.Errorf("String() = %s, want %s", got, tc.want)
+		}
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// errUsage is returned by a command whose arguments could not be parsed; the
+// usage text has already been printed when it is returned.
+var errUsage = errors.New("invalid usage")
+
+// command is a node of the CLI tree. Leaf commands have a run function, group
+// commands only dispatch to their children.
+type command struct {
+	name     string
+	summary  string
+	run      func(args []string) error
+	children []*command
+}
+
+func (c *command) find(name string) *command {
+	for _, child := range c.children {
+		if child.name == name {
+			return child
+		}
+	}
+	return nil
+}
+
+func (c *command) printUsage(w io.Writer, path string) {
+	fmt.Fprintf(w, "Usage: %s <command> [flags]\n\nCommands:\n", path)
+	for _, child := range c.children {
+		fmt.Fprintf(w, "  %-14s %s\n", child.name, child.summary)
+	}
+	fmt.Fprintf(w, "\nRun '%s <command> -h' for the flags of a command.\n", path)
+}
+
+// execute walks down the tree following args and runs the selected leaf.
+func (c *command) execute(path string, args []string) error {
+	if c.run != nil {
+		return c.run(args)
+	}
+	if len(args) == 0 || args[0] == "-h" || args[0] == "--help" || args[0] == "help" {
+		c.printUsage(os.Stderr, path)
+		if len(args) == 0 {
+			return errUsage
+		}
+		return flag.ErrHelp
+	}
+	child := c.find(args[0])
+	if child == nil {
+		fmt.Fprintf(os.Stderr, "unknown command %q\n\n", strings.Join([]string{path, args[0]}, " "))
+		c.printUsage(os.Stderr, path)
+		return errUsage
+	}
+	return child.execute(path+" "+child.name, args[1:])
+}
+
+// newFlagSet returns a flag set that reports parse errors instead of exiting.
+func newFlagSet(path string) *flag.FlagSet {
+	fs := flag.NewFlagSet(path, flag.ContinueOnError)
+	fs.Usage = func() {
+		fmt.Fprintf(fs.Output(), "Usage: %s [flags]\n\nFlags:\n", path)
+		fs.PrintDefaults()
+	}
+	return fs
+}
+
+// parseFlags parses args into fs and rejects positional leftovers, since every
+// command argument is expected to be passed as a flag.
+func parseFlags(fs *flag.FlagSet, args []string) error {
+	if err := fs.Parse(args); err != nil {
+		if errors.Is(err, flag.ErrHelp) {
+			return err
+		}
+		return errUsage
+	}
+	if fs.NArg() > 0 {
+		fmt.Fprintf(fs.Output(), "unexpected arguments: %s\n", strings.Join(fs.Args(), " "))
+		fs.Usage()
+		return errUsage
+	}
+	return nil
+}
+
+// requireFlags checks that every named flag was given, with a non-empty
+// value. A flag left at its default counts as missing even when the default,
+// such as 0, is not empty.
+func requireFlags(fs *flag.FlagSet, names ...string) error {
+	given := make(map[string]bool)
+	fs.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, name := range names {
+		f := fs.Lookup(name)
+		if f == nil || !given[name] || f.Value.String() == "" {
+			fmt.Fprintf(fs.Output(), "flag --%s is required\n", name)
+			fs.Usage()
+			return errUsage
+		}
+	}
+	return nil
+}
+
+func parseAddress(name, value string) (common.Address, error) {
+	if !common.IsHexAddress(value) {
+		return common.Address{}, fmt.Errorf("--%s: invalid address %q", name, value)
+	}
+	return common.HexToAddress(value), nil
+}
+
+// parseAmount parses a token amount given in base units (10^-decimals EMD).
+func parseAmount(name, value string) (*big.Int, error) {
+	amount, ok := new(big.Int).SetString(value, 10)
+	if !ok || amount.Sign() < 0 {
+		return nil, fmt.Errorf("--%s: invalid amount %q", name, value)
+	}
+	return amount, nil
+}
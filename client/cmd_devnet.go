@@ -0,0 +1,165 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func devnetCommand() *command {
+	return &command{
+		name:    "devnet",
+		summary: "serve a simulated chain with the token deployed over JSON-RPC",
+		run:     runDevnet,
+	}
+}
+
+func runDevnet(args []string) error {
+	fs := newFlagSet("emerald devnet")
+	addr := fs.String("addr", "127.0.0.1:8545", "address to serve HTTP and WebSocket JSON-RPC on")
+	mnemonic := fs.String("mnemonic", devnetMnemonic, "mnemonic the pre-funded accounts are derived from")
+	accounts := fs.Int("accounts", 10, "number of pre-funded accounts")
+	balance := fs.String("balance", "10000", "ether given to every account")
+	gasLimit := fs.Uint64("gas-limit", 30_000_000, "block gas limit")
+	deploy := fs.Bool("deploy", true, "deploy EmeraldToken from the first account")
+	supply := fs.String("supply", "100", "initial token supply minted to the deployer, in base units")
+	automine := fs.Bool("automine", true, "mine every transaction into a block of its own as it arrives")
+	interval := fs.Duration("interval", 0, "also mine a block at this interval, e.g. 5s (default: never)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *accounts < 1 {
+		return fmt.Errorf("--accounts: need at least one account, got %d", *accounts)
+	}
+	ether, err := parseAmount("balance", *balance)
+	if err != nil {
+		return err
+	}
+	cfg := &devnetConfig{
+		mnemonic: *mnemonic,
+		accounts: *accounts,
+		balance:  new(big.Int).Mul(ether, big.NewInt(params.Ether)),
+		gasLimit: *gasLimit,
+	}
+	if *deploy {
+		if cfg.supply, err = parseAmount("supply", *supply); err != nil {
+			return err
+		}
+	}
+
+	d, err := newDevnet(cfg)
+	if err != nil {
+		return err
+	}
+	defer d.Close()
+	if err := d.setAutomine(*automine); err != nil {
+		return err
+	}
+	d.setIntervalMining(*interval)
+
+	server, err := d.newRPCServer()
+	if err != nil {
+		return err
+	}
+	defer server.Stop()
+
+	listener, err := net.Listen("tcp", *addr)
+	if err != nil {
+		return err
+	}
+	ws := server.WebsocketHandler([]string{"*"})
+	httpServer := &http.Server{Handler: allowCORS(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.EqualFold(r.Header.Get("Upgrade"), "websocket") {
+			ws.ServeHTTP(w, r)
+			return
+		}
+		server.ServeHTTP(w, r)
+	}))}
+
+	printDevnet(d, cfg, listener.Addr().String())
+	switch {
+	case *automine && *interval > 0:
+		fmt.Printf("mining every transaction as it arrives and a block every %s\n", *interval)
+	case *automine:
+		fmt.Println("mining every transaction as it arrives")
+	case *interval > 0:
+		fmt.Printf("mining a block every %s\n", *interval)
+	default:
+		fmt.Println("mining only on evm_mine")
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	heads := make(chan core.ChainHeadEvent)
+	sub := d.chain.SubscribeChainHeadEvent(heads)
+	defer sub.Unsubscribe()
+	go func() {
+		for {
+			select {
+			case head := <-heads:
+				block := head.Block
+				fmt.Printf("block %d (%s): %d transactions, gas used %d\n", block.NumberU64(),
+					time.Unix(int64(block.Time()), 0).UTC().Format(time.RFC3339), len(block.Transactions()), block.GasUsed())
+			case <-sub.Err():
+				return
+			}
+		}
+	}()
+
+	served := make(chan error, 1)
+	go func() { served <- httpServer.Serve(listener) }()
+	select {
+	case err := <-served:
+		return err
+	case <-ctx.Done():
+	}
+	shutdown, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	if err := httpServer.Shutdown(shutdown); err != nil && !errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return nil
+}
+
+// allowCORS lets browser wallets and dapps served from any origin call the
+// devnet.
+func allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Access-Control-Allow-Origin", "*")
+		if r.Method == http.MethodOptions {
+			w.Header().Set("Access-Control-Allow-Methods", "POST, GET, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Content-Type")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func printDevnet(d *devnet, cfg *devnetConfig, addr string) {
+	fmt.Printf("devnet chain id %s\n", devnetChainID)
+	fmt.Printf("accounts, %s ether each, from mnemonic %q:\n", new(big.Int).Div(cfg.balance, big.NewInt(params.Ether)), cfg.mnemonic)
+	for i, a := range d.accounts {
+		fmt.Printf("  (%d) %s key %s\n", i, a.address.Hex(), hexutil.Encode(crypto.FromECDSA(a.key)))
+	}
+	if d.token != (common.Address{}) {
+		fmt.Printf("EmeraldToken deployed at %s in block %d, supply %s owned by %s\n",
+			d.token.Hex(), d.deployBlock, cfg.supply, d.accounts[0].address.Hex())
+	}
+	fmt.Printf("serving JSON-RPC on http://%s and ws://%s\n", addr, addr)
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"os/signal"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// genreNames follows the order of the Genre enum in EmeraldToken.sol.
+var genreNames = []string{"horror", "romantic", "drama"}
+
+// parseGenre accepts either a genre name or its enum index.
+func parseGenre(value string) (uint8, error) {
+	for i, name := range genreNames {
+		if strings.EqualFold(value, name) {
+			return uint8(i), nil
+		}
+	}
+	if n, err := strconv.ParseUint(value, 10, 8); err == nil && int(n) < len(genreNames) {
+		return uint8(n), nil
+	}
+	return 0, fmt.Errorf("--genre: unknown genre %q (want one of %s)", value, strings.Join(genreNames, ", "))
+}
+
+func genreName(genre uint8) string {
+	if int(genre) < len(genreNames) {
+		return genreNames[genre]
+	}
+	return fmt.Sprintf("genre(%d)", genre)
+}
+
+func filmCommand() *command {
+	return &command{
+		name:    "film",
+		summary: "manage the film catalog",
+		children: []*command{
+			{name: "add", summary: "add or replace a film", run: runFilmAdd},
+			{name: "delete", summary: "delete a film", run: runFilmDelete},
+			{name: "get", summary: "read a film from the contract storage", run: runFilmGet},
+			{name: "events", summary: "list FilmAdded and FilmDeleted events", run: runFilmEvents},
+			{name: "index", summary: "build the local film catalog and follow the chain", run: runFilmIndex},
+			{name: "list", summary: "list the films of the local catalog", run: runFilmList},
+			{name: "diff", summary: "show how the local catalog changed between two blocks", run: runFilmDiff},
+		},
+	}
+}
+
+func runFilmAdd(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald film add")
+	conn.register(fs)
+	conn.registerTx(fs)
+	title := fs.String("title", "", "film title")
+	year := fs.Uint64("year", 0, "release year")
+	genre := fs.String("genre", "", "genre: "+strings.Join(genreNames, ", "))
+	overwrite := fs.Bool("overwrite", false, "replace a film already in the catalog with different data")
+	var idx indexFlags
+	idx.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "title", "year", "genre"); err != nil {
+		return err
+	}
+
+	genreValue, err := parseGenre(*genre)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	tx, err := addFilm(context.Background(), s, &idx, *title, new(big.Int).SetUint64(*year), genreValue, *overwrite, os.Stdout)
+	if err != nil || tx == nil {
+		return err
+	}
+	fmt.Printf("tx add film send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+// addFilm sends the transaction adding title to the catalog. addFilm of the
+// contract silently replaces an existing title, so the catalog is checked
+// first: a film with different data is only replaced with overwrite, and
+// one with the same data is left alone, with no transaction and a nil result.
+func addFilm(ctx context.Context, s *session, idx *indexFlags, title string, year *big.Int, genre uint8, overwrite bool, w io.Writer) (*types.Transaction, error) {
+	existing, err := currentFilm(ctx, s, idx, title, w)
+	if err != nil {
+		return nil, fmt.Errorf("checking the catalog for %q: %w", title, err)
+	}
+	if existing != nil && !existing.Deleted {
+		entry := fmt.Sprintf("year=%s genre=%s (block %d tx %s)", existing.Year, genreName(existing.Genre), existing.Block, existing.TxHash.Hex())
+		switch {
+		case existing.Year.Cmp(year) == 0 && existing.Genre == genre:
+			fmt.Fprintf(w, "%q is already in the catalog with the same data, nothing to send: %s\n", title, entry)
+			return nil, nil
+		case !overwrite:
+			fmt.Fprintf(w, "%q is already in the catalog: %s\n", title, entry)
+			return nil, fmt.Errorf("refusing to overwrite %q; pass --overwrite to replace it", title)
+		default:
+			fmt.Fprintf(w, "overwriting %q: %s\n", title, entry)
+		}
+	}
+
+	intent := fmt.Sprintf("film add --title %q --year %s --genre %s", title, year, genreName(genre))
+	return s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.AddFilm(auth, title, year, genre)
+	})
+}
+
+func runFilmDelete(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald film delete")
+	conn.register(fs)
+	conn.registerTx(fs)
+	title := fs.String("title", "", "film title")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "title"); err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := fmt.Sprintf("film delete --title %q", *title)
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.DeleteFilm(auth, *title)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx delete film send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+func runFilmGet(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald film get")
+	conn.register(fs)
+	var block blockFlags
+	block.register(fs)
+	title := fs.String("title", "", "film title")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "title"); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	opts, err := block.callOpts(ctx, s.client, os.Stderr)
+	if err != nil {
+		return err
+	}
+	storage := &filmStorage{reader: s.client, address: s.address}
+	f, err := storage.film(ctx, *title, opts.BlockNumber)
+	if err != nil {
+		return err
+	}
+	if f == nil {
+		fmt.Printf("%q is not in the catalog\n", *title)
+		return nil
+	}
+	fmt.Printf("%q year=%s genre=%s\n", f.Title, f.Year, genreName(f.Genre))
+	return nil
+}
+
+func runFilmEvents(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald film events")
+	conn.register(fs)
+	conn.registerLogFlags(fs)
+	fromBlock := fs.Int64("from-block", -1, "first block to scan (default: the profile's deploy_block)")
+	toBlock := fs.Uint64("to-block", 0, "last block to scan (default: latest)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ctx := context.Background()
+	start := s.network.DeployBlock
+	if *fromBlock >= 0 {
+		start = uint64(*fromBlock)
+	}
+	end := *toBlock
+	if end == 0 {
+		if end, err = s.client.BlockNumber(ctx); err != nil {
+			return err
+		}
+	}
+
+	films := &filmConsumer{filterer: &s.token.MainFilterer, address: s.address, out: os.Stdout}
+	return s.logs.run(ctx, films.query(), start, end, func(_, _ uint64, logs []types.Log) error {
+		for _, log := range logs {
+			c, err := parseFilmLog(films.filterer, log)
+			if err != nil {
+				return err
+			}
+			films.report(c)
+		}
+		return nil
+	})
+}
+
+func runFilmIndex(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald film index")
+	conn.register(fs)
+	conn.registerLogFlags(fs)
+	var idx indexFlags
+	idx.register(fs)
+	fromBlock := fs.Int64("from-block", -1, "block to start the first backfill at (default: the profile's deploy_block)")
+	follow := fs.Bool("follow", true, "keep following the chain head after the backfill")
+	poll := fs.Duration("poll", 5*time.Second, "how often to poll for new blocks while following")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ix, err := idx.open(false)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	if err := ix.bindSource(s.chainID.Uint64(), s.address); err != nil {
+		return err
+	}
+
+	start := s.network.DeployBlock
+	if *fromBlock >= 0 {
+		start = uint64(*fromBlock)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	indexer := newFilmIndexer(ix, s, os.Stdout)
+	if err := indexer.sync(ctx, start); err != nil {
+		return err
+	}
+	if !*follow {
+		return nil
+	}
+	return indexer.follow(ctx, *poll)
+}
+
+func runFilmList(args []string) error {
+	fs := newFlagSet("emerald film list")
+	var idx indexFlags
+	idx.register(fs)
+	deleted := fs.Bool("deleted", false, "include deleted titles")
+	atBlock := fs.Int64("at-block", -1, "show the catalog as it stood at this block (default: the latest)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+
+	var films []*filmRecord
+	if *atBlock >= 0 {
+		at, err := ix.indexedBlock(filmsCheckpoint, *atBlock)
+		if err != nil {
+			return err
+		}
+		catalog, err := ix.catalogAt(at)
+		if err != nil {
+			return err
+		}
+		for _, r := range catalog {
+			if *deleted || !r.Deleted {
+				films = append(films, r)
+			}
+		}
+		sort.Slice(films, func(i, j int) bool { return films[i].Title < films[j].Title })
+		printFilms(films)
+		fmt.Printf("%d titles at block %d\n", len(films), at)
+		return nil
+	}
+
+	films, err = ix.films(*deleted)
+	if err != nil {
+		return err
+	}
+	next, _, err := ix.checkpoint(filmsCheckpoint)
+	if err != nil {
+		return err
+	}
+	printFilms(films)
+	if next > 0 {
+		fmt.Printf("%d titles, indexed up to block %d\n", len(films), next-1)
+	}
+	return nil
+}
+
+func printFilms(films []*filmRecord) {
+	for _, f := range films {
+		state := fmt.Sprintf("year=%s genre=%s", f.Year, genreName(f.Genre))
+		if f.Deleted {
+			state = "deleted"
+		}
+		fmt.Printf("%q %s (block %d tx %s)\n", f.Title, state, f.Block, f.TxHash.Hex())
+	}
+}
+
+func runFilmDiff(args []string) error {
+	fs := newFlagSet("emerald film diff")
+	var idx indexFlags
+	idx.register(fs)
+	from := fs.Int64("from", -1, "first block of the window")
+	to := fs.Int64("to", -1, "last block of the window (default: the last indexed one)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *from < 0 {
+		fmt.Fprintln(fs.Output(), "flag --from is required")
+		fs.Usage()
+		return errUsage
+	}
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+
+	end, err := ix.indexedBlock(filmsCheckpoint, *to)
+	if err != nil {
+		return err
+	}
+	start := uint64(*from)
+	if start > end {
+		return fmt.Errorf("--from %d is after --to %d", start, end)
+	}
+	before := map[string]*filmRecord{}
+	if start > 0 {
+		if before, err = ix.catalogAt(start - 1); err != nil {
+			return err
+		}
+	}
+	events, err := ix.filmEvents(start, end)
+	if err != nil {
+		return err
+	}
+	diffs := diffFilmEvents(before, events)
+	for _, d := range diffs {
+		fmt.Println(d)
+	}
+	fmt.Printf("%d changes in blocks %d..%d\n", len(diffs), start, end)
+	return nil
+}
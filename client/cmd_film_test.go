@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"math/big"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// rangeRecorder remembers the first block of every log query.
+type rangeRecorder struct {
+	logFilterer
+	from []uint64
+}
+
+func (r *rangeRecorder) FilterLogs(ctx context.Context, q ethereum.FilterQuery) ([]types.Log, error) {
+	r.from = append(r.from, q.FromBlock.Uint64())
+	return r.logFilterer.FilterLogs(ctx, q)
+}
+
+// mustAddFilm adds a film through addFilm and waits for it to be mined.
+func mustAddFilm(t *testing.T, s *session, idx *indexFlags, title string, year int64, overwrite bool) {
+	t.Helper()
+	tx, err := addFilm(context.Background(), s, idx, title, big.NewInt(year), 0, overwrite, &bytes.Buffer{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx == nil {
+		t.Fatalf("adding %q sent no transaction", title)
+	}
+	if _, err := s.confirm(tx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestFilmAddRequiresYear(t *testing.T) {
+	err := runFilmAdd([]string{"--title", "Alien", "--genre", "horror"})
+	if !errors.Is(err, errUsage) {
+		t.Errorf("film add without --year = %v, want a usage error", err)
+	}
+}
+
+func TestAddFilm(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[1].key)
+	// There is no index, so the catalog is scanned from the deploy block.
+	idx := &indexFlags{kind: storeBolt, path: filepath.Join(t.TempDir(), "missing.db")}
+	ctx := context.Background()
+
+	mustAddFilm(t, s, idx, "Alien", 1979, false)
+	nonce, err := s.client.PendingNonceAt(ctx, s.from())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out bytes.Buffer
+	tx, err := addFilm(ctx, s, idx, "Alien", big.NewInt(1979), 0, false, &out)
+	if err != nil || tx != nil {
+		t.Fatalf("adding the same film again = %v, %v; want nothing sent", tx, err)
+	}
+	if !strings.Contains(out.String(), "nothing to send") {
+		t.Errorf("same-data add printed\n%s", out.String())
+	}
+
+	out.Reset()
+	tx, err = addFilm(ctx, s, idx, "Alien", big.NewInt(1986), 0, false, &out)
+	if err == nil || !strings.Contains(err.Error(), "--overwrite") || tx != nil {
+		t.Fatalf("overwriting without --overwrite = %v, %v; want a refusal", tx, err)
+	}
+	if !strings.Contains(out.String(), "year=1979") {
+		t.Errorf("refusal does not show the existing entry:\n%s", out.String())
+	}
+
+	if after, err := s.client.PendingNonceAt(ctx, s.from()); err != nil || after != nonce {
+		t.Fatalf("pending nonce went from %d to %d (%v); want no transaction sent", nonce, after, err)
+	}
+
+	mustAddFilm(t, s, idx, "Alien", 1986, true)
+	current, err := currentFilm(ctx, s, idx, "Alien", &out)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current == nil || current.Year.Int64() != 1986 {
+		t.Errorf("Alien after --overwrite = %+v, want year 1986", current)
+	}
+}
+
+// TestCurrentFilmIndexTail checks that currentFilm takes the catalog from the
+// index and only scans the blocks after its checkpoint.
+func TestCurrentFilmIndexTail(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[1].key)
+	idx := &indexFlags{kind: storeBolt, path: filepath.Join(t.TempDir(), "index.db")}
+	ctx := context.Background()
+
+	mustAddFilm(t, s, idx, "Alien", 1979, false)
+	mustAddFilm(t, s, idx, "Brazil", 1985, false)
+
+	ix, err := idx.open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.bindSource(s.chainID.Uint64(), s.address); err != nil {
+		t.Fatal(err)
+	}
+	if err := newFilmIndexer(ix, s, &bytes.Buffer{}).sync(ctx, s.network.DeployBlock); err != nil {
+		t.Fatal(err)
+	}
+	checkpoint, _, err := ix.checkpoint(filmsCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	mustAddFilm(t, s, idx, "Brazil", 1986, true)
+
+	recorder := &rangeRecorder{logFilterer: s.logs.client}
+	s.logs = newLogBackfill(recorder, 0, 0)
+	var out bytes.Buffer
+	for _, tc := range []struct {
+		title string
+		year  int64
+	}{
+		{"Alien", 1979},  // only in the index
+		{"Brazil", 1986}, // overwritten after the checkpoint
+		{"Dune", 0},
+	} {
+		recorder.from = nil
+		current, err := currentFilm(ctx, s, idx, tc.title, &out)
+		switch {
+		case err != nil:
+			t.Fatal(err)
+		case tc.year == 0 && current != nil:
+			t.Errorf("%s = %+v, want none", tc.title, current)
+		case tc.year != 0 && (current == nil || current.Year.Int64() != tc.year):
+			t.Errorf("%s = %+v, want year %d", tc.title, current, tc.year)
+		}
+		for _, from := range recorder.from {
+			if from < checkpoint {
+				t.Errorf("looking up %s scanned from block %d, before the checkpoint %d", tc.title, from, checkpoint)
+			}
+		}
+	}
+	if out.Len() != 0 {
+		t.Errorf("currentFilm fell back to a full scan:\n%s", out.String())
+	}
+}
@@ -0,0 +1,30 @@
+package main
+
+import (
+	"fmt"
+	"os"
+)
+
+func gasCommand() *command {
+	return &command{
+		name:    "gas",
+		summary: "measure the gas the contract uses",
+		children: []*command{
+			{name: "snapshot", summary: "run the gas scenarios on a simulated chain and compare with the snapshot", run: runGasSnapshot},
+		},
+	}
+}
+
+func runGasSnapshot(args []string) error {
+	fs := newFlagSet("emerald gas snapshot")
+	file := fs.String("file", defaultGasSnapshot, "snapshot file")
+	tolerance := fs.Float64("tolerance", 1, "growth in percent a scenario may show before the check fails")
+	update := fs.Bool("update", false, "rewrite the snapshot with the measured gas instead of comparing")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *tolerance < 0 {
+		return fmt.Errorf("--tolerance: cannot be negative, got %g", *tolerance)
+	}
+	return checkGasSnapshot(os.Stdout, *file, *tolerance, *update)
+}
@@ -0,0 +1,211 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"os"
+	"os/signal"
+	"sort"
+	"syscall"
+	"time"
+)
+
+func ledgerCommand() *command {
+	return &command{
+		name:    "ledger",
+		summary: "index EMD transfers and query balances offline",
+		children: []*command{
+			{name: "index", summary: "index Transfer and Approval events and follow the chain", run: runLedgerIndex},
+			{name: "balance", summary: "show an indexed balance", run: runLedgerBalance},
+			{name: "allowance", summary: "show an indexed allowance", run: runLedgerAllowance},
+			{name: "holders", summary: "list the indexed balances", run: runLedgerHolders},
+		},
+	}
+}
+
+func runLedgerIndex(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald ledger index")
+	conn.register(fs)
+	conn.registerLogFlags(fs)
+	var idx indexFlags
+	idx.register(fs)
+	fromBlock := fs.Int64("from-block", -1, "block to start the first backfill at (default: the profile's deploy_block)")
+	follow := fs.Bool("follow", true, "keep following the chain head after the backfill")
+	poll := fs.Duration("poll", 5*time.Second, "how often to poll for new blocks while following")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ix, err := idx.open(false)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	if err := ix.bindSource(s.chainID.Uint64(), s.address); err != nil {
+		return err
+	}
+
+	start := s.network.DeployBlock
+	if *fromBlock >= 0 {
+		start = uint64(*fromBlock)
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	indexer := newLedgerIndexer(ix, s, os.Stdout)
+	if err := indexer.sync(ctx, start); err != nil {
+		return err
+	}
+	if !*follow {
+		return nil
+	}
+	return indexer.follow(ctx, *poll)
+}
+
+func runLedgerBalance(args []string) error {
+	fs := newFlagSet("emerald ledger balance")
+	var idx indexFlags
+	idx.register(fs)
+	address := fs.String("address", "", "account to query")
+	block := fs.Int64("block", -1, "block to query (default: the last indexed one)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "address"); err != nil {
+		return err
+	}
+	account, err := parseAddress("address", *address)
+	if err != nil {
+		return err
+	}
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+
+	at, err := ix.indexedBlock(ledgerCheckpoint, *block)
+	if err != nil {
+		return err
+	}
+	var p *balancePoint
+	err = ix.view(func(tx storeTx) error {
+		p, err = tx.balance(account, at)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		fmt.Printf("%s: 0 at block %d\n", account.Hex(), at)
+		return nil
+	}
+	fmt.Printf("%s: %s at block %d (last changed at block %d)\n", account.Hex(), p.Value, at, p.Block)
+	return nil
+}
+
+func runLedgerAllowance(args []string) error {
+	fs := newFlagSet("emerald ledger allowance")
+	var idx indexFlags
+	idx.register(fs)
+	owner := fs.String("owner", "", "owner address")
+	spender := fs.String("spender", "", "spender address")
+	block := fs.Int64("block", -1, "block to query (default: the last indexed one)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "owner", "spender"); err != nil {
+		return err
+	}
+	ownerAddress, err := parseAddress("owner", *owner)
+	if err != nil {
+		return err
+	}
+	spenderAddress, err := parseAddress("spender", *spender)
+	if err != nil {
+		return err
+	}
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+
+	at, err := ix.indexedBlock(ledgerCheckpoint, *block)
+	if err != nil {
+		return err
+	}
+	var p *allowancePoint
+	err = ix.view(func(tx storeTx) error {
+		p, err = tx.allowance(ownerAddress, spenderAddress, at)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if p == nil {
+		fmt.Printf("%s -> %s: 0 at block %d\n", ownerAddress.Hex(), spenderAddress.Hex(), at)
+		return nil
+	}
+	fmt.Printf("%s -> %s: %s at block %d (last changed at block %d)\n", ownerAddress.Hex(), spenderAddress.Hex(), p.Value, at, p.Block)
+	return nil
+}
+
+func runLedgerHolders(args []string) error {
+	fs := newFlagSet("emerald ledger holders")
+	var idx indexFlags
+	idx.register(fs)
+	block := fs.Int64("block", -1, "block to query (default: the last indexed one)")
+	zero := fs.Bool("zero", false, "include accounts whose balance went back to zero")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+
+	at, err := ix.indexedBlock(ledgerCheckpoint, *block)
+	if err != nil {
+		return err
+	}
+	var points []*balancePoint
+	err = ix.view(func(tx storeTx) error {
+		points, err = tx.balances(at)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+
+	sort.Slice(points, func(i, j int) bool {
+		if c := points[i].Value.Cmp(points[j].Value); c != 0 {
+			return c > 0
+		}
+		return points[i].Account.Hex() < points[j].Account.Hex()
+	})
+	total, holders := new(big.Int), 0
+	for _, p := range points {
+		total.Add(total, p.Value)
+		if p.Value.Sign() == 0 && !*zero {
+			continue
+		}
+		holders++
+		fmt.Printf("%s %s\n", p.Account.Hex(), p.Value)
+	}
+	fmt.Printf("%d holders, %s in total at block %d\n", holders, total, at)
+	return nil
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func ownerCommand() *command {
+	return &command{
+		name:    "owner",
+		summary: "inspect and change contract ownership",
+		children: []*command{
+			{name: "show", summary: "show the owner, now or at a past block", run: runOwnerShow},
+			{name: "transfer", summary: "transfer ownership to another account", run: runOwnerTransfer},
+			{name: "renounce", summary: "renounce ownership, leaving the contract without an owner", run: runOwnerRenounce},
+		},
+	}
+}
+
+func runOwnerShow(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald owner show")
+	conn.register(fs)
+	var block blockFlags
+	block.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	opts, err := block.callOpts(context.Background(), s.client, os.Stderr)
+	if err != nil {
+		return err
+	}
+	owner, err := s.token.Owner(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(owner.Hex())
+	return nil
+}
+
+func runOwnerTransfer(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald owner transfer")
+	conn.register(fs)
+	conn.registerTx(fs)
+	newOwner := fs.String("new-owner", "", "address of the new owner")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "new-owner"); err != nil {
+		return err
+	}
+
+	account, err := parseAddress("new-owner", *newOwner)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := fmt.Sprintf("owner transfer --new-owner %s", account.Hex())
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.TransferOwnership(auth, account)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx transfer ownership send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+func runOwnerRenounce(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald owner renounce")
+	conn.register(fs)
+	conn.registerTx(fs)
+	confirm := fs.Bool("yes", false, "confirm that the contract should be left without an owner")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if !*confirm {
+		fmt.Fprintln(fs.Output(), "renouncing ownership is irreversible; pass --yes to confirm")
+		return errUsage
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := "owner renounce"
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.RenounceOwnership(auth)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx renounce ownership send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
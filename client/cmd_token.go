@@ -0,0 +1,255 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+func tokenCommand() *command {
+	return &command{
+		name:    "token",
+		summary: "read and move EMD balances",
+		children: []*command{
+			{name: "balance", summary: "show the balance of an address", run: runTokenBalance},
+			{name: "transfer", summary: "transfer tokens to an address", run: runTokenTransfer},
+			{name: "approve", summary: "set the allowance of a spender", run: runTokenApprove},
+			{name: "allowance", summary: "show the allowance of a spender", run: runTokenAllowance},
+			{name: "mint", summary: "mint new tokens (owner only)", run: runTokenMint},
+			{name: "total-supply", summary: "show the total supply", run: runTokenTotalSupply},
+		},
+	}
+}
+
+func runTokenBalance(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token balance")
+	conn.register(fs)
+	var block blockFlags
+	block.register(fs)
+	address := fs.String("address", "", "account to query (default: the signing account)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(*address == "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var account common.Address
+	if *address == "" {
+		account = s.from()
+	} else if account, err = parseAddress("address", *address); err != nil {
+		return err
+	}
+
+	opts, err := block.callOpts(context.Background(), s.client, os.Stderr)
+	if err != nil {
+		return err
+	}
+	balance, err := s.token.BalanceOf(opts, account)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s: %s\n", account.Hex(), balance)
+	return nil
+}
+
+func runTokenTransfer(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token transfer")
+	conn.register(fs)
+	conn.registerTx(fs)
+	to := fs.String("to", "", "recipient address")
+	amount := fs.String("amount", "", "amount in base units")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "to", "amount"); err != nil {
+		return err
+	}
+
+	recipient, err := parseAddress("to", *to)
+	if err != nil {
+		return err
+	}
+	value, err := parseAmount("amount", *amount)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := fmt.Sprintf("token transfer --to %s --amount %s", recipient.Hex(), value)
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, recipient, value)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx transfer send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+func runTokenApprove(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token approve")
+	conn.register(fs)
+	conn.registerTx(fs)
+	spender := fs.String("spender", "", "spender address")
+	amount := fs.String("amount", "", "allowance in base units")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "spender", "amount"); err != nil {
+		return err
+	}
+
+	spenderAddress, err := parseAddress("spender", *spender)
+	if err != nil {
+		return err
+	}
+	value, err := parseAmount("amount", *amount)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := fmt.Sprintf("token approve --spender %s --amount %s", spenderAddress.Hex(), value)
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Approve(auth, spenderAddress, value)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx approve send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+func runTokenAllowance(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token allowance")
+	conn.register(fs)
+	var block blockFlags
+	block.register(fs)
+	owner := fs.String("owner", "", "token owner address (default: the signing account)")
+	spender := fs.String("spender", "", "spender address")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "spender"); err != nil {
+		return err
+	}
+
+	spenderAddress, err := parseAddress("spender", *spender)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(*owner == "")
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	var ownerAddress common.Address
+	if *owner == "" {
+		ownerAddress = s.from()
+	} else if ownerAddress, err = parseAddress("owner", *owner); err != nil {
+		return err
+	}
+
+	opts, err := block.callOpts(context.Background(), s.client, os.Stderr)
+	if err != nil {
+		return err
+	}
+	allowance, err := s.token.Allowance(opts, ownerAddress, spenderAddress)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("%s -> %s: %s\n", ownerAddress.Hex(), spenderAddress.Hex(), allowance)
+	return nil
+}
+
+func runTokenMint(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token mint")
+	conn.register(fs)
+	conn.registerTx(fs)
+	to := fs.String("to", "", "account receiving the minted tokens")
+	amount := fs.String("amount", "", "amount in base units")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "to", "amount"); err != nil {
+		return err
+	}
+
+	account, err := parseAddress("to", *to)
+	if err != nil {
+		return err
+	}
+	value, err := parseAmount("amount", *amount)
+	if err != nil {
+		return err
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	intent := fmt.Sprintf("token mint --to %s --amount %s", account.Hex(), value)
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Mint(auth, account, value)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Printf("tx mint send: %s\n", tx.Hash().Hex())
+	return s.report(tx)
+}
+
+func runTokenTotalSupply(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald token total-supply")
+	conn.register(fs)
+	var block blockFlags
+	block.register(fs)
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	opts, err := block.callOpts(context.Background(), s.client, os.Stderr)
+	if err != nil {
+		return err
+	}
+	supply, err := s.token.TotalSupply(opts)
+	if err != nil {
+		return err
+	}
+	fmt.Println(supply)
+	return nil
+}
@@ -0,0 +1,138 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+func txCommand() *command {
+	return &command{
+		name:    "tx",
+		summary: "manage sent transactions",
+		children: []*command{
+			{name: "speedup", summary: "resend a pending transaction with higher fees", run: runTxSpeedup},
+			{name: "cancel", summary: "replace a pending transaction with a zero-value self-transfer", run: runTxCancel},
+			{name: "journal", summary: "list journaled transactions", run: runTxJournal},
+		},
+	}
+}
+
+func runTxSpeedup(args []string) error {
+	return runTxReplace("emerald tx speedup", args, false)
+}
+
+func runTxCancel(args []string) error {
+	return runTxReplace("emerald tx cancel", args, true)
+}
+
+func runTxReplace(path string, args []string, cancel bool) error {
+	var conn connFlags
+	fs := newFlagSet(path)
+	conn.register(fs)
+	conn.registerTx(fs)
+	hash := fs.String("hash", "", "hash of the pending transaction")
+	bump := fs.Uint64("bump", 2*minPriceBump, "fee increase in percent over the pending transaction")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if err := requireFlags(fs, "hash"); err != nil {
+		return err
+	}
+	if !isHash(*hash) {
+		return fmt.Errorf("--hash: invalid transaction hash %q", *hash)
+	}
+
+	s, err := conn.open(true)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	sent, err := s.replace(context.Background(), common.HexToHash(*hash), cancel, *bump)
+	if errors.Is(err, errAlreadyMined) {
+		fmt.Printf("tx %s is no longer pending\n", *hash)
+		return s.reportAny(sent)
+	}
+	if len(sent) > 1 {
+		fmt.Printf("tx replacement send: %s (nonce %d)\n", sent[len(sent)-1].Hash().Hex(), sent[0].Nonce())
+	}
+	if err != nil {
+		return err
+	}
+	return s.reportAny(sent)
+}
+
+func runTxJournal(args []string) error {
+	fs := newFlagSet("emerald tx journal")
+	path := fs.String("journal", "", "transaction journal file (default $EMERALD_JOURNAL or "+defaultJournalPath+")")
+	all := fs.Bool("all", false, "include mined, dropped and replaced transactions")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+	if *path == "" {
+		*path = os.Getenv("EMERALD_JOURNAL")
+	}
+	if *path == "" {
+		*path = defaultJournalPath
+	}
+
+	j, err := openJournal(*path)
+	if err != nil {
+		return err
+	}
+	defer j.Close()
+
+	entries, err := j.entries(func(e *journalEntry) bool { return *all || !e.State.final() })
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		fmt.Printf("%d\t%s\tchain %d\t%s nonce %d\t%s\t%s\n",
+			e.ID, e.State, e.ChainID, e.From.Hex(), e.Nonce, e.Hash.Hex(), e.Intent)
+	}
+	return nil
+}
+
+func isHash(s string) bool {
+	b, err := hexutil.Decode(s)
+	return err == nil && len(b) == common.HashLength
+}
+
+// reportAny waits for whichever of txs gets mined and prints it.
+func (s *session) reportAny(txs []*types.Transaction) error {
+	if !s.wait {
+		return nil
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	tx, receipt, err := waitAnyMined(ctx, s.client, txs, s.confirmations)
+	if err != nil {
+		return txerr.Classify(err)
+	}
+	if tx.Hash() == txs[0].Hash() {
+		fmt.Printf("original tx %s was mined\n", tx.Hash().Hex())
+	} else {
+		fmt.Printf("replacement tx %s was mined\n", tx.Hash().Hex())
+	}
+	if err := s.journalMined(tx, receipt, txs); err != nil {
+		return err
+	}
+	if err := checkReceipt(ctx, s.client, tx, receipt); err != nil {
+		return txerr.Classify(err)
+	}
+	events, err := decodeEvents(&s.token.MainFilterer, s.address, receipt)
+	if err != nil {
+		return err
+	}
+	printResult(os.Stdout, &txResult{Tx: tx, Receipt: receipt, Events: events})
+	return nil
+}
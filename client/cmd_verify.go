@@ -0,0 +1,145 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+)
+
+func verifyCommand() *command {
+	return &command{
+		name:    "verify",
+		summary: "check indexed data against the chain",
+		children: []*command{
+			{name: "ledger", summary: "check the indexed ledger against TotalSupply and BalanceOf", run: runVerifyLedger},
+			{name: "films", summary: "check the indexed catalog against the contract storage", run: runVerifyFilms},
+		},
+	}
+}
+
+func runVerifyLedger(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald verify ledger")
+	conn.register(fs)
+	var idx indexFlags
+	idx.register(fs)
+	block := fs.Int64("block", -1, "block to check (default: the last indexed one)")
+	fromBlock := fs.Int64("from-block", -1, "first indexed block, where divergence searches start (default: the profile's deploy_block)")
+	samples := fs.Int("samples", 20, "accounts to spot-check against BalanceOf")
+	seed := fs.Int64("seed", 0, "seed of the account sample (default: random)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	// Balances of another chain or contract would all look diverged.
+	if err := ix.checkSource(s.chainID.Uint64(), s.address); err != nil {
+		return err
+	}
+
+	at, err := ix.indexedBlock(ledgerCheckpoint, *block)
+	if err != nil {
+		return err
+	}
+	from := s.network.DeployBlock
+	if *fromBlock >= 0 {
+		from = uint64(*fromBlock)
+	}
+	if from > at {
+		return fmt.Errorf("--from-block %d is after the checked block %d", from, at)
+	}
+	var transfers []*transferRecord
+	err = ix.view(func(tx storeTx) error {
+		transfers, err = tx.transfers(0, at)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if *seed == 0 {
+		*seed = time.Now().UnixNano()
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	v := &ledgerVerifier{
+		ix:      ix,
+		replay:  replayLedger(transfers),
+		token:   s.token,
+		from:    from,
+		at:      at,
+		samples: *samples,
+		rng:     rand.New(rand.NewSource(*seed)),
+		out:     os.Stdout,
+	}
+	first, err := v.run(ctx)
+	if err != nil {
+		return err
+	}
+	if first != nil {
+		return fmt.Errorf("ledger diverges from the chain at block %d (sample seed %d)", *first, *seed)
+	}
+	fmt.Printf("ledger verified at block %d (sample seed %d)\n", at, *seed)
+	return nil
+}
+
+func runVerifyFilms(args []string) error {
+	var conn connFlags
+	fs := newFlagSet("emerald verify films")
+	conn.register(fs)
+	var idx indexFlags
+	idx.register(fs)
+	block := fs.Int64("block", -1, "block to check (default: the last indexed one)")
+	if err := parseFlags(fs, args); err != nil {
+		return err
+	}
+
+	s, err := conn.open(false)
+	if err != nil {
+		return err
+	}
+	defer s.Close()
+
+	ix, err := idx.open(true)
+	if err != nil {
+		return err
+	}
+	defer ix.Close()
+	// Another contract's storage would not match the indexed catalog.
+	if err := ix.checkSource(s.chainID.Uint64(), s.address); err != nil {
+		return err
+	}
+
+	at, err := ix.indexedBlock(filmsCheckpoint, *block)
+	if err != nil {
+		return err
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	storage := &filmStorage{reader: s.client, address: s.address}
+	differ, err := verifyFilms(ctx, ix, storage, at, os.Stdout)
+	if err != nil {
+		return err
+	}
+	if len(differ) > 0 {
+		return fmt.Errorf("%d indexed films differ from the contract storage at block %d", len(differ), at)
+	}
+	return nil
+}
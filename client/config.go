@@ -0,0 +1,109 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/common"
+	"gopkg.in/yaml.v3"
+)
+
+// defaultConfigPath is used when neither --config nor $EMERALD_CONFIG is set.
+const defaultConfigPath = "networks.yaml"
+
+// tokenContract is the key of the EmeraldToken deployment in a profile's
+// contracts section.
+const tokenContract = "emerald"
+
+// networkProfile describes one deployment of the contracts. String values may
+// reference environment variables as $VAR or ${VAR}, so secrets such as RPC
+// API keys can stay in .env.
+type networkProfile struct {
+	RPC           string            `yaml:"rpc"`
+	ChainID       uint64            `yaml:"chain_id"`
+	Contracts     map[string]string `yaml:"contracts"`
+	Confirmations uint64            `yaml:"confirmations"`
+	// DeployBlock is the block the contracts were deployed in. Indexers
+	// start their backfill there, since no event can be older.
+	DeployBlock uint64 `yaml:"deploy_block"`
+}
+
+// config is the parsed network configuration file.
+type config struct {
+	Default  string                     `yaml:"default"`
+	Networks map[string]*networkProfile `yaml:"networks"`
+}
+
+func loadConfig(path string) (*config, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var cfg config
+	if err := yaml.Unmarshal(data, &cfg); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	for name, profile := range cfg.Networks {
+		if err := profile.validate(); err != nil {
+			return nil, fmt.Errorf("%s: network %q: %w", path, name, err)
+		}
+	}
+	return &cfg, nil
+}
+
+func (p *networkProfile) validate() error {
+	if p == nil {
+		return errors.New("empty profile")
+	}
+	if p.RPC == "" {
+		return errors.New("rpc is required")
+	}
+	if p.ChainID == 0 {
+		return errors.New("chain_id is required")
+	}
+	return nil
+}
+
+// profile returns the named network, or the default one when name is empty.
+func (c *config) profile(name string) (*networkProfile, error) {
+	if name == "" {
+		name = c.Default
+	}
+	if name == "" {
+		return nil, errors.New("no network selected: pass --network or set default in the config file")
+	}
+	profile, ok := c.Networks[name]
+	if !ok {
+		return nil, fmt.Errorf("unknown network %q (configured: %s)", name, strings.Join(c.names(), ", "))
+	}
+	return profile, nil
+}
+
+func (c *config) names() []string {
+	names := make([]string, 0, len(c.Networks))
+	for name := range c.Networks {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func (p *networkProfile) endpoint() string {
+	return os.ExpandEnv(p.RPC)
+}
+
+// contract returns the address of the named contract in this deployment.
+func (p *networkProfile) contract(name string) (common.Address, error) {
+	value := os.ExpandEnv(p.Contracts[name])
+	if value == "" {
+		return common.Address{}, fmt.Errorf("no %q contract configured for this network", name)
+	}
+	if !common.IsHexAddress(value) {
+		return common.Address{}, fmt.Errorf("contract %q: invalid address %q", name, value)
+	}
+	return common.HexToAddress(value), nil
+}
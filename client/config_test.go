@@ -0,0 +1,191 @@
+package main
+
+import (
+	"errors"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+const testConfig = `
+default: devnet
+
+networks:
+  devnet:
+    rpc: http://127.0.0.1:8545
+    chain_id: 1337
+    contracts:
+      emerald: "0x5FbDB2315678afecb367f032d93F642f64180aa3"
+    deploy_block: 1
+
+  staging:
+    rpc: https://rpc.example.org/v3/${TEST_EMERALD_API_KEY}
+    chain_id: 5
+    contracts:
+      emerald: $TEST_EMERALD_CONTRACT
+    confirmations: 2
+`
+
+// writeConfig writes a config file with content and returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "networks.yaml")
+	if err := os.WriteFile(path, []byte(content), 0o600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestConnFlagsProfile(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	t.Setenv("EMERALD_NETWORK", "")
+	for _, tc := range []struct {
+		name    string
+		flags   connFlags
+		env     string // $EMERALD_NETWORK
+		network string
+		chainID uint64
+	}{
+		{name: "default", flags: connFlags{config: path}, network: "devnet", chainID: 1337},
+		{name: "flag", flags: connFlags{config: path, network: "staging"}, network: "staging", chainID: 5},
+		{name: "environment", flags: connFlags{config: path}, env: "staging", network: "staging", chainID: 5},
+		{name: "flag over environment", flags: connFlags{config: path, network: "devnet"}, env: "staging", network: "devnet", chainID: 1337},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("EMERALD_NETWORK", tc.env)
+			name, profile, err := tc.flags.profile()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if name != tc.network || profile.ChainID != tc.chainID {
+				t.Errorf("selected %q on chain %d, want %q on chain %d", name, profile.ChainID, tc.network, tc.chainID)
+			}
+		})
+	}
+}
+
+func TestConnFlagsOverrides(t *testing.T) {
+	path := writeConfig(t, testConfig)
+	flags := connFlags{config: path, network: "devnet", rpc: "http://node:8545", contract: "0x000000000000000000000000000000000000000e"}
+	_, profile, err := flags.profile()
+	if err != nil {
+		t.Fatal(err)
+	}
+	address, err := profile.contract(tokenContract)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if profile.endpoint() != "http://node:8545" || address != common.HexToAddress("0xe") || profile.DeployBlock != 1 {
+		t.Errorf("overridden profile %+v", profile)
+	}
+
+	cfg, err := loadConfig(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p := cfg.Networks["devnet"]; p.RPC != "http://127.0.0.1:8545" || p.Contracts[tokenContract] != "0x5FbDB2315678afecb367f032d93F642f64180aa3" {
+		t.Errorf("the overrides changed the loaded profile: %+v", p)
+	}
+}
+
+func TestProfileExpandsEnvironment(t *testing.T) {
+	cfg, err := loadConfig(writeConfig(t, testConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	staging, err := cfg.profile("staging")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	t.Setenv("TEST_EMERALD_API_KEY", "secret")
+	t.Setenv("TEST_EMERALD_CONTRACT", "0x95E72Ebd9F722e0F6AD5fcd3a29F446B7fDf7e5f")
+	if got, want := staging.endpoint(), "https://rpc.example.org/v3/secret"; got != want {
+		t.Errorf("endpoint = %s, want %s", got, want)
+	}
+	address, err := staging.contract(tokenContract)
+	if err != nil || address != common.HexToAddress("0x95E72Ebd9F722e0F6AD5fcd3a29F446B7fDf7e5f") {
+		t.Errorf("contract = %s, %v; want the address from the environment", address.Hex(), err)
+	}
+
+	t.Setenv("TEST_EMERALD_CONTRACT", "")
+	if _, err := staging.contract(tokenContract); err == nil || !strings.Contains(err.Error(), "no \"emerald\" contract") {
+		t.Errorf("contract with the variable unset returned %v", err)
+	}
+	t.Setenv("TEST_EMERALD_CONTRACT", "not an address")
+	if _, err := staging.contract(tokenContract); err == nil || !strings.Contains(err.Error(), "invalid address") {
+		t.Errorf("contract with an invalid address returned %v", err)
+	}
+}
+
+func TestConfigErrors(t *testing.T) {
+	for _, tc := range []struct {
+		name    string
+		config  string
+		network string
+		want    string
+	}{
+		{"unknown network", testConfig, "mainnet", `unknown network "mainnet" (configured: devnet, staging)`},
+		{"no default", "networks:\n  devnet:\n    rpc: http://127.0.0.1:8545\n    chain_id: 1337\n", "", "no network selected"},
+		{"no rpc", "networks:\n  devnet:\n    chain_id: 1337\n", "devnet", `network "devnet": rpc is required`},
+		{"no chain id", "networks:\n  devnet:\n    rpc: http://127.0.0.1:8545\n", "devnet", `network "devnet": chain_id is required`},
+		{"empty profile", "networks:\n  devnet:\n", "devnet", `network "devnet": empty profile`},
+		{"malformed", "networks: [", "devnet", "yaml"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			t.Setenv("EMERALD_NETWORK", "")
+			flags := connFlags{config: writeConfig(t, tc.config), network: tc.network}
+			_, _, err := flags.profile()
+			if err == nil || !strings.Contains(err.Error(), tc.want) {
+				t.Errorf("profile() = %v, want an error containing %q", err, tc.want)
+			}
+		})
+	}
+
+	flags := connFlags{config: filepath.Join(t.TempDir(), "missing.yaml")}
+	if _, _, err := flags.profile(); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("profile() with a missing file = %v", err)
+	}
+}
+
+// TestOpenChainIDMismatch opens sessions against the devnet from profiles
+// that agree and disagree with its chain id.
+func TestOpenChainIDMismatch(t *testing.T) {
+	d, server := newTestDevnet(t)
+	node := httptest.NewServer(server)
+	t.Cleanup(node.Close)
+	t.Setenv("EMERALD_NETWORK", "")
+	t.Setenv("TEST_EMERALD_RPC", node.URL)
+	t.Setenv("TEST_EMERALD_CONTRACT", d.token.Hex())
+	path := writeConfig(t, `
+networks:
+  devnet:
+    rpc: ${TEST_EMERALD_RPC}
+    chain_id: 1337
+    contracts:
+      emerald: ${TEST_EMERALD_CONTRACT}
+  mislabeled:
+    rpc: ${TEST_EMERALD_RPC}
+    chain_id: 5
+    contracts:
+      emerald: ${TEST_EMERALD_CONTRACT}
+`)
+
+	s, err := (&connFlags{config: path, network: "devnet"}).open(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s.chainID.Cmp(devnetChainID) != 0 || s.address != d.token {
+		t.Errorf("session on chain %s at %s, want the devnet's", s.chainID, s.address.Hex())
+	}
+	s.Close()
+
+	_, err = (&connFlags{config: path, network: "mislabeled"}).open(false)
+	if !errors.Is(err, errChainIDMismatch) || !strings.Contains(err.Error(), `network "mislabeled"`) {
+		t.Errorf("opening a profile for chain 5 on the devnet returned %v, want errChainIDMismatch", err)
+	}
+}
@@ -0,0 +1,332 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math"
+	"math/big"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethdb"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// devnetMnemonic is the mnemonic hardhat and anvil derive their development
+// accounts from. Using it too gives the devnet the same accounts, and the
+// token the address the local profile expects.
+const devnetMnemonic = "test test test test test test test test test test test junk"
+
+// devnetChainID is the chain id of every simulated backend.
+var devnetChainID = params.AllEthashProtocolChanges.ChainID
+
+// devnetConfig describes the chain a devnet starts with.
+type devnetConfig struct {
+	mnemonic string
+	accounts int
+	balance  *big.Int // wei given to every account at genesis
+	gasLimit uint64
+	supply   *big.Int // initial supply of the token; nil skips the deployment
+}
+
+// devnetAccount is a pre-funded account whose key the devnet holds.
+type devnetAccount struct {
+	address common.Address
+	key     *ecdsa.PrivateKey
+}
+
+// devnet is a simulated chain served to other processes over JSON-RPC. With
+// automine on, every transaction is mined into a block of its own as soon as
+// it arrives; otherwise transactions wait for evm_mine or the mining interval.
+type devnet struct {
+	mu       sync.Mutex // serializes changes to the chain and guards the fields below
+	db       ethdb.Database
+	backend  *backends.SimulatedBackend
+	chain    *core.BlockChain
+	config   *params.ChainConfig
+	signer   types.Signer
+	accounts []*devnetAccount
+	keys     map[common.Address]*ecdsa.PrivateKey
+
+	token       common.Address // zero when the token was not deployed
+	deployBlock uint64
+
+	automine      bool
+	pending       []*types.Transaction // sent but not mined yet, in order
+	offset        int64                // seconds the next block is moved forward
+	adjusted      int64                // seconds the clock was moved forward in total
+	snapshots     map[uint64]*devnetSnapshot
+	lastSnapshot  uint64
+	stopInterval  chan struct{} // closed to stop interval mining
+	impersonating map[common.Address]bool
+	impersonated  sync.Map // transaction hash to the impersonated sender
+}
+
+// newDevnet starts a chain whose genesis funds the configured accounts and
+// deploys the token. The genesis is dated 1970 and every block is ten seconds
+// after its parent. Keeping that clock rather than the current time leaves
+// room for jumps forward: geth sets aside a block dated more than a few
+// seconds ahead of the wall clock instead of importing it.
+func newDevnet(cfg *devnetConfig) (*devnet, error) {
+	funded, err := devnetAccounts(cfg.mnemonic, cfg.accounts)
+	if err != nil {
+		return nil, err
+	}
+	alloc := make(core.GenesisAlloc, len(funded))
+	keys := make(map[common.Address]*ecdsa.PrivateKey, len(funded))
+	for _, a := range funded {
+		alloc[a.address] = core.GenesisAccount{Balance: new(big.Int).Set(cfg.balance)}
+		keys[a.address] = a.key
+	}
+
+	db := rawdb.NewMemoryDatabase()
+	backend := backends.NewSimulatedBackendWithDatabase(db, alloc, cfg.gasLimit)
+	d := &devnet{
+		db:       db,
+		backend:  backend,
+		chain:    backend.Blockchain(),
+		config:   backend.Blockchain().Config(),
+		signer:   types.LatestSignerForChainID(devnetChainID),
+		accounts: funded,
+		keys:     keys,
+
+		automine:      true,
+		snapshots:     make(map[uint64]*devnetSnapshot),
+		impersonating: make(map[common.Address]bool),
+	}
+
+	if cfg.supply != nil && len(funded) > 0 {
+		if err := d.deploy(cfg.supply); err != nil {
+			backend.Close()
+			return nil, fmt.Errorf("deploying the token: %w", err)
+		}
+	}
+	return d, nil
+}
+
+// devnetAccounts derives n accounts from mnemonic along the default HD path.
+func devnetAccounts(mnemonic string, n int) ([]*devnetAccount, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, "")
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+	base, err := accounts.ParseDerivationPath(defaultHDPath)
+	if err != nil {
+		return nil, err
+	}
+	result := make([]*devnetAccount, n)
+	for i := range result {
+		path := append(append(accounts.DerivationPath{}, base...), uint32(i))
+		key, err := deriveHDKey(seed, path)
+		if err != nil {
+			return nil, err
+		}
+		result[i] = &devnetAccount{address: crypto.PubkeyToAddress(key.PublicKey), key: key}
+	}
+	return result, nil
+}
+
+// deploy deploys the token from the first account in a block of its own.
+func (d *devnet) deploy(supply *big.Int) error {
+	auth, err := bind.NewKeyedTransactorWithChainID(d.accounts[0].key, devnetChainID)
+	if err != nil {
+		return err
+	}
+	auth.Context = context.Background()
+	auth.NoSend = true
+	address, tx, _, err := DeployMain(auth, d.backend, supply)
+	if err != nil {
+		return err
+	}
+	if err := d.sendTransaction(auth.Context, tx); err != nil {
+		return err
+	}
+	receipt, err := d.backend.TransactionReceipt(auth.Context, tx.Hash())
+	if err != nil {
+		return err
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return errors.New("deployment reverted")
+	}
+	d.token, d.deployBlock = address, receipt.BlockNumber.Uint64()
+	return nil
+}
+
+// sendTransaction checks tx against the pending state and adds it to the
+// pending block, which is mined right away when automine is on.
+func (d *devnet) sendTransaction(ctx context.Context, tx *types.Transaction) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	from, err := types.Sender(d.signer, tx)
+	if err != nil {
+		return fmt.Errorf("invalid sender: %w", err)
+	}
+	nonce, err := d.backend.PendingNonceAt(ctx, from)
+	if err != nil {
+		return err
+	}
+	// There is no pool to queue a transaction in until its turn comes.
+	switch {
+	case tx.Nonce() < nonce:
+		return fmt.Errorf("%w: address %v, tx: %d state: %d", core.ErrNonceTooLow, from, tx.Nonce(), nonce)
+	case tx.Nonce() > nonce:
+		return fmt.Errorf("%w: address %v, tx: %d state: %d", core.ErrNonceTooHigh, from, tx.Nonce(), nonce)
+	}
+	if tx.Gas() > d.chain.CurrentHeader().GasLimit {
+		return errors.New("exceeds block gas limit")
+	}
+	// The simulated backend panics on a transaction it cannot apply, such
+	// as one its sender cannot pay for.
+	err = recovered(func() error {
+		return d.backend.SendTransaction(ctx, tx)
+	})
+	if err != nil {
+		return err
+	}
+	d.pending = append(d.pending, tx)
+	if !d.automine {
+		return nil
+	}
+	return d.mine(nil)
+}
+
+// recovered calls f and turns a panic in it back into the error that caused
+// it.
+func recovered(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	return f()
+}
+
+// sender returns the account that sent tx.
+func (d *devnet) sender(tx *types.Transaction) common.Address {
+	if from, ok := d.impersonated.Load(tx.Hash()); ok {
+		return from.(common.Address)
+	}
+	from, _ := types.Sender(d.signer, tx)
+	return from
+}
+
+// header resolves a block reference of a state query. Pending and the tags
+// of finality all mean the head, since every block is final once mined.
+func (d *devnet) header(ref rpc.BlockNumberOrHash) (*types.Header, error) {
+	if hash, ok := ref.Hash(); ok {
+		header := d.chain.GetHeaderByHash(hash)
+		if header == nil {
+			return nil, fmt.Errorf("header for hash %s not found", hash.Hex())
+		}
+		return header, nil
+	}
+	number, _ := ref.Number()
+	header := d.headerByNumber(number)
+	if header == nil {
+		return nil, fmt.Errorf("header %d not found", number)
+	}
+	return header, nil
+}
+
+// headerByNumber returns the header of number, or nil when there is none.
+func (d *devnet) headerByNumber(number rpc.BlockNumber) *types.Header {
+	if number < 0 {
+		return d.chain.CurrentHeader()
+	}
+	return d.chain.GetHeaderByNumber(uint64(number))
+}
+
+// call executes msg on the state after header without changing it.
+func (d *devnet) call(ctx context.Context, msg ethereum.CallMsg, header *types.Header) ([]byte, error) {
+	state, err := d.chain.StateAt(header.Root)
+	if err != nil {
+		return nil, err
+	}
+	gasPrice, feeCap, tipCap := new(big.Int), new(big.Int), new(big.Int)
+	switch {
+	case msg.GasPrice != nil:
+		gasPrice, feeCap, tipCap = msg.GasPrice, msg.GasPrice, msg.GasPrice
+	case msg.GasFeeCap != nil || msg.GasTipCap != nil:
+		if msg.GasFeeCap != nil {
+			feeCap = msg.GasFeeCap
+		}
+		if msg.GasTipCap != nil {
+			tipCap = msg.GasTipCap
+		}
+		gasPrice = new(big.Int).Add(tipCap, header.BaseFee)
+		if gasPrice.Cmp(feeCap) > 0 {
+			gasPrice = feeCap
+		}
+	}
+	gas := msg.Gas
+	if gas == 0 {
+		gas = header.GasLimit
+	}
+	value := msg.Value
+	if value == nil {
+		value = new(big.Int)
+	}
+	m := types.NewMessage(msg.From, msg.To, 0, value, gas, gasPrice, feeCap, tipCap, msg.Data, msg.AccessList, true)
+
+	evm := vm.NewEVM(core.NewEVMBlockContext(header, d.chain, nil), core.NewEVMTxContext(m), state, d.config, vm.Config{NoBaseFee: true})
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		select {
+		case <-ctx.Done():
+			evm.Cancel()
+		case <-done:
+		}
+	}()
+	res, err := core.ApplyMessage(evm, m, new(core.GasPool).AddGas(math.MaxUint64))
+	if err != nil {
+		return nil, err
+	}
+	if len(res.Revert()) > 0 {
+		return nil, newDevnetRevertError(res.Revert())
+	}
+	return res.Return(), res.Err
+}
+
+// devnetRevertError is a reverted call. The JSON-RPC server reports it with
+// the code and data geth uses, so clients can decode the reason.
+type devnetRevertError struct {
+	error
+	data string
+}
+
+func newDevnetRevertError(data []byte) *devnetRevertError {
+	err := errors.New("execution reverted")
+	if reason, unpackErr := abi.UnpackRevert(data); unpackErr == nil {
+		err = fmt.Errorf("execution reverted: %v", reason)
+	}
+	return &devnetRevertError{error: err, data: hexutil.Encode(data)}
+}
+
+func (e *devnetRevertError) ErrorCode() int         { return 3 }
+func (e *devnetRevertError) ErrorData() interface{} { return e.data }
+
+func (d *devnet) Close() {
+	d.setIntervalMining(0)
+	d.backend.Close()
+}
@@ -0,0 +1,342 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/ethash"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// simulatedBlockTime is the number of seconds a simulated block is dated
+// after its parent, unless the clock was moved.
+const simulatedBlockTime = 10
+
+// mine mines the pending transactions into a new block. The block is dated
+// timestamp when it is given, and otherwise simulatedBlockTime seconds after
+// its parent plus whatever evm_increaseTime added since the last block.
+// increaseTime dates the backend's pending block with AdjustTime, but the
+// backend drops that as soon as a transaction joins the block, so the block
+// mined here is dated from the same adjustment. The caller holds d.mu.
+func (d *devnet) mine(timestamp *uint64) error {
+	parent := d.chain.CurrentBlock()
+	offset := d.offset
+	if timestamp != nil {
+		if *timestamp <= parent.Time() {
+			return fmt.Errorf("timestamp %d is not after the head, block %d at %d", *timestamp, parent.NumberU64(), parent.Time())
+		}
+		offset = int64(*timestamp) - int64(parent.Time()+simulatedBlockTime)
+	}
+	// geth sets aside a block dated after the wall clock instead of
+	// importing it, so the simulated clock, which starts in 1970, must not
+	// overtake it.
+	if at := int64(parent.Time()) + simulatedBlockTime + offset; at > time.Now().Unix() {
+		return fmt.Errorf("cannot date a block %s: the devnet clock cannot pass the wall clock", time.Unix(at, 0).UTC().Format(time.RFC3339))
+	}
+
+	txs := d.pending
+	var blocks []*types.Block
+	err := recovered(func() error {
+		blocks, _ = core.GenerateChain(d.config, parent, ethash.NewFaker(), d.db, 1, func(_ int, block *core.BlockGen) {
+			if offset != 0 {
+				block.OffsetTime(offset)
+			}
+			for _, tx := range txs {
+				block.AddTxWithChain(d.chain, tx)
+			}
+		})
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := d.chain.InsertChain(blocks); err != nil {
+		return err
+	}
+	if head := d.chain.CurrentHeader(); head.Hash() != blocks[0].Hash() {
+		return fmt.Errorf("block %s was not imported on top of block %d", blocks[0].Hash().Hex(), head.Number)
+	}
+	d.pending, d.offset = nil, 0
+	// Start a new, empty pending block on top of the one just mined.
+	d.backend.Rollback()
+	return nil
+}
+
+// pendingTransaction returns the transaction with hash that waits to be
+// mined, or nil.
+func (d *devnet) pendingTransaction(hash common.Hash) *types.Transaction {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, tx := range d.pending {
+		if tx.Hash() == hash {
+			return tx
+		}
+	}
+	return nil
+}
+
+func (d *devnet) setAutomine(on bool) error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.automine = on
+	if on && len(d.pending) > 0 {
+		return d.mine(nil)
+	}
+	return nil
+}
+
+// setIntervalMining mines a block every interval, whether there are pending
+// transactions or not. An interval of zero stops it.
+func (d *devnet) setIntervalMining(interval time.Duration) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if d.stopInterval != nil {
+		close(d.stopInterval)
+		d.stopInterval = nil
+	}
+	if interval <= 0 {
+		return
+	}
+	stop := make(chan struct{})
+	d.stopInterval = stop
+	go func() {
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			d.mu.Lock()
+			err := d.mine(nil)
+			d.mu.Unlock()
+			if err != nil {
+				fmt.Fprintf(os.Stderr, "interval mining: %v\n", err)
+			}
+		}
+	}()
+}
+
+// increaseTime moves the clock forward by seconds, starting with the next
+// block, and returns the total the clock was moved.
+func (d *devnet) increaseTime(seconds int64) (int64, error) {
+	if seconds < 0 {
+		return 0, fmt.Errorf("cannot move the clock back by %d seconds", -seconds)
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if err := d.adjustTime(d.offset + seconds); err != nil {
+		return 0, err
+	}
+	d.offset += seconds
+	d.adjusted += seconds
+	return d.adjusted, nil
+}
+
+// adjustTime dates the simulated backend's pending block, which pending calls
+// and gas estimates run in, offset seconds later than its parent allows. The
+// backend cannot date a block that already holds transactions; mine dates
+// them when they are mined. The caller holds d.mu.
+func (d *devnet) adjustTime(offset int64) error {
+	if len(d.pending) > 0 {
+		return nil
+	}
+	return d.backend.AdjustTime(time.Duration(offset) * time.Second)
+}
+
+// devnetSnapshot is a chain state evm_revert can return to.
+type devnetSnapshot struct {
+	head     uint64
+	offset   int64
+	adjusted int64
+}
+
+func (d *devnet) snapshot() uint64 {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.lastSnapshot++
+	d.snapshots[d.lastSnapshot] = &devnetSnapshot{
+		head:     d.chain.CurrentHeader().Number.Uint64(),
+		offset:   d.offset,
+		adjusted: d.adjusted,
+	}
+	return d.lastSnapshot
+}
+
+// revert rewinds the chain to snapshot id, dropping the pending transactions.
+// Like hardhat, it discards the snapshot and every later one, and reports
+// false for an unknown id.
+func (d *devnet) revert(id uint64) (bool, error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	s, ok := d.snapshots[id]
+	if !ok {
+		return false, nil
+	}
+	for later := range d.snapshots {
+		if later >= id {
+			delete(d.snapshots, later)
+		}
+	}
+	if err := d.chain.SetHead(s.head); err != nil {
+		return false, err
+	}
+	d.backend.Rollback()
+	d.pending = nil
+	if err := d.adjustTime(s.offset); err != nil {
+		return false, err
+	}
+	d.offset, d.adjusted = s.offset, s.adjusted
+	return true, nil
+}
+
+func (d *devnet) impersonate(address common.Address, on bool) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if on {
+		d.impersonating[address] = true
+	} else {
+		delete(d.impersonating, address)
+	}
+}
+
+func (d *devnet) isImpersonating(address common.Address) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.impersonating[address]
+}
+
+// impersonatedSigner attributes a transaction to an account whose key the
+// devnet does not have.
+type impersonatedSigner struct {
+	types.Signer
+	from common.Address
+}
+
+func (s impersonatedSigner) Sender(*types.Transaction) (common.Address, error) {
+	return s.from, nil
+}
+
+// forge builds a transaction from an impersonated account. It carries a fake
+// signature, r = the account and s = 1, so that transactions of different
+// accounts never share a hash; recovering a sender from it yields some other
+// address or fails.
+//
+// That the chain executes the transaction as the account relies on an
+// internal detail of go-ethereum rather than on any API: types.Sender caches
+// the sender inside the transaction together with the signer that derived
+// it, and returns the cached sender to any signer that compares equal.
+// impersonatedSigner embeds the chain's signer, and so its Equal, and the
+// call to types.Sender below primes the cache with the account. Should a
+// go-ethereum upgrade change that cache, TestDevnetForge fails. The cache
+// does not survive the database either, so sender looks the account up by
+// hash.
+func (d *devnet) forge(from common.Address, data types.TxData) (*types.Transaction, error) {
+	sig := make([]byte, crypto.SignatureLength)
+	copy(sig[32-common.AddressLength:32], from.Bytes())
+	sig[63] = 1
+	tx, err := types.NewTx(data).WithSignature(d.signer, sig)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := types.Sender(impersonatedSigner{Signer: d.signer, from: from}, tx); err != nil {
+		return nil, err
+	}
+	d.impersonated.Store(tx.Hash(), from)
+	return tx, nil
+}
+
+// devnetEvmAPI serves the evm namespace hardhat and anvil use to control
+// a development chain.
+type devnetEvmAPI struct {
+	d *devnet
+}
+
+// devnetNumber is a numeric argument of the control methods, which clients
+// send both as a JSON number and as a hex quantity.
+type devnetNumber int64
+
+func (n *devnetNumber) UnmarshalJSON(input []byte) error {
+	if len(input) > 0 && input[0] == '"' {
+		var q hexutil.Uint64
+		if err := q.UnmarshalJSON(input); err != nil {
+			return err
+		}
+		*n = devnetNumber(q)
+		return nil
+	}
+	var v int64
+	if err := json.Unmarshal(input, &v); err != nil {
+		return err
+	}
+	*n = devnetNumber(v)
+	return nil
+}
+
+func (api *devnetEvmAPI) Snapshot() hexutil.Uint64 {
+	return hexutil.Uint64(api.d.snapshot())
+}
+
+func (api *devnetEvmAPI) Revert(id devnetNumber) (bool, error) {
+	return api.d.revert(uint64(id))
+}
+
+func (api *devnetEvmAPI) IncreaseTime(seconds devnetNumber) (int64, error) {
+	return api.d.increaseTime(int64(seconds))
+}
+
+// Mine mines a block, dated timestamp when one is given.
+func (api *devnetEvmAPI) Mine(timestamp *devnetNumber) (string, error) {
+	api.d.mu.Lock()
+	defer api.d.mu.Unlock()
+	var at *uint64
+	if timestamp != nil {
+		if *timestamp < 0 {
+			return "", fmt.Errorf("invalid timestamp %d", *timestamp)
+		}
+		t := uint64(*timestamp)
+		at = &t
+	}
+	if err := api.d.mine(at); err != nil {
+		return "", err
+	}
+	return "0x0", nil
+}
+
+func (api *devnetEvmAPI) SetAutomine(on bool) error {
+	return api.d.setAutomine(on)
+}
+
+// SetIntervalMining takes the interval in milliseconds, as hardhat does.
+func (api *devnetEvmAPI) SetIntervalMining(ms devnetNumber) error {
+	if ms < 0 {
+		return errors.New("the mining interval cannot be negative")
+	}
+	api.d.setIntervalMining(time.Duration(ms) * time.Millisecond)
+	return nil
+}
+
+// devnetImpersonationAPI serves account impersonation under the names hardhat
+// and anvil use. eth_sendTransaction then sends for an impersonated account
+// without its key.
+type devnetImpersonationAPI struct {
+	d *devnet
+}
+
+func (api *devnetImpersonationAPI) ImpersonateAccount(address common.Address) bool {
+	api.d.impersonate(address, true)
+	return true
+}
+
+func (api *devnetImpersonationAPI) StopImpersonatingAccount(address common.Address) bool {
+	api.d.impersonate(address, false)
+	return true
+}
@@ -0,0 +1,385 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// timestampCode is init code that returns block.timestamp, so a call without
+// a recipient reads the time of the block it runs in.
+var timestampCode = common.FromHex("0x4260005260206000f3")
+
+// devnetControl talks to the devnet the way hardhat scripts do.
+type devnetControl struct {
+	t      *testing.T
+	rpc    *rpc.Client
+	client *ethclient.Client
+}
+
+func newDevnetControl(t *testing.T, server *rpc.Server) *devnetControl {
+	c := rpc.DialInProc(server)
+	t.Cleanup(c.Close)
+	return &devnetControl{t: t, rpc: c, client: ethclient.NewClient(c)}
+}
+
+func (c *devnetControl) call(result interface{}, method string, args ...interface{}) {
+	c.t.Helper()
+	if err := c.rpc.Call(result, method, args...); err != nil {
+		c.t.Fatalf("%s: %v", method, err)
+	}
+}
+
+func (c *devnetControl) head() *types.Header {
+	c.t.Helper()
+	header, err := c.client.HeaderByNumber(context.Background(), nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return header
+}
+
+// send sends wei from one account to another with eth_sendTransaction.
+func (c *devnetControl) send(from, to common.Address, wei int64) common.Hash {
+	c.t.Helper()
+	var hash common.Hash
+	c.call(&hash, "eth_sendTransaction", map[string]interface{}{
+		"from":  from,
+		"to":    to,
+		"value": (*hexutil.Big)(big.NewInt(wei)),
+	})
+	return hash
+}
+
+func (c *devnetControl) balance(address common.Address) *big.Int {
+	c.t.Helper()
+	balance, err := c.client.BalanceAt(context.Background(), address, nil)
+	if err != nil {
+		c.t.Fatal(err)
+	}
+	return balance
+}
+
+func TestDevnetSnapshotRevert(t *testing.T) {
+	d, server := newTestDevnet(t)
+	c := newDevnetControl(t, server)
+	from, to := d.accounts[0].address, d.accounts[1].address
+	before := c.balance(to)
+
+	var first, second hexutil.Uint64
+	c.call(&first, "evm_snapshot")
+	c.send(from, to, 1)
+	c.call(&second, "evm_snapshot")
+	c.send(from, to, 2)
+	if got := c.balance(to); got.Cmp(new(big.Int).Add(before, big.NewInt(3))) != 0 {
+		t.Fatalf("balance %v after the transfers", got)
+	}
+
+	var ok bool
+	c.call(&ok, "evm_revert", first)
+	if !ok {
+		t.Fatal("evm_revert returned false for a snapshot")
+	}
+	if got := c.balance(to); got.Cmp(before) != 0 {
+		t.Errorf("balance %v after the revert, want %v", got, before)
+	}
+	if got := c.head().Number.Uint64(); got != d.deployBlock {
+		t.Errorf("head %d after the revert, want %d", got, d.deployBlock)
+	}
+	// Reverting discards the snapshot and every later one.
+	for _, id := range []hexutil.Uint64{first, second, 99} {
+		c.call(&ok, "evm_revert", id)
+		if ok {
+			t.Errorf("evm_revert(%d) returned true for a discarded or unknown snapshot", id)
+		}
+	}
+	// The chain goes on from the snapshot.
+	c.send(from, to, 4)
+	if got := c.balance(to); got.Cmp(new(big.Int).Add(before, big.NewInt(4))) != 0 {
+		t.Errorf("balance %v after a transfer on the reverted chain", got)
+	}
+}
+
+func TestDevnetIncreaseTime(t *testing.T) {
+	d, server := newTestDevnet(t)
+	c := newDevnetControl(t, server)
+	parent := c.head()
+
+	var total int64
+	c.call(&total, "evm_increaseTime", 3600)
+	c.call(&total, "evm_increaseTime", "0x3c")
+	if total != 3660 {
+		t.Errorf("evm_increaseTime returned %d, want 3660", total)
+	}
+	// The backend's pending block, in which pending calls run, is dated
+	// forward already.
+	pending, err := d.backend.PendingCallContract(context.Background(), ethereum.CallMsg{Data: timestampCode})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := parent.Time + simulatedBlockTime + 3660
+	if got := new(big.Int).SetBytes(pending).Uint64(); got != want {
+		t.Errorf("pending block at %d, want %d", got, want)
+	}
+
+	c.send(d.accounts[0].address, d.accounts[1].address, 1)
+	head := c.head()
+	if head.Time != want {
+		t.Errorf("block %d at %d, want %d", head.Number, head.Time, want)
+	}
+	// The adjustment is used up by one block; the clock stays moved.
+	c.call(nil, "evm_mine")
+	if got := c.head().Time; got != want+simulatedBlockTime {
+		t.Errorf("the next block at %d, want %d", got, want+simulatedBlockTime)
+	}
+	c.call(&total, "evm_increaseTime", 0)
+	if total != 3660 {
+		t.Errorf("evm_increaseTime(0) returned %d, want 3660", total)
+	}
+
+	if err := c.rpc.Call(&total, "evm_increaseTime", -1); err == nil {
+		t.Error("moved the clock back")
+	}
+	// The clock starts in 1970 and cannot pass the wall clock.
+	c.call(&total, "evm_increaseTime", time.Now().Unix())
+	if err := c.rpc.Call(nil, "evm_mine"); err == nil {
+		t.Error("mined a block dated after the wall clock")
+	}
+}
+
+func TestDevnetMine(t *testing.T) {
+	d, server := newTestDevnet(t)
+	c := newDevnetControl(t, server)
+	parent := c.head()
+
+	c.call(nil, "evm_mine")
+	head := c.head()
+	if head.Number.Uint64() != parent.Number.Uint64()+1 || head.Time != parent.Time+simulatedBlockTime {
+		t.Errorf("evm_mine mined block %d at %d after block %d at %d", head.Number, head.Time, parent.Number, parent.Time)
+	}
+
+	at := head.Time + 1000
+	c.call(nil, "evm_mine", at)
+	if head = c.head(); head.Time != at {
+		t.Errorf("evm_mine(%d) dated the block %d", at, head.Time)
+	}
+	c.call(nil, "evm_mine", hexutil.Uint64(at+5))
+	if head = c.head(); head.Time != at+5 {
+		t.Errorf("evm_mine with a hex timestamp dated the block %d", head.Time)
+	}
+	if err := c.rpc.Call(nil, "evm_mine", at); err == nil {
+		t.Error("mined a block no later than its parent")
+	}
+	if got := c.head().Number.Uint64(); got != d.deployBlock+3 {
+		t.Errorf("head %d, want %d", got, d.deployBlock+3)
+	}
+}
+
+func TestDevnetAutomine(t *testing.T) {
+	d, server := newTestDevnet(t)
+	c := newDevnetControl(t, server)
+	from, to := d.accounts[0].address, d.accounts[1].address
+	start := c.head().Number.Uint64()
+	mined, err := c.client.NonceAt(context.Background(), from, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c.call(nil, "evm_setAutomine", false)
+	first := c.send(from, to, 1)
+	second := c.send(from, to, 2)
+	if got := c.head().Number.Uint64(); got != start {
+		t.Fatalf("mined block %d with automine off", got)
+	}
+	if _, pending, err := c.client.TransactionByHash(context.Background(), first); err != nil || !pending {
+		t.Errorf("transaction pending %v, %v", pending, err)
+	}
+	nonce, err := c.client.PendingNonceAt(context.Background(), from)
+	if err != nil || nonce != mined+2 {
+		t.Errorf("pending nonce %d, %v; want %d", nonce, err, mined+2)
+	}
+
+	c.call(nil, "evm_mine")
+	head := c.head()
+	if head.Number.Uint64() != start+1 {
+		t.Fatalf("head %d after evm_mine", head.Number)
+	}
+	for _, hash := range []common.Hash{first, second} {
+		receipt, err := c.client.TransactionReceipt(context.Background(), hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.BlockNumber.Cmp(head.Number) != 0 {
+			t.Errorf("transaction mined in block %d, want %d", receipt.BlockNumber, head.Number)
+		}
+	}
+
+	// Turning automine back on mines what waits.
+	third := c.send(from, to, 3)
+	c.call(nil, "evm_setAutomine", true)
+	if _, err := c.client.TransactionReceipt(context.Background(), third); err != nil {
+		t.Errorf("waiting transaction not mined: %v", err)
+	}
+	c.send(from, to, 4)
+	if got := c.head().Number.Uint64(); got != start+3 {
+		t.Errorf("head %d with automine on, want %d", got, start+3)
+	}
+}
+
+func TestDevnetIntervalMining(t *testing.T) {
+	d, server := newTestDevnet(t)
+	c := newDevnetControl(t, server)
+	start := c.head().Number.Uint64()
+
+	c.call(nil, "evm_setAutomine", false)
+	hash := c.send(d.accounts[0].address, d.accounts[1].address, 1)
+	c.call(nil, "evm_setIntervalMining", 10)
+	deadline := time.Now().Add(5 * time.Second)
+	for c.head().Number.Uint64() < start+3 {
+		if time.Now().After(deadline) {
+			t.Fatalf("head %d, want blocks mined every 10ms after %d", c.head().Number, start)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+	receipt, err := c.client.TransactionReceipt(context.Background(), hash)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.BlockNumber.Uint64() != start+1 {
+		t.Errorf("transaction mined in block %d, want %d", receipt.BlockNumber, start+1)
+	}
+
+	c.call(nil, "evm_setIntervalMining", 0)
+	stopped := c.head().Number.Uint64()
+	time.Sleep(50 * time.Millisecond)
+	if got := c.head().Number.Uint64(); got != stopped {
+		t.Errorf("mined up to block %d after interval mining stopped at %d", got, stopped)
+	}
+	if err := c.rpc.Call(nil, "evm_setIntervalMining", -1); err == nil {
+		t.Error("accepted a negative interval")
+	}
+}
+
+func TestDevnetImpersonation(t *testing.T) {
+	for _, namespace := range []string{"hardhat", "anvil"} {
+		t.Run(namespace, func(t *testing.T) {
+			d, server := newTestDevnet(t)
+			c := newDevnetControl(t, server)
+			whale := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+			to := d.accounts[1].address
+			c.send(d.accounts[0].address, whale, params.Ether/10)
+
+			if err := c.rpc.Call(nil, "eth_sendTransaction", map[string]interface{}{"from": whale, "to": to}); err == nil {
+				t.Fatal("sent for an account without its key")
+			}
+			var ok bool
+			c.call(&ok, namespace+"_impersonateAccount", whale)
+			before := c.balance(to)
+			hash := c.send(whale, to, 1000)
+			if got := c.balance(to); got.Cmp(new(big.Int).Add(before, big.NewInt(1000))) != 0 {
+				t.Errorf("recipient balance %v, want %v + 1000", got, before)
+			}
+			tx, _, err := c.client.TransactionByHash(context.Background(), hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			receipt, err := c.client.TransactionReceipt(context.Background(), hash)
+			if err != nil {
+				t.Fatal(err)
+			}
+			from, err := c.client.TransactionSender(context.Background(), tx, receipt.BlockHash, receipt.TransactionIndex)
+			if err != nil || from != whale {
+				t.Errorf("sender %v, %v; want %v", from, err, whale)
+			}
+
+			c.call(&ok, namespace+"_stopImpersonatingAccount", whale)
+			if err := c.rpc.Call(nil, "eth_sendTransaction", map[string]interface{}{"from": whale, "to": to}); err == nil {
+				t.Error("sent for an account no longer impersonated")
+			}
+		})
+	}
+}
+
+// TestDevnetForge pins the go-ethereum behavior forge relies on: the sender
+// cached by types.Sender, not the fake signature, decides who the chain
+// thinks sent a forged transaction.
+func TestDevnetForge(t *testing.T) {
+	d, _ := newTestDevnet(t)
+	whale := common.HexToAddress("0x000000000000000000000000000000000000dEaD")
+	to := d.accounts[1].address
+	tx, err := d.forge(whale, &types.LegacyTx{Gas: 21000, GasPrice: big.NewInt(params.GWei), To: &to, Value: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The chain's signer finds the sender forge cached.
+	if from, err := types.Sender(d.signer, tx); err != nil || from != whale {
+		t.Fatalf("types.Sender = %v, %v with the chain's signer; want %v from the cache", from, err, whale)
+	}
+	// A copy without the cache recovers something else from the signature.
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded types.Transaction
+	if err := decoded.UnmarshalBinary(raw); err != nil {
+		t.Fatal(err)
+	}
+	if from, err := types.Sender(d.signer, &decoded); err == nil && from == whale {
+		t.Fatal("the fake signature recovers the impersonated account")
+	}
+	if got := d.sender(&decoded); got != whale {
+		t.Errorf("sender of the decoded transaction %v, want %v", got, whale)
+	}
+
+	// The chain executes the forged transaction as the account.
+	d.mu.Lock()
+	d.automine = false
+	d.mu.Unlock()
+	if err := d.sendTransaction(context.Background(), fundingTx(t, d, whale)); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.sendTransaction(context.Background(), tx); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	err = d.mine(nil)
+	d.mu.Unlock()
+	if err != nil {
+		t.Fatal(err)
+	}
+	nonce, err := d.backend.NonceAt(context.Background(), whale, nil)
+	if err != nil || nonce != 1 {
+		t.Errorf("impersonated account nonce %d, %v; want 1", nonce, err)
+	}
+}
+
+// fundingTx returns a transaction from the first account that pays to for
+// a transfer.
+func fundingTx(t *testing.T, d *devnet, to common.Address) *types.Transaction {
+	t.Helper()
+	nonce, err := d.backend.PendingNonceAt(context.Background(), d.accounts[0].address)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignNewTx(d.accounts[0].key, d.signer, &types.LegacyTx{
+		Nonce:    nonce,
+		Gas:      21000,
+		GasPrice: big.NewInt(params.GWei),
+		To:       &to,
+		Value:    big.NewInt(params.Ether / 10),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tx
+}
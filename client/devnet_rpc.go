@@ -0,0 +1,584 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/consensus/misc"
+	"github.com/ethereum/go-ethereum/core/rawdb"
+	"github.com/ethereum/go-ethereum/core/state"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/eth/filters"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// newRPCServer returns a JSON-RPC server for the devnet, to be served over
+// HTTP and WebSocket.
+func (d *devnet) newRPCServer() (*rpc.Server, error) {
+	server := rpc.NewServer()
+	apis := []struct {
+		namespace string
+		service   interface{}
+	}{
+		{"eth", &devnetEthAPI{d: d}},
+		{"net", &devnetNetAPI{}},
+		{"web3", &devnetWeb3API{}},
+		{"evm", &devnetEvmAPI{d: d}},
+		{"hardhat", &devnetImpersonationAPI{d: d}},
+		{"anvil", &devnetImpersonationAPI{d: d}},
+	}
+	for _, api := range apis {
+		if err := server.RegisterName(api.namespace, api.service); err != nil {
+			server.Stop()
+			return nil, err
+		}
+	}
+	return server, nil
+}
+
+// devnetNetAPI serves the net namespace.
+type devnetNetAPI struct{}
+
+func (*devnetNetAPI) Version() string         { return devnetChainID.String() }
+func (*devnetNetAPI) Listening() bool         { return true }
+func (*devnetNetAPI) PeerCount() hexutil.Uint { return 0 }
+
+// devnetWeb3API serves the web3 namespace.
+type devnetWeb3API struct{}
+
+func (*devnetWeb3API) ClientVersion() string { return "emerald-devnet" }
+
+// devnetEthAPI serves the eth namespace: the methods the CLI, ethers and
+// hardhat call, with the arguments and results geth uses. The state of every
+// block is kept, so any block can be queried.
+type devnetEthAPI struct {
+	d *devnet
+}
+
+// devnetTxArgs are the transaction fields of eth_call, eth_estimateGas,
+// eth_sendTransaction and eth_signTransaction.
+type devnetTxArgs struct {
+	From                 *common.Address   `json:"from"`
+	To                   *common.Address   `json:"to"`
+	Gas                  *hexutil.Uint64   `json:"gas"`
+	GasPrice             *hexutil.Big      `json:"gasPrice"`
+	MaxFeePerGas         *hexutil.Big      `json:"maxFeePerGas"`
+	MaxPriorityFeePerGas *hexutil.Big      `json:"maxPriorityFeePerGas"`
+	Value                *hexutil.Big      `json:"value"`
+	Nonce                *hexutil.Uint64   `json:"nonce"`
+	Data                 *hexutil.Bytes    `json:"data"`
+	Input                *hexutil.Bytes    `json:"input"`
+	AccessList           *types.AccessList `json:"accessList"`
+	ChainID              *hexutil.Big      `json:"chainId"`
+}
+
+// data returns the input of the transaction, which clients send as either
+// input or data.
+func (a *devnetTxArgs) data() []byte {
+	if a.Input != nil {
+		return *a.Input
+	}
+	if a.Data != nil {
+		return *a.Data
+	}
+	return nil
+}
+
+func (a *devnetTxArgs) callMsg() ethereum.CallMsg {
+	msg := ethereum.CallMsg{
+		To:        a.To,
+		GasPrice:  (*big.Int)(a.GasPrice),
+		GasFeeCap: (*big.Int)(a.MaxFeePerGas),
+		GasTipCap: (*big.Int)(a.MaxPriorityFeePerGas),
+		Value:     (*big.Int)(a.Value),
+		Data:      a.data(),
+	}
+	if a.From != nil {
+		msg.From = *a.From
+	}
+	if a.Gas != nil {
+		msg.Gas = uint64(*a.Gas)
+	}
+	if a.AccessList != nil {
+		msg.AccessList = *a.AccessList
+	}
+	return msg
+}
+
+func (api *devnetEthAPI) ChainId() *hexutil.Big {
+	return (*hexutil.Big)(devnetChainID)
+}
+
+func (api *devnetEthAPI) BlockNumber() hexutil.Uint64 {
+	return hexutil.Uint64(api.d.chain.CurrentHeader().Number.Uint64())
+}
+
+func (api *devnetEthAPI) Syncing() bool {
+	return false
+}
+
+// Accounts lists the pre-funded accounts, which eth_sendTransaction signs for.
+func (api *devnetEthAPI) Accounts() []common.Address {
+	addresses := make([]common.Address, len(api.d.accounts))
+	for i, a := range api.d.accounts {
+		addresses[i] = a.address
+	}
+	return addresses
+}
+
+func (api *devnetEthAPI) GasPrice(ctx context.Context) (*hexutil.Big, error) {
+	baseFee, err := api.d.backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	tip, err := api.d.backend.SuggestGasTipCap(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(new(big.Int).Add(baseFee, tip)), nil
+}
+
+func (api *devnetEthAPI) MaxPriorityFeePerGas(ctx context.Context) (*hexutil.Big, error) {
+	tip, err := api.d.backend.SuggestGasTipCap(ctx)
+	return (*hexutil.Big)(tip), err
+}
+
+// devnetFeeHistory is the result of eth_feeHistory.
+type devnetFeeHistory struct {
+	OldestBlock  *hexutil.Big     `json:"oldestBlock"`
+	Reward       [][]*hexutil.Big `json:"reward,omitempty"`
+	BaseFee      []*hexutil.Big   `json:"baseFeePerGas,omitempty"`
+	GasUsedRatio []float64        `json:"gasUsedRatio"`
+}
+
+// maxFeeHistory bounds the blocks one eth_feeHistory call covers, as in geth.
+const maxFeeHistory = 1024
+
+func (api *devnetEthAPI) FeeHistory(ctx context.Context, blockCount hexutil.Uint64, lastBlock rpc.BlockNumber, percentiles []float64) (*devnetFeeHistory, error) {
+	last := api.d.headerByNumber(lastBlock)
+	if last == nil {
+		return nil, fmt.Errorf("block %d not found", lastBlock)
+	}
+	n := uint64(blockCount)
+	if n > maxFeeHistory {
+		n = maxFeeHistory
+	}
+	if head := last.Number.Uint64(); n > head+1 {
+		n = head + 1
+	}
+	oldest := last.Number.Uint64() + 1 - n
+	fh := &devnetFeeHistory{OldestBlock: (*hexutil.Big)(new(big.Int).SetUint64(oldest)), GasUsedRatio: []float64{}}
+	for number := oldest; number <= last.Number.Uint64(); number++ {
+		block := api.d.chain.GetBlockByNumber(number)
+		if block == nil {
+			return nil, fmt.Errorf("block %d not found", number)
+		}
+		fh.BaseFee = append(fh.BaseFee, (*hexutil.Big)(baseFeeOf(block.Header())))
+		fh.GasUsedRatio = append(fh.GasUsedRatio, float64(block.GasUsed())/float64(block.GasLimit()))
+		if len(percentiles) > 0 {
+			fh.Reward = append(fh.Reward, tipPercentiles(block, percentiles))
+		}
+	}
+	fh.BaseFee = append(fh.BaseFee, (*hexutil.Big)(misc.CalcBaseFee(api.d.config, last)))
+	return fh, nil
+}
+
+func baseFeeOf(header *types.Header) *big.Int {
+	if header.BaseFee == nil {
+		return new(big.Int)
+	}
+	return header.BaseFee
+}
+
+// tipPercentiles returns the priority fees paid in block at each percentile,
+// counting transactions rather than gas, and zero for an empty block.
+func tipPercentiles(block *types.Block, percentiles []float64) []*hexutil.Big {
+	tips := make([]*big.Int, 0, len(block.Transactions()))
+	for _, tx := range block.Transactions() {
+		tips = append(tips, tx.EffectiveGasTipValue(block.BaseFee()))
+	}
+	sort.Slice(tips, func(i, j int) bool { return tips[i].Cmp(tips[j]) < 0 })
+	reward := make([]*hexutil.Big, len(percentiles))
+	for i, p := range percentiles {
+		if len(tips) == 0 {
+			reward[i] = (*hexutil.Big)(new(big.Int))
+			continue
+		}
+		k := int(p / 100 * float64(len(tips)))
+		if k >= len(tips) {
+			k = len(tips) - 1
+		}
+		if k < 0 {
+			k = 0
+		}
+		reward[i] = (*hexutil.Big)(tips[k])
+	}
+	return reward
+}
+
+func (api *devnetEthAPI) state(ref rpc.BlockNumberOrHash) (*state.StateDB, error) {
+	header, err := api.d.header(ref)
+	if err != nil {
+		return nil, err
+	}
+	return api.d.chain.StateAt(header.Root)
+}
+
+func (api *devnetEthAPI) GetBalance(ctx context.Context, address common.Address, ref rpc.BlockNumberOrHash) (*hexutil.Big, error) {
+	st, err := api.state(ref)
+	if err != nil {
+		return nil, err
+	}
+	return (*hexutil.Big)(st.GetBalance(address)), nil
+}
+
+func (api *devnetEthAPI) GetCode(ctx context.Context, address common.Address, ref rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	st, err := api.state(ref)
+	if err != nil {
+		return nil, err
+	}
+	return st.GetCode(address), nil
+}
+
+// GetStorageAt takes the slot as a string since clients send it both as a
+// quantity and as a 32-byte word.
+func (api *devnetEthAPI) GetStorageAt(ctx context.Context, address common.Address, key string, ref rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	st, err := api.state(ref)
+	if err != nil {
+		return nil, err
+	}
+	value := st.GetState(address, common.HexToHash(key))
+	return value.Bytes(), nil
+}
+
+func (api *devnetEthAPI) GetTransactionCount(ctx context.Context, address common.Address, ref rpc.BlockNumberOrHash) (*hexutil.Uint64, error) {
+	if number, ok := ref.Number(); ok && number == rpc.PendingBlockNumber {
+		nonce, err := api.d.backend.PendingNonceAt(ctx, address)
+		return (*hexutil.Uint64)(&nonce), err
+	}
+	st, err := api.state(ref)
+	if err != nil {
+		return nil, err
+	}
+	nonce := st.GetNonce(address)
+	return (*hexutil.Uint64)(&nonce), nil
+}
+
+func (api *devnetEthAPI) Call(ctx context.Context, args devnetTxArgs, ref *rpc.BlockNumberOrHash) (hexutil.Bytes, error) {
+	if ref == nil {
+		latest := rpc.BlockNumberOrHashWithNumber(rpc.LatestBlockNumber)
+		ref = &latest
+	}
+	header, err := api.d.header(*ref)
+	if err != nil {
+		return nil, err
+	}
+	return api.d.call(ctx, args.callMsg(), header)
+}
+
+// EstimateGas estimates against the pending state, whatever block is asked
+// for.
+func (api *devnetEthAPI) EstimateGas(ctx context.Context, args devnetTxArgs, ref *rpc.BlockNumberOrHash) (hexutil.Uint64, error) {
+	gas, err := api.d.backend.EstimateGas(ctx, args.callMsg())
+	return hexutil.Uint64(gas), err
+}
+
+func (api *devnetEthAPI) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if err := api.d.sendTransaction(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+func (api *devnetEthAPI) SendTransaction(ctx context.Context, args devnetTxArgs) (common.Hash, error) {
+	tx, err := api.signTransaction(ctx, args)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := api.d.sendTransaction(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// SignTransaction returns the raw signed transaction, as web3signer does, so
+// the devnet can back --signer remote.
+func (api *devnetEthAPI) SignTransaction(ctx context.Context, args devnetTxArgs) (hexutil.Bytes, error) {
+	tx, err := api.signTransaction(ctx, args)
+	if err != nil {
+		return nil, err
+	}
+	return tx.MarshalBinary()
+}
+
+// signTransaction fills in the fields args leaves out and signs the result
+// with the key of its sender. Without a gas price the transaction pays
+// EIP-1559 fees.
+func (api *devnetEthAPI) signTransaction(ctx context.Context, args devnetTxArgs) (*types.Transaction, error) {
+	if args.From == nil {
+		return nil, errors.New("from is required")
+	}
+	key, ok := api.d.keys[*args.From]
+	if !ok && !api.d.isImpersonating(*args.From) {
+		return nil, fmt.Errorf("unknown account %s", args.From.Hex())
+	}
+	if args.ChainID != nil && args.ChainID.ToInt().Cmp(devnetChainID) != 0 {
+		return nil, fmt.Errorf("%w: chain id %s, the devnet is %s", errChainIDMismatch, args.ChainID, devnetChainID)
+	}
+
+	var nonce uint64
+	if args.Nonce != nil {
+		nonce = uint64(*args.Nonce)
+	} else {
+		var err error
+		if nonce, err = api.d.backend.PendingNonceAt(ctx, *args.From); err != nil {
+			return nil, err
+		}
+	}
+	var gas uint64
+	if args.Gas != nil {
+		gas = uint64(*args.Gas)
+	} else {
+		var err error
+		if gas, err = api.d.backend.EstimateGas(ctx, args.callMsg()); err != nil {
+			return nil, err
+		}
+	}
+	value := new(big.Int)
+	if args.Value != nil {
+		value = args.Value.ToInt()
+	}
+
+	var data types.TxData
+	if args.GasPrice != nil {
+		data = &types.LegacyTx{Nonce: nonce, GasPrice: args.GasPrice.ToInt(), Gas: gas, To: args.To, Value: value, Data: args.data()}
+	} else {
+		tip := (*big.Int)(args.MaxPriorityFeePerGas)
+		if tip == nil {
+			var err error
+			if tip, err = api.d.backend.SuggestGasTipCap(ctx); err != nil {
+				return nil, err
+			}
+		}
+		feeCap := (*big.Int)(args.MaxFeePerGas)
+		if feeCap == nil {
+			// The base fee of the pending block, with room for it to double.
+			baseFee, err := api.d.backend.SuggestGasPrice(ctx)
+			if err != nil {
+				return nil, err
+			}
+			feeCap = new(big.Int).Add(new(big.Int).Mul(baseFee, big.NewInt(2)), tip)
+		}
+		var accessList types.AccessList
+		if args.AccessList != nil {
+			accessList = *args.AccessList
+		}
+		data = &types.DynamicFeeTx{
+			ChainID:    devnetChainID,
+			Nonce:      nonce,
+			GasTipCap:  tip,
+			GasFeeCap:  feeCap,
+			Gas:        gas,
+			To:         args.To,
+			Value:      value,
+			Data:       args.data(),
+			AccessList: accessList,
+		}
+	}
+	if key == nil {
+		return api.d.forge(*args.From, data)
+	}
+	return types.SignNewTx(key, api.d.signer, data)
+}
+
+// GetTransactionByHash returns null for an unknown transaction.
+func (api *devnetEthAPI) GetTransactionByHash(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	tx, blockHash, _, index := rawdb.ReadTransaction(api.d.db, hash)
+	if tx == nil {
+		if tx = api.d.pendingTransaction(hash); tx != nil {
+			return api.rpcTransaction(tx, nil, 0)
+		}
+		return nil, nil
+	}
+	return api.rpcTransaction(tx, api.d.chain.GetHeaderByHash(blockHash), index)
+}
+
+// GetTransactionReceipt returns null for a transaction that is not mined.
+func (api *devnetEthAPI) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	tx, blockHash, _, _ := rawdb.ReadTransaction(api.d.db, hash)
+	if tx == nil {
+		return nil, nil
+	}
+	receipt, err := api.d.backend.TransactionReceipt(ctx, hash)
+	if errors.Is(err, ethereum.NotFound) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	fields, err := rpcFields(receipt)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = api.d.sender(tx)
+	fields["to"] = tx.To()
+	fields["effectiveGasPrice"] = (*hexutil.Big)(effectiveGasPrice(tx, api.d.chain.GetHeaderByHash(blockHash)))
+	if receipt.ContractAddress == (common.Address{}) {
+		fields["contractAddress"] = nil
+	}
+	if receipt.Logs == nil {
+		fields["logs"] = []*types.Log{}
+	}
+	if len(receipt.PostState) == 0 {
+		delete(fields, "root")
+	}
+	return fields, nil
+}
+
+func (api *devnetEthAPI) GetBlockByNumber(ctx context.Context, number rpc.BlockNumber, fullTx bool) (map[string]interface{}, error) {
+	header := api.d.headerByNumber(number)
+	if header == nil {
+		return nil, nil
+	}
+	return api.rpcBlock(api.d.chain.GetBlock(header.Hash(), header.Number.Uint64()), fullTx)
+}
+
+func (api *devnetEthAPI) GetBlockByHash(ctx context.Context, hash common.Hash, fullTx bool) (map[string]interface{}, error) {
+	block := api.d.chain.GetBlockByHash(hash)
+	if block == nil {
+		return nil, nil
+	}
+	return api.rpcBlock(block, fullTx)
+}
+
+func (api *devnetEthAPI) GetLogs(ctx context.Context, crit filters.FilterCriteria) ([]types.Log, error) {
+	logs, err := api.d.backend.FilterLogs(ctx, ethereum.FilterQuery(crit))
+	if err != nil {
+		return nil, err
+	}
+	if logs == nil {
+		logs = []types.Log{}
+	}
+	return logs, nil
+}
+
+// NewHeads streams the header of every block mined.
+func (api *devnetEthAPI) NewHeads(ctx context.Context) (*rpc.Subscription, error) {
+	heads := make(chan *types.Header)
+	sub, err := api.d.backend.SubscribeNewHead(context.Background(), heads)
+	if err != nil {
+		return nil, err
+	}
+	return forward(ctx, sub, heads)
+}
+
+// Logs streams the logs crit selects as their blocks are mined.
+func (api *devnetEthAPI) Logs(ctx context.Context, crit filters.FilterCriteria) (*rpc.Subscription, error) {
+	logs := make(chan types.Log)
+	sub, err := api.d.backend.SubscribeFilterLogs(context.Background(), ethereum.FilterQuery(crit), logs)
+	if err != nil {
+		return nil, err
+	}
+	return forward(ctx, sub, logs)
+}
+
+// forward relays the values of sub to the subscriber of the calling
+// JSON-RPC connection until either side ends the subscription.
+func forward[T any](ctx context.Context, sub ethereum.Subscription, values <-chan T) (*rpc.Subscription, error) {
+	notifier, ok := rpc.NotifierFromContext(ctx)
+	if !ok {
+		sub.Unsubscribe()
+		return nil, rpc.ErrNotificationsUnsupported
+	}
+	rpcSub := notifier.CreateSubscription()
+	go func() {
+		defer sub.Unsubscribe()
+		for {
+			select {
+			case v := <-values:
+				notifier.Notify(rpcSub.ID, v)
+			case <-sub.Err():
+				return
+			case <-rpcSub.Err():
+				return
+			case <-notifier.Closed():
+				return
+			}
+		}
+	}()
+	return rpcSub, nil
+}
+
+// rpcFields encodes v to a JSON object whose fields can then be added to.
+func rpcFields(v interface{}) (map[string]interface{}, error) {
+	data, err := json.Marshal(v)
+	if err != nil {
+		return nil, err
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, err
+	}
+	return fields, nil
+}
+
+func (api *devnetEthAPI) rpcBlock(block *types.Block, fullTx bool) (map[string]interface{}, error) {
+	fields, err := rpcFields(block.Header())
+	if err != nil {
+		return nil, err
+	}
+	fields["size"] = hexutil.Uint64(block.Size())
+	fields["totalDifficulty"] = (*hexutil.Big)(api.d.chain.GetTd(block.Hash(), block.NumberU64()))
+	fields["uncles"] = []common.Hash{}
+	txs := make([]interface{}, len(block.Transactions()))
+	for i, tx := range block.Transactions() {
+		if !fullTx {
+			txs[i] = tx.Hash()
+			continue
+		}
+		if txs[i], err = api.rpcTransaction(tx, block.Header(), uint64(i)); err != nil {
+			return nil, err
+		}
+	}
+	fields["transactions"] = txs
+	return fields, nil
+}
+
+// rpcTransaction encodes tx as mined at index of the block of header, or as
+// pending when header is nil.
+func (api *devnetEthAPI) rpcTransaction(tx *types.Transaction, header *types.Header, index uint64) (map[string]interface{}, error) {
+	fields, err := rpcFields(tx)
+	if err != nil {
+		return nil, err
+	}
+	fields["from"] = api.d.sender(tx)
+	if header == nil {
+		fields["blockHash"], fields["blockNumber"], fields["transactionIndex"] = nil, nil, nil
+		fields["gasPrice"] = (*hexutil.Big)(tx.GasFeeCap())
+		return fields, nil
+	}
+	fields["blockHash"] = header.Hash()
+	fields["blockNumber"] = (*hexutil.Big)(header.Number)
+	fields["transactionIndex"] = hexutil.Uint64(index)
+	fields["gasPrice"] = (*hexutil.Big)(effectiveGasPrice(tx, header))
+	return fields, nil
+}
+
+// effectiveGasPrice is the price per gas tx paid in the block of header.
+func effectiveGasPrice(tx *types.Transaction, header *types.Header) *big.Int {
+	if header == nil || header.BaseFee == nil {
+		return tx.GasPrice()
+	}
+	return new(big.Int).Add(tx.EffectiveGasTipValue(header.BaseFee), header.BaseFee)
+}
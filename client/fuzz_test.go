@@ -0,0 +1,406 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// FuzzTokenModel runs sequences of token and catalog calls from several
+// accounts against EmeraldToken and checks the contract against tokenModel
+// after every call. A fuzz input is a sequence of four-byte calls, see
+// decodeTokenCalls. go test -fuzz minimizes a failing sequence and saves it
+// under testdata/fuzz/FuzzTokenModel, where plain go test replays it; commit
+// it along with the fix.
+func FuzzTokenModel(f *testing.F) {
+	for _, seed := range [][]tokenCall{
+		{
+			{kind: callTransfer, actor: 0, target: 1, arg: 5},
+			{kind: callTransfer, actor: 1, target: 2, arg: 6},
+			{kind: callTransfer, actor: 2, target: 3, arg: 1},
+		},
+		{
+			{kind: callApprove, actor: 0, target: 1, arg: 3},
+			{kind: callTransferFrom, actor: 1, target: 2, arg: 2},
+			{kind: callTransferFrom, actor: 1, target: 2, arg: 2},
+			{kind: callIncreaseAllowance, actor: 0, target: 1, arg: 1},
+			{kind: callDecreaseAllowance, actor: 0, target: 1, arg: 3},
+		},
+		{
+			{kind: callMint, actor: 1, target: 1, arg: 9},
+			{kind: callMint, actor: 0, target: 2, arg: 200},
+			{kind: callMint, actor: 0, target: 3, arg: 1},
+		},
+		{
+			{kind: callAddFilm, actor: 1, target: 2, arg: 1},
+			{kind: callAddFilm, actor: 2, target: 0, arg: 1},
+			{kind: callAddFilm, actor: 0, target: 3, arg: 3},
+			{kind: callDeleteFilm, actor: 0, arg: 1},
+			{kind: callAddFilm, actor: 0, target: 1, arg: 4},
+		},
+	} {
+		f.Add(encodeTokenCalls(seed))
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		calls := decodeTokenCalls(data)
+		if len(calls) == 0 {
+			return
+		}
+		env := newTokenEnv(t)
+		model := newTokenModel(env)
+		// A fixed gas limit skips the estimation, so calls that revert are
+		// mined and their status compared too.
+		for _, auth := range env.accounts() {
+			auth.GasLimit = 1_000_000
+		}
+		catalog := make(map[string]storedFilm)
+		for i, call := range calls {
+			receipt := env.run(call)
+			want := model.apply(call)
+			if got := receipt.Status == types.ReceiptStatusSuccessful; got != want {
+				t.Fatalf("call %d, %v: succeeded %v, the model says %v", i, call, got, want)
+			}
+			events, err := decodeEvents(&env.token.MainFilterer, env.address, receipt)
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, ev := range events.FilmsAdded {
+				catalog[ev.Title] = storedFilm{Title: ev.Title, Year: ev.Year, Genre: ev.Genre}
+			}
+			for _, ev := range events.FilmsDeleted {
+				delete(catalog, ev.Title)
+			}
+			if err := model.check(env, catalog); err != nil {
+				t.Fatalf("after call %d, %v: %v", i, call, err)
+			}
+		}
+	})
+}
+
+// Kinds of tokenCall.
+const (
+	callTransfer = iota
+	callApprove
+	callTransferFrom
+	callIncreaseAllowance
+	callDecreaseAllowance
+	callMint
+	callAddFilm
+	callDeleteFilm
+	callKinds
+)
+
+var callNames = [callKinds]string{
+	"transfer", "approve", "transferFrom", "increaseAllowance", "decreaseAllowance", "mint", "addFilm", "deleteFilm",
+}
+
+// modelAccounts are the accounts a call can name: the owner, alice, bob and
+// the zero address, which only ever appears as a target.
+const modelAccounts = 4
+
+// fuzzTitles are the titles films are added and deleted under. They cover
+// the storage encodings of short and long strings on either side of 32
+// bytes, and the empty title.
+var fuzzTitles = []string{
+	"",
+	"Alien",
+	strings.Repeat("a", 31),
+	strings.Repeat("b", 32),
+	strings.Repeat("c", 100),
+}
+
+// tokenCall is one contract call of a fuzzed sequence. The actor sends it;
+// target is the recipient, spender or, for addFilm, the genre. For
+// transferFrom the actor spends the allowance the owner account gave it. arg
+// is the amount in thousands, or selects the title and year of a film.
+type tokenCall struct {
+	kind   int
+	actor  int
+	target int
+	arg    byte
+}
+
+func (c tokenCall) amount() *big.Int {
+	return big.NewInt(int64(c.arg) * 1000)
+}
+
+func (c tokenCall) title() string {
+	return fuzzTitles[int(c.arg)%len(fuzzTitles)]
+}
+
+func (c tokenCall) year() *big.Int {
+	// Never zero, so that an added film is never mistaken for an empty entry.
+	return big.NewInt(1900 + int64(c.arg))
+}
+
+func (c tokenCall) String() string {
+	switch c.kind {
+	case callAddFilm:
+		return fmt.Sprintf("%d.addFilm(%q, %s, %d)", c.actor, c.title(), c.year(), c.target)
+	case callDeleteFilm:
+		return fmt.Sprintf("%d.deleteFilm(%q)", c.actor, c.title())
+	default:
+		return fmt.Sprintf("%d.%s(%d, %s)", c.actor, callNames[c.kind], c.target, c.amount())
+	}
+}
+
+// decodeTokenCalls splits data into calls of four bytes each: kind, actor,
+// target and arg, reduced to their ranges. A trailing partial call is
+// ignored, and so is anything past 32 calls, which keeps runs fast enough
+// for the fuzzer to minimize.
+func decodeTokenCalls(data []byte) []tokenCall {
+	var calls []tokenCall
+	for ; len(data) >= 4 && len(calls) < 32; data = data[4:] {
+		calls = append(calls, tokenCall{
+			kind:   int(data[0]) % callKinds,
+			actor:  int(data[1]) % (modelAccounts - 1),
+			target: int(data[2]) % modelAccounts,
+			arg:    data[3],
+		})
+	}
+	return calls
+}
+
+func encodeTokenCalls(calls []tokenCall) []byte {
+	var data []byte
+	for _, c := range calls {
+		data = append(data, byte(c.kind), byte(c.actor), byte(c.target), c.arg)
+	}
+	return data
+}
+
+// accounts returns the options of the owner, alice and bob, in the order
+// calls number them.
+func (env *tokenEnv) accounts() []*bind.TransactOpts {
+	return []*bind.TransactOpts{env.owner, env.alice, env.bob}
+}
+
+// account returns the account a call names by index; the last is the zero
+// address.
+func (env *tokenEnv) account(i int) common.Address {
+	if accounts := env.accounts(); i < len(accounts) {
+		return accounts[i].From
+	}
+	return common.Address{}
+}
+
+// run sends call, mines it and returns the receipt, whether it reverted or
+// not.
+func (env *tokenEnv) run(call tokenCall) *types.Receipt {
+	t := env.t
+	t.Helper()
+	auth := env.accounts()[call.actor]
+	target := env.account(call.target)
+	var tx *types.Transaction
+	var err error
+	switch call.kind {
+	case callTransfer:
+		tx, err = env.token.Transfer(auth, target, call.amount())
+	case callApprove:
+		tx, err = env.token.Approve(auth, target, call.amount())
+	case callTransferFrom:
+		tx, err = env.token.TransferFrom(auth, env.owner.From, target, call.amount())
+	case callIncreaseAllowance:
+		tx, err = env.token.IncreaseAllowance(auth, target, call.amount())
+	case callDecreaseAllowance:
+		tx, err = env.token.DecreaseAllowance(auth, target, call.amount())
+	case callMint:
+		tx, err = env.token.Mint(auth, target, call.amount())
+	case callAddFilm:
+		tx, err = env.token.AddFilm(auth, call.title(), call.year(), uint8(call.target))
+	case callDeleteFilm:
+		tx, err = env.token.DeleteFilm(auth, call.title())
+	}
+	if err != nil {
+		t.Fatalf("sending %v: %v", call, err)
+	}
+	env.backend.Commit()
+	receipt, err := env.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return receipt
+}
+
+// tokenModel is a plain Go reference for the state of EmeraldToken: the
+// ERC-20 ledger of OpenZeppelin 4.8, minting restricted to the owner, and a
+// catalog anyone may edit.
+type tokenModel struct {
+	owner      common.Address
+	supply     *big.Int
+	balances   map[common.Address]*big.Int
+	allowances map[[2]common.Address]*big.Int // by owner and spender
+	films      map[string]storedFilm
+	accounts   []common.Address
+}
+
+func newTokenModel(env *tokenEnv) *tokenModel {
+	m := &tokenModel{
+		owner:      env.owner.From,
+		supply:     big.NewInt(testSupply),
+		balances:   map[common.Address]*big.Int{env.owner.From: big.NewInt(testSupply)},
+		allowances: make(map[[2]common.Address]*big.Int),
+		films:      make(map[string]storedFilm),
+	}
+	for i := 0; i < modelAccounts; i++ {
+		m.accounts = append(m.accounts, env.account(i))
+	}
+	return m
+}
+
+func (m *tokenModel) balance(account common.Address) *big.Int {
+	if b, ok := m.balances[account]; ok {
+		return b
+	}
+	return new(big.Int)
+}
+
+func (m *tokenModel) allowance(owner, spender common.Address) *big.Int {
+	if a, ok := m.allowances[[2]common.Address{owner, spender}]; ok {
+		return a
+	}
+	return new(big.Int)
+}
+
+// transfer moves amount from one account to another, reporting false and
+// changing nothing where ERC20._transfer reverts.
+func (m *tokenModel) transfer(from, to common.Address, amount *big.Int) bool {
+	if to == (common.Address{}) || m.balance(from).Cmp(amount) < 0 {
+		return false
+	}
+	m.balances[from] = new(big.Int).Sub(m.balance(from), amount)
+	m.balances[to] = new(big.Int).Add(m.balance(to), amount)
+	return true
+}
+
+func (m *tokenModel) approve(owner, spender common.Address, amount *big.Int) bool {
+	if spender == (common.Address{}) {
+		return false
+	}
+	m.allowances[[2]common.Address{owner, spender}] = amount
+	return true
+}
+
+// apply executes call on the model and reports whether the contract should
+// accept it.
+func (m *tokenModel) apply(call tokenCall) bool {
+	actor, target, amount := m.accounts[call.actor], m.accounts[call.target], call.amount()
+	switch call.kind {
+	case callTransfer:
+		return m.transfer(actor, target, amount)
+	case callApprove:
+		return m.approve(actor, target, amount)
+	case callTransferFrom:
+		allowance := m.allowance(m.owner, actor)
+		if allowance.Cmp(amount) < 0 || target == (common.Address{}) || m.balance(m.owner).Cmp(amount) < 0 {
+			return false
+		}
+		m.allowances[[2]common.Address{m.owner, actor}] = new(big.Int).Sub(allowance, amount)
+		return m.transfer(m.owner, target, amount)
+	case callIncreaseAllowance:
+		return m.approve(actor, target, new(big.Int).Add(m.allowance(actor, target), amount))
+	case callDecreaseAllowance:
+		allowance := m.allowance(actor, target)
+		if allowance.Cmp(amount) < 0 {
+			return false
+		}
+		return m.approve(actor, target, new(big.Int).Sub(allowance, amount))
+	case callMint:
+		if actor != m.owner || target == (common.Address{}) {
+			return false
+		}
+		m.supply = new(big.Int).Add(m.supply, amount)
+		m.balances[target] = new(big.Int).Add(m.balance(target), amount)
+		return true
+	case callAddFilm:
+		// Genre is an enum of three values.
+		if call.target >= 3 {
+			return false
+		}
+		m.films[call.title()] = storedFilm{Title: call.title(), Year: call.year(), Genre: uint8(call.target)}
+		return true
+	case callDeleteFilm:
+		delete(m.films, call.title())
+		return true
+	}
+	return false
+}
+
+// check compares the contract with the model: every balance and allowance,
+// the supply, which must also equal the sum of the balances, the catalog in
+// storage and the catalog replayed from the events.
+func (m *tokenModel) check(env *tokenEnv, events map[string]storedFilm) error {
+	supply, err := env.token.TotalSupply(nil)
+	if err != nil {
+		return err
+	}
+	if supply.Cmp(m.supply) != 0 {
+		return fmt.Errorf("total supply %s, model %s", supply, m.supply)
+	}
+	sum := new(big.Int)
+	for i, account := range m.accounts {
+		balance, err := env.token.BalanceOf(nil, account)
+		if err != nil {
+			return err
+		}
+		if balance.Cmp(m.balance(account)) != 0 {
+			return fmt.Errorf("balance of account %d %s, model %s", i, balance, m.balance(account))
+		}
+		sum.Add(sum, balance)
+		for j, spender := range m.accounts {
+			allowance, err := env.token.Allowance(nil, account, spender)
+			if err != nil {
+				return err
+			}
+			if allowance.Cmp(m.allowance(account, spender)) != 0 {
+				return fmt.Errorf("allowance of %d to %d %s, model %s", i, j, allowance, m.allowance(account, spender))
+			}
+		}
+	}
+	if sum.Cmp(supply) != 0 {
+		return fmt.Errorf("balances add up to %s, total supply %s", sum, supply)
+	}
+
+	storage := &filmStorage{reader: env.backend, address: env.address}
+	for _, title := range fuzzTitles {
+		stored, err := storage.film(context.Background(), title, nil)
+		if err != nil {
+			return err
+		}
+		want, ok := m.films[title]
+		if err := sameFilm(stored, want, ok); err != nil {
+			return fmt.Errorf("%q in storage: %v", title, err)
+		}
+		replayed, inEvents := events[title]
+		var fromEvents *storedFilm
+		if inEvents {
+			fromEvents = &replayed
+		}
+		if err := sameFilm(fromEvents, want, ok); err != nil {
+			return fmt.Errorf("%q in the events: %v", title, err)
+		}
+	}
+	return nil
+}
+
+// sameFilm compares a film read back with the model's, which exists when ok.
+func sameFilm(got *storedFilm, want storedFilm, ok bool) error {
+	switch {
+	case got == nil && !ok:
+		return nil
+	case got == nil:
+		return fmt.Errorf("missing, model has year=%s genre=%d", want.Year, want.Genre)
+	case !ok:
+		return fmt.Errorf("year=%s genre=%d, model has none", got.Year, got.Genre)
+	case got.Title != want.Title || got.Year.Cmp(want.Year) != 0 || got.Genre != want.Genre:
+		return fmt.Errorf("%q year=%s genre=%d, model has %q year=%s genre=%d", got.Title, got.Year, got.Genre, want.Title, want.Year, want.Genre)
+	}
+	return nil
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"sort"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// Fee modes selectable with --fee-mode.
+const (
+	feeModeAuto   = "auto"   // EIP-1559 when the chain has a base fee, legacy otherwise
+	feeModeLondon = "london" // always EIP-1559, fail on pre-London chains
+	feeModeLegacy = "legacy" // always a single gasPrice
+)
+
+const (
+	// feeHistoryBlocks is how many recent blocks are sampled for priority fees.
+	feeHistoryBlocks = 10
+	// feeHistoryPercentile is the reward percentile taken from each block.
+	feeHistoryPercentile = 50
+	// baseFeeMultiplier leaves room for the base fee to rise for a few blocks
+	// before the transaction becomes unincludable.
+	baseFeeMultiplier = 2
+)
+
+// gasMarginBackend wraps a contract backend and pads every gas estimate by
+// marginPercent, so bindings that leave GasLimit at zero still get headroom.
+type gasMarginBackend struct {
+	bind.ContractBackend
+	marginPercent uint64
+}
+
+func (b *gasMarginBackend) EstimateGas(ctx context.Context, call ethereum.CallMsg) (uint64, error) {
+	gas, err := b.ContractBackend.EstimateGas(ctx, call)
+	if err != nil {
+		return 0, err
+	}
+	return gas + gas*b.marginPercent/100, nil
+}
+
+// feeBackend is the part of a node client needed to price transactions.
+type feeBackend interface {
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	SuggestGasTipCap(ctx context.Context) (*big.Int, error)
+}
+
+// feeHistoryBackend is implemented by clients that support eth_feeHistory.
+type feeHistoryBackend interface {
+	FeeHistory(ctx context.Context, blockCount uint64, lastBlock *big.Int, rewardPercentiles []float64) (*ethereum.FeeHistory, error)
+}
+
+// txFees holds either a legacy gas price or a pair of EIP-1559 caps.
+type txFees struct {
+	gasPrice  *big.Int
+	gasFeeCap *big.Int
+	gasTipCap *big.Int
+}
+
+func (f *txFees) apply(opts *bind.TransactOpts) {
+	opts.GasPrice = f.gasPrice
+	opts.GasFeeCap = f.gasFeeCap
+	opts.GasTipCap = f.gasTipCap
+}
+
+func (f *txFees) String() string {
+	if f.gasPrice != nil {
+		return fmt.Sprintf("gasPrice=%s", f.gasPrice)
+	}
+	return fmt.Sprintf("maxFeePerGas=%s maxPriorityFeePerGas=%s", f.gasFeeCap, f.gasTipCap)
+}
+
+// suggestFees prices a transaction according to mode.
+func suggestFees(ctx context.Context, backend feeBackend, mode string) (*txFees, error) {
+	switch mode {
+	case feeModeLegacy:
+		return legacyFees(ctx, backend)
+	case feeModeAuto, feeModeLondon, "":
+	default:
+		return nil, fmt.Errorf("unknown fee mode %q (want %s, %s or %s)", mode, feeModeAuto, feeModeLondon, feeModeLegacy)
+	}
+
+	head, err := backend.HeaderByNumber(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	if head.BaseFee == nil {
+		if mode == feeModeLondon {
+			return nil, errors.New("fee mode london requested, but the chain has no base fee")
+		}
+		return legacyFees(ctx, backend)
+	}
+
+	baseFee, tip, err := feeHistory(ctx, backend)
+	if err != nil {
+		return nil, err
+	}
+	if baseFee == nil {
+		baseFee = head.BaseFee
+	}
+	if tip == nil {
+		if tip, err = backend.SuggestGasTipCap(ctx); err != nil {
+			return nil, err
+		}
+	}
+
+	feeCap := new(big.Int).Mul(baseFee, big.NewInt(baseFeeMultiplier))
+	feeCap.Add(feeCap, tip)
+	return &txFees{gasFeeCap: feeCap, gasTipCap: tip}, nil
+}
+
+func legacyFees(ctx context.Context, backend feeBackend) (*txFees, error) {
+	price, err := backend.SuggestGasPrice(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &txFees{gasPrice: price}, nil
+}
+
+// feeHistory returns the base fee of the next block and the median of the
+// recent priority fees. Either is nil when the backend cannot provide it, in
+// which case the caller falls back to the head block and SuggestGasTipCap.
+func feeHistory(ctx context.Context, backend feeBackend) (*big.Int, *big.Int, error) {
+	history, ok := backend.(feeHistoryBackend)
+	if !ok {
+		return nil, nil, nil
+	}
+	fh, err := history.FeeHistory(ctx, feeHistoryBlocks, nil, []float64{feeHistoryPercentile})
+	if err != nil {
+		// Not every provider serves eth_feeHistory; fall back rather than fail.
+		return nil, nil, nil
+	}
+
+	var baseFee *big.Int
+	if n := len(fh.BaseFee); n > 0 {
+		// The last entry is the base fee of the block after the newest one.
+		baseFee = fh.BaseFee[n-1]
+	}
+
+	var rewards []*big.Int
+	for _, reward := range fh.Reward {
+		if len(reward) > 0 && reward[0] != nil && reward[0].Sign() > 0 {
+			rewards = append(rewards, reward[0])
+		}
+	}
+	if len(rewards) == 0 {
+		return baseFee, nil, nil
+	}
+	sort.Slice(rewards, func(i, j int) bool { return rewards[i].Cmp(rewards[j]) < 0 })
+	return baseFee, new(big.Int).Set(rewards[len(rewards)/2]), nil
+}
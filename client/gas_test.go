@@ -0,0 +1,178 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// fakeFees is a feeBackend with a fixed head base fee, gas price and tip.
+type fakeFees struct {
+	baseFee  *big.Int // nil for a pre-London chain
+	gasPrice int64
+	tip      int64
+}
+
+func (f *fakeFees) HeaderByNumber(context.Context, *big.Int) (*types.Header, error) {
+	return &types.Header{Number: big.NewInt(100), BaseFee: f.baseFee}, nil
+}
+
+func (f *fakeFees) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(f.gasPrice), nil
+}
+
+func (f *fakeFees) SuggestGasTipCap(context.Context) (*big.Int, error) {
+	return big.NewInt(f.tip), nil
+}
+
+// fakeFeeHistory adds eth_feeHistory to fakeFees.
+type fakeFeeHistory struct {
+	fakeFees
+	history *ethereum.FeeHistory
+	err     error
+}
+
+func (f *fakeFeeHistory) FeeHistory(context.Context, uint64, *big.Int, []float64) (*ethereum.FeeHistory, error) {
+	return f.history, f.err
+}
+
+// rewards builds the reward column of a fee history, one block per fee; a
+// negative fee stands for a block without a reward.
+func rewards(fees ...int64) [][]*big.Int {
+	reward := make([][]*big.Int, len(fees))
+	for i, fee := range fees {
+		if fee >= 0 {
+			reward[i] = []*big.Int{big.NewInt(fee)}
+		}
+	}
+	return reward
+}
+
+func bigs(xs ...int64) []*big.Int {
+	out := make([]*big.Int, len(xs))
+	for i, x := range xs {
+		out[i] = big.NewInt(x)
+	}
+	return out
+}
+
+func TestSuggestFees(t *testing.T) {
+	london := fakeFees{baseFee: big.NewInt(100), gasPrice: 150, tip: 7}
+	preLondon := fakeFees{gasPrice: 150, tip: 7}
+
+	for _, tc := range []struct {
+		name    string
+		backend feeBackend
+		mode    string
+		want    string
+		wantErr bool
+	}{
+		{"legacy", &london, feeModeLegacy, "gasPrice=150", false},
+		{"auto pre-london", &preLondon, feeModeAuto, "gasPrice=150", false},
+		{"london pre-london", &preLondon, feeModeLondon, "", true},
+		{"unknown mode", &london, "cheap", "", true},
+		{"head base fee and suggested tip", &london, feeModeAuto, "maxFeePerGas=207 maxPriorityFeePerGas=7", false},
+		{"default mode", &london, "", "maxFeePerGas=207 maxPriorityFeePerGas=7", false},
+		{
+			"next base fee and median reward",
+			&fakeFeeHistory{fakeFees: london, history: &ethereum.FeeHistory{
+				BaseFee: bigs(90, 95, 120),
+				Reward:  rewards(3, 1, 2),
+			}},
+			feeModeLondon, "maxFeePerGas=242 maxPriorityFeePerGas=2", false,
+		},
+		{
+			"upper median of an even count",
+			&fakeFeeHistory{fakeFees: london, history: &ethereum.FeeHistory{
+				BaseFee: bigs(100, 100),
+				Reward:  rewards(4, 1, 3, 2),
+			}},
+			feeModeAuto, "maxFeePerGas=203 maxPriorityFeePerGas=3", false,
+		},
+		{
+			"empty blocks are skipped",
+			&fakeFeeHistory{fakeFees: london, history: &ethereum.FeeHistory{
+				BaseFee: bigs(50),
+				Reward:  rewards(0, -1, 5, 0),
+			}},
+			feeModeAuto, "maxFeePerGas=105 maxPriorityFeePerGas=5", false,
+		},
+		{
+			"no rewards falls back to the suggested tip",
+			&fakeFeeHistory{fakeFees: london, history: &ethereum.FeeHistory{
+				BaseFee: bigs(50),
+				Reward:  rewards(0, 0),
+			}},
+			feeModeAuto, "maxFeePerGas=107 maxPriorityFeePerGas=7", false,
+		},
+		{
+			"fee history unsupported",
+			&fakeFeeHistory{fakeFees: london, err: errors.New("the method eth_feeHistory does not exist")},
+			feeModeAuto, "maxFeePerGas=207 maxPriorityFeePerGas=7", false,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			fees, err := suggestFees(context.Background(), tc.backend, tc.mode)
+			if tc.wantErr {
+				if err == nil {
+					t.Fatalf("got %v, want an error", fees)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got := fees.String(); got != tc.want {
+				t.Errorf("fees %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestTxFeesApply(t *testing.T) {
+	opts := &bind.TransactOpts{GasPrice: big.NewInt(1)}
+	(&txFees{gasFeeCap: big.NewInt(30), gasTipCap: big.NewInt(2)}).apply(opts)
+	if opts.GasPrice != nil || opts.GasFeeCap.Int64() != 30 || opts.GasTipCap.Int64() != 2 {
+		t.Errorf("EIP-1559 fees applied as gasPrice=%v feeCap=%v tipCap=%v", opts.GasPrice, opts.GasFeeCap, opts.GasTipCap)
+	}
+	(&txFees{gasPrice: big.NewInt(40)}).apply(opts)
+	if opts.GasPrice.Int64() != 40 || opts.GasFeeCap != nil || opts.GasTipCap != nil {
+		t.Errorf("legacy fees applied as gasPrice=%v feeCap=%v tipCap=%v", opts.GasPrice, opts.GasFeeCap, opts.GasTipCap)
+	}
+}
+
+// fixedEstimate is a contract backend whose estimates are always gas.
+type fixedEstimate struct {
+	bind.ContractBackend
+	gas uint64
+}
+
+func (f fixedEstimate) EstimateGas(context.Context, ethereum.CallMsg) (uint64, error) {
+	return f.gas, nil
+}
+
+func TestGasMargin(t *testing.T) {
+	for _, tc := range []struct {
+		gas, margin, want uint64
+	}{
+		{21000, 0, 21000},
+		{21000, 20, 25200},
+		{100_000, 20, 120_000},
+		{99, 20, 118},
+		{50_000, 100, 100_000},
+	} {
+		backend := &gasMarginBackend{ContractBackend: fixedEstimate{gas: tc.gas}, marginPercent: tc.margin}
+		got, err := backend.EstimateGas(context.Background(), ethereum.CallMsg{})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got != tc.want {
+			t.Errorf("%d gas with a %d%% margin: %d, want %d", tc.gas, tc.margin, got, tc.want)
+		}
+	}
+}
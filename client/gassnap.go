@@ -0,0 +1,229 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"strconv"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+// defaultGasSnapshot is the checked-in snapshot that `emerald gas snapshot`
+// and TestGasSnapshot compare with, relative to the client directory.
+const defaultGasSnapshot = "gas.snapshot"
+
+// gasTitleLengths are the title lengths, in bytes, addFilm and deleteFilm are
+// measured with: the longest title that fits in one slot, the shortest that
+// does not, and long ones whose cost grows with every 32 bytes.
+var gasTitleLengths = []int{1, 31, 32, 100, 1000}
+
+// gasMeasurement is the gas a scenario's transaction used.
+type gasMeasurement struct {
+	Name string
+	Gas  uint64
+}
+
+// gasRun records the gas of the scenarios as they are mined.
+type gasRun struct {
+	backend  *backends.SimulatedBackend
+	measured []gasMeasurement
+}
+
+// measureGas deploys the token and runs the scenarios in order: deploy,
+// transfer and approve to a fresh account, mint, then addFilm and deleteFilm
+// for every title length. Each scenario starts from the state the previous
+// ones left. The accounts are the devnet's, so every run sends the same
+// calldata to the same addresses and uses exactly the same gas.
+func measureGas() ([]gasMeasurement, error) {
+	accounts, err := devnetAccounts(devnetMnemonic, 3)
+	if err != nil {
+		return nil, err
+	}
+	alloc := make(core.GenesisAlloc, len(accounts))
+	for _, a := range accounts {
+		alloc[a.address] = core.GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 30_000_000)
+	defer backend.Close()
+
+	owner, err := bind.NewKeyedTransactorWithChainID(accounts[0].key, devnetChainID)
+	if err != nil {
+		return nil, err
+	}
+	owner.Context = context.Background()
+	alice, bob := accounts[1].address, accounts[2].address
+	r := &gasRun{backend: backend}
+
+	_, tx, token, err := DeployMain(owner, backend, big.NewInt(1_000_000))
+	if err := r.measure("deploy", tx, err); err != nil {
+		return nil, err
+	}
+
+	tx, err = token.Transfer(owner, alice, big.NewInt(1000))
+	if err := r.measure("transfer", tx, err); err != nil {
+		return nil, err
+	}
+	tx, err = token.Approve(owner, alice, big.NewInt(1000))
+	if err := r.measure("approve", tx, err); err != nil {
+		return nil, err
+	}
+	tx, err = token.Mint(owner, bob, big.NewInt(1000))
+	if err := r.measure("mint", tx, err); err != nil {
+		return nil, err
+	}
+	for _, n := range gasTitleLengths {
+		tx, err = token.AddFilm(owner, gasTitle(n), big.NewInt(2000), 2)
+		if err := r.measure(fmt.Sprintf("addFilm/%d", n), tx, err); err != nil {
+			return nil, err
+		}
+	}
+	for _, n := range gasTitleLengths {
+		tx, err = token.DeleteFilm(owner, gasTitle(n))
+		if err := r.measure(fmt.Sprintf("deleteFilm/%d", n), tx, err); err != nil {
+			return nil, err
+		}
+	}
+	return r.measured, nil
+}
+
+// gasTitle returns a title of n bytes.
+func gasTitle(n int) string {
+	return strings.Repeat("F", n)
+}
+
+// measure mines tx, sent with error err, and records the gas it used.
+func (r *gasRun) measure(name string, tx *types.Transaction, err error) error {
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	r.backend.Commit()
+	receipt, err := r.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		return fmt.Errorf("%s: %w", name, err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		return fmt.Errorf("%s: transaction reverted", name)
+	}
+	r.measured = append(r.measured, gasMeasurement{Name: name, Gas: receipt.GasUsed})
+	return nil
+}
+
+// readGasSnapshot parses a snapshot: one scenario per line, its name and the
+// gas it used, with # starting a comment.
+func readGasSnapshot(path string) ([]gasMeasurement, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var snapshot []gasMeasurement
+	scanner := bufio.NewScanner(f)
+	for line := 1; scanner.Scan(); line++ {
+		text := strings.TrimSpace(scanner.Text())
+		if text == "" || strings.HasPrefix(text, "#") {
+			continue
+		}
+		fields := strings.Fields(text)
+		if len(fields) != 2 {
+			return nil, fmt.Errorf("%s:%d: want a scenario name and its gas, got %q", path, line, text)
+		}
+		gas, err := strconv.ParseUint(fields[1], 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: invalid gas %q", path, line, fields[1])
+		}
+		snapshot = append(snapshot, gasMeasurement{Name: fields[0], Gas: gas})
+	}
+	return snapshot, scanner.Err()
+}
+
+func writeGasSnapshot(path string, measured []gasMeasurement) error {
+	var b strings.Builder
+	b.WriteString("# Gas used by the EmeraldToken scenarios of measureGas. After an intended\n")
+	b.WriteString("# change, regenerate with `emerald gas snapshot --update` or\n")
+	b.WriteString("# `go test -run TestGasSnapshot -update-gas`.\n")
+	for _, m := range measured {
+		fmt.Fprintf(&b, "%s %d\n", m.Name, m.Gas)
+	}
+	return os.WriteFile(path, []byte(b.String()), 0o644)
+}
+
+// diffGas prints how the measured gas compares with the snapshot and fails
+// when a scenario grew by more than tolerance percent, or when the scenarios
+// themselves changed. Savings never fail, but are worth recording.
+func diffGas(w io.Writer, snapshot, measured []gasMeasurement, tolerance float64) error {
+	recorded := make(map[string]uint64, len(snapshot))
+	for _, m := range snapshot {
+		recorded[m.Name] = m.Gas
+	}
+	var grew, added, changed int
+	for _, m := range measured {
+		old, ok := recorded[m.Name]
+		delete(recorded, m.Name)
+		switch {
+		case !ok:
+			added++
+			fmt.Fprintf(w, "%-16s %10s -> %-10d new scenario\n", m.Name, "", m.Gas)
+		case m.Gas == old:
+			fmt.Fprintf(w, "%-16s %10d\n", m.Name, m.Gas)
+		default:
+			changed++
+			percent := (float64(m.Gas) - float64(old)) * 100 / float64(old)
+			note := ""
+			if percent > tolerance {
+				grew++
+				note = fmt.Sprintf("  over the %g%% tolerance", tolerance)
+			}
+			fmt.Fprintf(w, "%-16s %10d -> %-10d %+.2f%%%s\n", m.Name, old, m.Gas, percent, note)
+		}
+	}
+	for _, m := range snapshot {
+		if _, gone := recorded[m.Name]; gone {
+			fmt.Fprintf(w, "%-16s %10d -> %-10s scenario is gone\n", m.Name, m.Gas, "")
+		}
+	}
+
+	switch {
+	case grew > 0:
+		return fmt.Errorf("gas of %d scenarios grew more than %g%%", grew, tolerance)
+	case added > 0 || len(recorded) > 0:
+		return fmt.Errorf("the snapshot is out of date: %d scenarios are new and %d are gone; update it", added, len(recorded))
+	case changed > 0:
+		fmt.Fprintf(w, "gas of %d scenarios changed within the tolerance; update the snapshot to record it\n", changed)
+	}
+	return nil
+}
+
+// checkGasSnapshot measures the scenarios and compares them with the
+// snapshot at path, or rewrites it when update is set.
+func checkGasSnapshot(w io.Writer, path string, tolerance float64, update bool) error {
+	measured, err := measureGas()
+	if err != nil {
+		return err
+	}
+	if update {
+		if err := writeGasSnapshot(path, measured); err != nil {
+			return err
+		}
+		fmt.Fprintf(w, "wrote the gas of %d scenarios to %s\n", len(measured), path)
+		return nil
+	}
+	snapshot, err := readGasSnapshot(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return fmt.Errorf("no gas snapshot at %s; create it with --update", path)
+	}
+	if err != nil {
+		return err
+	}
+	return diffGas(w, snapshot, measured, tolerance)
+}
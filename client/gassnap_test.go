@@ -0,0 +1,60 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"strings"
+	"testing"
+)
+
+var updateGas = flag.Bool("update-gas", false, "rewrite "+defaultGasSnapshot+" with the measured gas")
+
+// gasTolerance is the growth in percent TestGasSnapshot accepts, the default
+// of `emerald gas snapshot`.
+const gasTolerance = 1
+
+// TestGasSnapshot fails when a scenario of measureGas uses more gas than
+// gas.snapshot records. After an intended change, run
+//
+//	go test -run TestGasSnapshot -update-gas
+//
+// and commit the new snapshot.
+func TestGasSnapshot(t *testing.T) {
+	var out bytes.Buffer
+	err := checkGasSnapshot(&out, defaultGasSnapshot, gasTolerance, *updateGas)
+	t.Log("\n" + out.String())
+	if err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestDiffGas(t *testing.T) {
+	snapshot := []gasMeasurement{{"deploy", 1000}, {"transfer", 100}}
+	for _, tc := range []struct {
+		name     string
+		measured []gasMeasurement
+		fail     string // substring of the error, empty when the diff passes
+		output   string // substring of the output
+	}{
+		{"unchanged", []gasMeasurement{{"deploy", 1000}, {"transfer", 100}}, "", "transfer"},
+		{"within tolerance", []gasMeasurement{{"deploy", 1010}, {"transfer", 100}}, "", "+1.00%"},
+		{"over tolerance", []gasMeasurement{{"deploy", 1011}, {"transfer", 100}}, "1 scenarios grew", "over the 1% tolerance"},
+		{"savings", []gasMeasurement{{"deploy", 500}, {"transfer", 50}}, "", "-50.00%"},
+		{"new scenario", []gasMeasurement{{"deploy", 1000}, {"transfer", 100}, {"mint", 10}}, "1 scenarios are new", "new scenario"},
+		{"gone scenario", []gasMeasurement{{"deploy", 1000}}, "0 scenarios are new and 1 are gone", "scenario is gone"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			var out bytes.Buffer
+			err := diffGas(&out, snapshot, tc.measured, gasTolerance)
+			switch {
+			case tc.fail == "" && err != nil:
+				t.Errorf("diff failed: %v", err)
+			case tc.fail != "" && (err == nil || !strings.Contains(err.Error(), tc.fail)):
+				t.Errorf("diff returned %v, want an error containing %q", err, tc.fail)
+			}
+			if !strings.Contains(out.String(), tc.output) {
+				t.Errorf("output\n%s\ndoes not contain %q", out.String(), tc.output)
+			}
+		})
+	}
+}
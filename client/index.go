@@ -0,0 +1,272 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"math/big"
+	"os"
+	"sort"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// Storage backends selectable with --store.
+const (
+	storeBolt   = "bolt"
+	storeSQLite = "sqlite"
+	storeMemory = "memory"
+)
+
+// Index files used when neither --index nor $EMERALD_INDEX is set.
+const (
+	defaultIndexPath       = "emerald-index.db"
+	defaultSQLiteIndexPath = "emerald-index.sqlite"
+)
+
+// logRef locates an indexed event on the chain.
+type logRef struct {
+	Block     uint64      `json:"block"`
+	BlockHash common.Hash `json:"blockHash"`
+	TxHash    common.Hash `json:"tx"`
+	LogIndex  uint        `json:"logIndex"`
+}
+
+// transferRecord is an indexed Transfer event.
+type transferRecord struct {
+	logRef
+	From  common.Address `json:"from"`
+	To    common.Address `json:"to"`
+	Value *big.Int       `json:"value"`
+}
+
+// approvalRecord is an indexed Approval event.
+type approvalRecord struct {
+	logRef
+	Owner   common.Address `json:"owner"`
+	Spender common.Address `json:"spender"`
+	Value   *big.Int       `json:"value"`
+}
+
+// balancePoint is the balance of an account after a block that changed it.
+type balancePoint struct {
+	Account common.Address
+	Block   uint64
+	Value   *big.Int
+}
+
+// allowancePoint is the allowance of a spender after a block that changed it.
+type allowancePoint struct {
+	Owner   common.Address
+	Spender common.Address
+	Block   uint64
+	Value   *big.Int
+}
+
+// blockRef is a remembered block.
+type blockRef struct {
+	number uint64
+	hash   common.Hash
+}
+
+// store is where the indexers keep what they materialize. Every backend
+// offers the same operations with the same guarantees, checked by the shared
+// conformance tests, so the indexers do not care which one they run on.
+type store interface {
+	// update runs fn in a transaction that is committed when fn returns nil
+	// and discarded otherwise.
+	update(fn func(storeTx) error) error
+	// view runs fn against a consistent snapshot. fn must not write.
+	view(fn func(storeTx) error) error
+	Close() error
+}
+
+// storeTx is the data of a store as seen from inside a transaction. Events
+// are keyed by their chain position, so putting one twice keeps one copy.
+// Ranges are inclusive and results come in chain order unless noted.
+type storeTx interface {
+	meta(key string) (string, bool, error)
+	setMeta(key, value string) error
+
+	// checkpoint returns the next block the named consumer has to process.
+	checkpoint(name string) (uint64, bool, error)
+	setCheckpoint(name string, next uint64) error
+
+	// blockHash and blockHashes return the hashes remembered for the named
+	// consumer, blockHashes lowest first.
+	blockHash(name string, number uint64) (common.Hash, bool, error)
+	blockHashes(name string) ([]blockRef, error)
+	putBlockHash(name string, number uint64, hash common.Hash) error
+	// deleteBlockHashes forgets the blocks from..to of the named consumer.
+	deleteBlockHashes(name string, from, to uint64) error
+
+	putFilmEvent(r *filmRecord) error
+	filmEvents(from, to uint64) ([]*filmRecord, error)
+	// deleteFilmEvents removes the film events of block from and later
+	// ones and returns them.
+	deleteFilmEvents(from uint64) ([]*filmRecord, error)
+	// lastFilmEvent returns the latest film event of title, or nil.
+	lastFilmEvent(title string) (*filmRecord, error)
+
+	// film returns the catalog entry of title, or nil.
+	film(title string) (*filmRecord, error)
+	putFilm(r *filmRecord) error
+	deleteFilm(title string) error
+	// films returns every catalog entry, tombstones included, in no
+	// particular order.
+	films() ([]*filmRecord, error)
+
+	putTransfer(r *transferRecord) error
+	transfers(from, to uint64) ([]*transferRecord, error)
+	putApproval(r *approvalRecord) error
+	approvals(from, to uint64) ([]*approvalRecord, error)
+
+	// putBalance records the balance of an account after a block, replacing
+	// the one recorded for the same block.
+	putBalance(p *balancePoint) error
+	// balance returns the latest balance of account recorded at or before
+	// block, or nil.
+	balance(account common.Address, block uint64) (*balancePoint, error)
+	// balances returns the latest balance of every account recorded at or
+	// before block, in no particular order.
+	balances(block uint64) ([]*balancePoint, error)
+	putAllowance(p *allowancePoint) error
+	allowance(owner, spender common.Address, block uint64) (*allowancePoint, error)
+	allowances(block uint64) ([]*allowancePoint, error)
+
+	// deleteLedger removes the Transfer and Approval events and the balances
+	// and allowances of block from and later ones.
+	deleteLedger(from uint64) error
+}
+
+// index is a store holding the data of a single contract deployment, recorded
+// on first use, so that pointing the same index at another network fails
+// loudly instead of mixing two catalogs.
+type index struct {
+	store
+}
+
+// indexFlags select the store of the commands that use the index.
+type indexFlags struct {
+	kind string
+	path string
+}
+
+func (f *indexFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&f.kind, "store", "", "index backend: bolt or sqlite (default $EMERALD_STORE or bolt)")
+	fs.StringVar(&f.path, "index", "", "index file (default $EMERALD_INDEX, or "+defaultIndexPath+" for bolt and "+defaultSQLiteIndexPath+" for sqlite)")
+}
+
+// open opens the selected index. A read-only bolt index cannot be shared with
+// a running indexer; a SQLite one can.
+func (f *indexFlags) open(readOnly bool) (*index, error) {
+	kind := f.kind
+	if kind == "" {
+		kind = os.Getenv("EMERALD_STORE")
+	}
+	if kind == "" {
+		kind = storeBolt
+	}
+	path := f.path
+	if path == "" {
+		path = os.Getenv("EMERALD_INDEX")
+	}
+
+	var (
+		st  store
+		err error
+	)
+	switch kind {
+	case storeBolt:
+		if path == "" {
+			path = defaultIndexPath
+		}
+		st, err = openBoltStore(path, readOnly)
+	case storeSQLite:
+		if path == "" {
+			path = defaultSQLiteIndexPath
+		}
+		st, err = openSQLiteStore(path, readOnly)
+	default:
+		return nil, fmt.Errorf("unknown store %q (want %s or %s)", kind, storeBolt, storeSQLite)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &index{store: st}, nil
+}
+
+// missingIndex is the error for a read-only index that does not exist yet.
+func missingIndex(path string, err error) error {
+	return fmt.Errorf("index %s: %w (run emerald film index first)", path, err)
+}
+
+// sourceID identifies the contract at address on chainID.
+func sourceID(chainID uint64, address common.Address) string {
+	return fmt.Sprintf("%d:%s", chainID, address.Hex())
+}
+
+// bindSource ties the index to the contract at address on chainID, or checks
+// that it already belongs to it.
+func (ix *index) bindSource(chainID uint64, address common.Address) error {
+	source := sourceID(chainID, address)
+	return ix.update(func(tx storeTx) error {
+		bound, ok, err := tx.meta("source")
+		if err != nil {
+			return err
+		}
+		if ok {
+			return sourceMismatch(bound, source)
+		}
+		return tx.setMeta("source", source)
+	})
+}
+
+// checkSource checks that the index belongs to the contract at address on
+// chainID, without binding an index that belongs to none yet.
+func (ix *index) checkSource(chainID uint64, address common.Address) error {
+	source := sourceID(chainID, address)
+	return ix.view(func(tx storeTx) error {
+		bound, ok, err := tx.meta("source")
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return fmt.Errorf("index belongs to no contract, so it cannot be checked against %s", source)
+		}
+		return sourceMismatch(bound, source)
+	})
+}
+
+func sourceMismatch(bound, source string) error {
+	if bound != source {
+		return fmt.Errorf("index belongs to contract %s, not to %s", bound, source)
+	}
+	return nil
+}
+
+// checkpoint returns the next block the named consumer has to process, and
+// false when it has never run.
+func (ix *index) checkpoint(name string) (next uint64, found bool, err error) {
+	err = ix.view(func(tx storeTx) error {
+		next, found, err = tx.checkpoint(name)
+		return err
+	})
+	return next, found, err
+}
+
+// films returns the catalog sorted by title. Deleted titles are only included
+// when withDeleted is set.
+func (ix *index) films(withDeleted bool) ([]*filmRecord, error) {
+	var records []*filmRecord
+	err := ix.view(func(tx storeTx) error {
+		all, err := tx.films()
+		for _, r := range all {
+			if withDeleted || !r.Deleted {
+				records = append(records, r)
+			}
+		}
+		return err
+	})
+	sort.Slice(records, func(i, j int) bool { return records[i].Title < records[j].Title })
+	return records, err
+}
@@ -0,0 +1,279 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// reorgDepth is how many blocks below the checkpoint keep their hashes, and so
+// the deepest reorganization an ingester can roll back on its own.
+const reorgDepth = 128
+
+// hashTail is how many blocks at the end of every processed range have their
+// hashes remembered; before that only the blocks with logs are.
+const hashTail = 16
+
+// errReorgTooDeep is returned when none of the remembered block hashes is on
+// the canonical chain any more.
+var errReorgTooDeep = fmt.Errorf("chain reorganized deeper than %d blocks; rebuild the index", reorgDepth)
+
+// logConsumer materializes one kind of contract log into the index.
+type logConsumer interface {
+	// name identifies the consumer's checkpoint.
+	name() string
+	// query selects the consumer's logs; the block range is set per step.
+	query() ethereum.FilterQuery
+	// apply stores logs, which are in chain order.
+	apply(tx storeTx, logs []types.Log) error
+	// rollback discards everything stored for block from and later ones.
+	rollback(tx storeTx, from uint64) error
+}
+
+// chainReader is the part of a node client an ingester needs besides the
+// logs.
+type chainReader interface {
+	BlockNumber(ctx context.Context) (uint64, error)
+	HeaderByNumber(ctx context.Context, number *big.Int) (*types.Header, error)
+}
+
+// ingester feeds the logs of a consumer into the index, one backfill chunk at
+// a time. Its checkpoint is the next block to process together with the hashes
+// of the blocks processed last, so a restart resumes exactly where it stopped
+// and a reorganization is noticed when the next block no longer descends from
+// the remembered one. The consumer is then rolled back to the fork point and
+// the blocks after it are processed again.
+type ingester struct {
+	index    *index
+	chain    chainReader
+	logs     *logBackfill
+	consumer logConsumer
+	out      io.Writer
+}
+
+// sync processes every block from the checkpoint, or from start on the first
+// run, up to the current head.
+func (in *ingester) sync(ctx context.Context, start uint64) error {
+	for {
+		done, err := in.step(ctx, start)
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// errChainMoved aborts a range whose logs and headers disagree because the
+// chain changed while it was read.
+var errChainMoved = errors.New("chain moved while reading")
+
+// errReorged aborts a range whose first block does not descend from the last
+// one processed.
+var errReorged = errors.New("chain reorganized")
+
+// step brings the consumer up to the head once, rolling it back first when
+// the chain reorganized, and reports whether it had already caught up.
+func (in *ingester) step(ctx context.Context, start uint64) (bool, error) {
+	name := in.consumer.name()
+	next, found, err := in.index.checkpoint(name)
+	if err != nil {
+		return false, err
+	}
+	if !found {
+		next = start
+	}
+
+	head, err := in.chain.BlockNumber(ctx)
+	if err != nil {
+		return false, err
+	}
+	if next > head {
+		// There is no next block whose parent could be checked yet, so the
+		// last block processed is compared directly.
+		if next == 0 {
+			return true, nil
+		}
+		remembered, ok, err := in.index.blockHash(name, next-1)
+		if err != nil {
+			return false, err
+		}
+		if !ok {
+			return true, nil
+		}
+		canonical, err := in.hashAt(ctx, next-1)
+		switch {
+		case errors.Is(err, ethereum.NotFound):
+			// The chain got shorter than what was processed, as after a
+			// devnet evm_revert, which is a reorganization too.
+			return false, in.rewind(ctx)
+		case err != nil:
+			return false, err
+		case canonical != remembered:
+			return false, in.rewind(ctx)
+		}
+		return true, nil
+	}
+
+	err = in.logs.run(ctx, in.consumer.query(), next, head, func(from, to uint64, logs []types.Log) error {
+		return in.commit(ctx, from, to, head, logs)
+	})
+	switch {
+	case errors.Is(err, errReorged):
+		return false, in.rewind(ctx)
+	case errors.Is(err, errChainMoved), errors.Is(err, ethereum.NotFound):
+		// A block of the range is gone; the next step starts over from
+		// the checkpoint and rewinds if the chain got shorter.
+		return false, nil
+	default:
+		return false, err
+	}
+}
+
+// commit stores the logs of the blocks from..to and moves the checkpoint past
+// them, after checking that from descends from the last block processed.
+func (in *ingester) commit(ctx context.Context, from, to, head uint64, logs []types.Log) error {
+	name := in.consumer.name()
+	first, err := in.chain.HeaderByNumber(ctx, new(big.Int).SetUint64(from))
+	if err != nil {
+		return err
+	}
+	if from > 0 {
+		remembered, ok, err := in.index.blockHash(name, from-1)
+		if err != nil {
+			return err
+		}
+		if ok && first.ParentHash != remembered {
+			return errReorged
+		}
+	}
+
+	// The end of every range is remembered, and the blocks close to the
+	// head one by one, so that a shallow reorg right after a long backfill
+	// still finds its fork point.
+	hashes := map[uint64]common.Hash{from: first.Hash()}
+	tail := to
+	if head < hashTail {
+		tail = 0
+	} else if head-hashTail+1 < tail {
+		tail = head - hashTail + 1
+	}
+	if tail <= from {
+		tail = from + 1
+	}
+	for number := tail; number <= to; number++ {
+		header, err := in.chain.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+		if err != nil {
+			return err
+		}
+		if parent, ok := hashes[number-1]; ok && header.ParentHash != parent {
+			return errChainMoved
+		}
+		hashes[number] = header.Hash()
+	}
+	for _, log := range logs {
+		if known, ok := hashes[log.BlockNumber]; ok && known != log.BlockHash {
+			return errChainMoved
+		}
+		hashes[log.BlockNumber] = log.BlockHash
+	}
+
+	return in.index.update(func(tx storeTx) error {
+		// Anything already stored for the range, such as events pushed by a
+		// subscription, is replaced by what the range query returned.
+		if err := in.consumer.rollback(tx, from); err != nil {
+			return err
+		}
+		if err := in.consumer.apply(tx, logs); err != nil {
+			return err
+		}
+		if err := putBlockHashes(tx, name, hashes, head); err != nil {
+			return err
+		}
+		return tx.setCheckpoint(name, to+1)
+	})
+}
+
+func (in *ingester) hashAt(ctx context.Context, number uint64) (common.Hash, error) {
+	header, err := in.chain.HeaderByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return header.Hash(), nil
+}
+
+// rewind finds the highest remembered block that is still canonical and rolls
+// the consumer back to it.
+func (in *ingester) rewind(ctx context.Context) error {
+	name := in.consumer.name()
+	remembered, err := in.index.blockHashes(name)
+	if err != nil {
+		return err
+	}
+	fork, found := uint64(0), false
+	for i := len(remembered) - 1; i >= 0; i-- {
+		canonical, err := in.hashAt(ctx, remembered[i].number)
+		if err != nil && !errors.Is(err, ethereum.NotFound) {
+			return err
+		}
+		if err == nil && canonical == remembered[i].hash {
+			fork, found = remembered[i].number, true
+			break
+		}
+	}
+	if !found {
+		return errReorgTooDeep
+	}
+
+	err = in.index.update(func(tx storeTx) error {
+		if err := in.consumer.rollback(tx, fork+1); err != nil {
+			return err
+		}
+		if err := tx.deleteBlockHashes(name, fork+1, math.MaxUint64); err != nil {
+			return err
+		}
+		return tx.setCheckpoint(name, fork+1)
+	})
+	if err != nil {
+		return err
+	}
+	fmt.Fprintf(in.out, "reorg: %s rolled back to block %d\n", name, fork)
+	return nil
+}
+
+// blockHash returns the remembered hash of block number for the consumer.
+func (ix *index) blockHash(name string, number uint64) (hash common.Hash, found bool, err error) {
+	err = ix.view(func(tx storeTx) error {
+		hash, found, err = tx.blockHash(name, number)
+		return err
+	})
+	return hash, found, err
+}
+
+// blockHashes returns the remembered blocks of the consumer, lowest first.
+func (ix *index) blockHashes(name string) (refs []blockRef, err error) {
+	err = ix.view(func(tx storeTx) error {
+		refs, err = tx.blockHashes(name)
+		return err
+	})
+	return refs, err
+}
+
+// putBlockHashes remembers hashes and forgets the blocks more than reorgDepth
+// below head.
+func putBlockHashes(tx storeTx, name string, hashes map[uint64]common.Hash, head uint64) error {
+	for number, hash := range hashes {
+		if err := tx.putBlockHash(name, number, hash); err != nil {
+			return err
+		}
+	}
+	if head <= reorgDepth {
+		return nil
+	}
+	return tx.deleteBlockHashes(name, 0, head-reorgDepth-1)
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// syncBuffer is a bytes.Buffer safe for the writes of a following indexer.
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+// TestFollowRevert reverts the devnet below the blocks a following indexer
+// has processed, so that the chain is shorter than its checkpoint.
+func TestFollowRevert(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	addFilm := func(title string) {
+		t.Helper()
+		tx, err := s.transact("film add "+title, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+			return s.token.AddFilm(auth, title, big.NewInt(1979), 0)
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := s.confirm(tx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	ix := &index{newMemStore()}
+	var out syncBuffer
+	fi := newFilmIndexer(ix, s, &out)
+	titles := func() string {
+		films, err := ix.films(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var titles []string
+		for _, f := range films {
+			titles = append(titles, f.Title)
+		}
+		sort.Strings(titles)
+		return strings.Join(titles, ", ")
+	}
+	waitFor := func(want string, followed <-chan error) {
+		t.Helper()
+		deadline := time.After(5 * time.Second)
+		for titles() != want {
+			select {
+			case err := <-followed:
+				t.Fatalf("follow stopped with %v\n%s", err, out.String())
+			case <-deadline:
+				t.Fatalf("catalog %q, want %q\n%s", titles(), want, out.String())
+			case <-time.After(10 * time.Millisecond):
+			}
+		}
+	}
+
+	addFilm("Alien")
+	snapshot := d.snapshot()
+	addFilm("Brazil")
+	addFilm("Casablanca")
+	if err := fi.sync(context.Background(), d.deployBlock); err != nil {
+		t.Fatal(err)
+	}
+	if got := titles(); got != "Alien, Brazil, Casablanca" {
+		t.Fatalf("catalog %q after the backfill", got)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	followed := make(chan error, 1)
+	go func() { followed <- fi.follow(ctx, 10*time.Millisecond) }()
+
+	if ok, err := d.revert(snapshot); !ok || err != nil {
+		t.Fatalf("revert: %v, %v", ok, err)
+	}
+	waitFor("Alien", followed)
+	if !strings.Contains(out.String(), "reorg:") {
+		t.Errorf("no rollback reported\n%s", out.String())
+	}
+
+	// The next block takes the number of a reverted one. The revert also
+	// took back nonces the session had already used.
+	if err := s.nonces.resync(context.Background(), s.from()); err != nil {
+		t.Fatal(err)
+	}
+	addFilm("Dune")
+	waitFor("Alien, Dune", followed)
+
+	cancel()
+	if err := <-followed; err != nil {
+		t.Errorf("follow returned %v", err)
+	}
+}
+
+// TestFilmIndexer indexes additions, an overwrite and a deletion into a bolt
+// index, reopens it and checks that the next sync resumes at the checkpoint.
+func TestFilmIndexer(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	ctx := context.Background()
+	send := func(intent string, call func(*bind.TransactOpts) (*types.Transaction, error)) uint64 {
+		t.Helper()
+		tx, err := s.transact(intent, call)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := s.confirm(tx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return res.Receipt.BlockNumber.Uint64()
+	}
+	add := func(title string, year int64) uint64 {
+		return send("film add "+title, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+			return s.token.AddFilm(auth, title, big.NewInt(year), 0)
+		})
+	}
+	idx := &indexFlags{kind: storeBolt, path: filepath.Join(t.TempDir(), "index.db")}
+	indexTo := func(filterer logFilterer) *index {
+		t.Helper()
+		ix, err := idx.open(false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		t.Cleanup(func() { ix.Close() })
+		if err := ix.bindSource(s.chainID.Uint64(), s.address); err != nil {
+			t.Fatal(err)
+		}
+		s.logs = newLogBackfill(filterer, 0, 0)
+		if err := newFilmIndexer(ix, s, &bytes.Buffer{}).sync(ctx, s.network.DeployBlock); err != nil {
+			t.Fatal(err)
+		}
+		return ix
+	}
+	type film struct {
+		year      int64 // 0 when deleted
+		block     uint64
+		overwrite bool
+	}
+	check := func(what string, got map[string]*filmRecord, want map[string]film) {
+		t.Helper()
+		if len(got) != len(want) {
+			t.Errorf("%s has %d titles, want %d", what, len(got), len(want))
+		}
+		for title, w := range want {
+			r := got[title]
+			switch {
+			case r == nil:
+				t.Errorf("%s lacks %s", what, title)
+			case r.Block != w.block || r.Deleted != (w.year == 0) || r.Overwrite != w.overwrite:
+				t.Errorf("%s: %s = %+v, want %+v", what, title, r, w)
+			case w.year != 0 && r.Year.Int64() != w.year:
+				t.Errorf("%s: %s year %s, want %d", what, title, r.Year, w.year)
+			}
+		}
+	}
+	films := func(ix *index, withDeleted bool) map[string]*filmRecord {
+		t.Helper()
+		records, err := ix.films(withDeleted)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m := make(map[string]*filmRecord)
+		for _, r := range records {
+			m[r.Title] = r
+		}
+		return m
+	}
+
+	alien := add("Alien", 1979)
+	brazil := add("Brazil", 1985)
+	ix := indexTo(s.logs.client)
+	first, _, err := ix.checkpoint(filmsCheckpoint)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first != brazil+1 {
+		t.Errorf("checkpoint %d after the first sync, want %d", first, brazil+1)
+	}
+	if err := ix.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	alien2 := add("Alien", 1986)
+	brazil2 := send("film delete Brazil", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.DeleteFilm(auth, "Brazil")
+	})
+	casablanca := add("Casablanca", 1942)
+
+	recorder := &rangeRecorder{logFilterer: s.logs.client}
+	ix = indexTo(recorder)
+	if len(recorder.from) == 0 {
+		t.Fatal("the second sync read no logs")
+	}
+	for _, from := range recorder.from {
+		if from < first {
+			t.Errorf("the second sync read logs from block %d, before the checkpoint %d", from, first)
+		}
+	}
+
+	check("films", films(ix, false), map[string]film{
+		"Alien":      {1986, alien2, true},
+		"Casablanca": {1942, casablanca, false},
+	})
+	latest := map[string]film{
+		"Alien":      {1986, alien2, true},
+		"Brazil":     {0, brazil2, false},
+		"Casablanca": {1942, casablanca, false},
+	}
+	check("films with deleted", films(ix, true), latest)
+	before, err := ix.catalogAt(first - 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("catalog before the second sync", before, map[string]film{
+		"Alien":  {1979, alien, false},
+		"Brazil": {1985, brazil, false},
+	})
+	after, err := ix.catalogAt(casablanca)
+	if err != nil {
+		t.Fatal(err)
+	}
+	check("catalog at the head", after, latest)
+}
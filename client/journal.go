@@ -0,0 +1,517 @@
+package main
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+	bolt "go.etcd.io/bbolt"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// defaultJournalPath is used when neither --journal nor $EMERALD_JOURNAL is set.
+const defaultJournalPath = "emerald-journal.db"
+
+// journalState is the lifecycle state of a journaled transaction:
+//
+//	built -> signed -> broadcast -> mined | dropped | replaced
+//
+// An entry that never got past built was never signed and can be dropped
+// safely; from signed on, the exact signed bytes are kept so that only they are
+// ever (re)broadcast.
+type journalState string
+
+const (
+	stateBuilt     journalState = "built"
+	stateSigned    journalState = "signed"
+	stateBroadcast journalState = "broadcast"
+	stateMined     journalState = "mined"
+	stateDropped   journalState = "dropped"
+	stateReplaced  journalState = "replaced"
+)
+
+// final reports whether no further transition is expected.
+func (s journalState) final() bool {
+	return s == stateMined || s == stateDropped || s == stateReplaced
+}
+
+// journalEntry is one transaction in the journal.
+type journalEntry struct {
+	ID         uint64         `json:"id"`
+	Intent     string         `json:"intent"`
+	ChainID    uint64         `json:"chainId"`
+	From       common.Address `json:"from"`
+	Nonce      uint64         `json:"nonce"`
+	Hash       common.Hash    `json:"hash,omitempty"`
+	Raw        hexutil.Bytes  `json:"raw,omitempty"`
+	State      journalState   `json:"state"`
+	Block      uint64         `json:"block,omitempty"`
+	ReplacedBy common.Hash    `json:"replacedBy,omitempty"`
+	Error      string         `json:"error,omitempty"`
+	Created    time.Time      `json:"created"`
+	Updated    time.Time      `json:"updated"`
+}
+
+// transaction decodes the signed bytes of the entry.
+func (e *journalEntry) transaction() (*types.Transaction, error) {
+	if len(e.Raw) == 0 {
+		return nil, fmt.Errorf("journal entry %d has no signed transaction", e.ID)
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(e.Raw); err != nil {
+		return nil, fmt.Errorf("journal entry %d: %w", e.ID, err)
+	}
+	return tx, nil
+}
+
+var journalBucket = []byte("transactions")
+
+// journal is an on-disk record of every transaction the client sends. Every
+// state change is committed to disk before the client acts on it, so a crash
+// at any point leaves enough behind to finish or reconcile the transaction.
+type journal struct {
+	db *bolt.DB
+}
+
+func openJournal(path string) (*journal, error) {
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("journal %s is locked by another process", path)
+		}
+		return nil, err
+	}
+	err = db.Update(func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(journalBucket)
+		return err
+	})
+	if err != nil {
+		db.Close()
+		return nil, err
+	}
+	return &journal{db: db}, nil
+}
+
+func (j *journal) Close() error {
+	return j.db.Close()
+}
+
+func journalKey(id uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, id)
+}
+
+// create stores a new entry in state built and assigns its ID.
+func (j *journal) create(entry *journalEntry) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(journalBucket)
+		id, err := b.NextSequence()
+		if err != nil {
+			return err
+		}
+		now := time.Now().UTC()
+		entry.ID, entry.State, entry.Created, entry.Updated = id, stateBuilt, now, now
+		return putEntry(b, entry)
+	})
+}
+
+// update applies change to the stored entry with the given ID and writes it
+// back; entry receives the result.
+func (j *journal) update(entry *journalEntry, change func(*journalEntry)) error {
+	return j.db.Update(func(tx *bolt.Tx) error {
+		b := tx.Bucket(journalBucket)
+		data := b.Get(journalKey(entry.ID))
+		if data == nil {
+			return fmt.Errorf("journal entry %d not found", entry.ID)
+		}
+		if err := json.Unmarshal(data, entry); err != nil {
+			return err
+		}
+		change(entry)
+		entry.Updated = time.Now().UTC()
+		return putEntry(b, entry)
+	})
+}
+
+func putEntry(b *bolt.Bucket, entry *journalEntry) error {
+	data, err := json.Marshal(entry)
+	if err != nil {
+		return err
+	}
+	return b.Put(journalKey(entry.ID), data)
+}
+
+// entries returns every entry matching keep, oldest first.
+func (j *journal) entries(keep func(*journalEntry) bool) ([]*journalEntry, error) {
+	var out []*journalEntry
+	err := j.db.View(func(tx *bolt.Tx) error {
+		return tx.Bucket(journalBucket).ForEach(func(_, data []byte) error {
+			entry := new(journalEntry)
+			if err := json.Unmarshal(data, entry); err != nil {
+				return err
+			}
+			if keep == nil || keep(entry) {
+				out = append(out, entry)
+			}
+			return nil
+		})
+	})
+	return out, err
+}
+
+// byHash returns the entry of the transaction with the given hash, or nil.
+func (j *journal) byHash(hash common.Hash) (*journalEntry, error) {
+	found, err := j.entries(func(e *journalEntry) bool { return e.Hash == hash })
+	if err != nil || len(found) == 0 {
+		return nil, err
+	}
+	return found[len(found)-1], nil
+}
+
+// unfinished returns the entries of from on chainID that are not final.
+func (j *journal) unfinished(chainID uint64, from common.Address) ([]*journalEntry, error) {
+	return j.entries(func(e *journalEntry) bool {
+		return e.ChainID == chainID && e.From == from && !e.State.final()
+	})
+}
+
+// markSigned records the signed transaction of entry.
+func (j *journal) markSigned(entry *journalEntry, tx *types.Transaction) error {
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return err
+	}
+	return j.update(entry, func(e *journalEntry) {
+		e.State, e.Hash, e.Raw = stateSigned, tx.Hash(), raw
+	})
+}
+
+func (j *journal) markState(entry *journalEntry, state journalState, cause error) error {
+	return j.update(entry, func(e *journalEntry) {
+		e.State = state
+		if cause != nil {
+			e.Error = cause.Error()
+		}
+	})
+}
+
+func (j *journal) markMined(entry *journalEntry, block uint64) error {
+	return j.update(entry, func(e *journalEntry) {
+		e.State, e.Block = stateMined, block
+	})
+}
+
+func (j *journal) markReplaced(entry *journalEntry, by common.Hash) error {
+	return j.update(entry, func(e *journalEntry) {
+		e.State, e.ReplacedBy = stateReplaced, by
+	})
+}
+
+// sendJournaled records a new entry for intent, signs the transaction with
+// sign and broadcasts it. Each step is committed to the journal before the
+// next one starts, and only the journaled bytes are ever broadcast. When the
+// broadcast fails, the signed transaction is returned along with the error.
+func (s *session) sendJournaled(ctx context.Context, intent string, nonce uint64, sign func() (*types.Transaction, error)) (*types.Transaction, error) {
+	if s.journal == nil {
+		tx, err := sign()
+		if err != nil {
+			return nil, err
+		}
+		return tx, s.client.SendTransaction(ctx, tx)
+	}
+
+	entry := &journalEntry{Intent: intent, ChainID: s.chainID.Uint64(), From: s.from(), Nonce: nonce}
+	if err := s.journal.create(entry); err != nil {
+		return nil, err
+	}
+	tx, err := sign()
+	if err != nil {
+		// Nothing was signed, so nothing can reach the chain.
+		_ = s.journal.markState(entry, stateDropped, err)
+		return nil, err
+	}
+	if err := s.journal.markSigned(entry, tx); err != nil {
+		return nil, err
+	}
+	if err := s.client.SendTransaction(ctx, tx); err != nil {
+		err = txerr.Classify(err)
+		if txerr.ClassOf(err).Rejected() {
+			_ = s.journal.markState(entry, stateDropped, err)
+		} else {
+			// A timeout, a lost connection or "already known" may leave tx
+			// in the pool; reconcile looks it up and rebroadcasts it.
+			_ = s.journal.markState(entry, stateSigned, err)
+		}
+		return tx, err
+	}
+	if err := s.journal.markState(entry, stateBroadcast, nil); err != nil {
+		return nil, err
+	}
+	return tx, nil
+}
+
+// checkDuplicate refuses to send intent again while an earlier transaction
+// for the same intent is still unfinished, so a command retried after a crash
+// or a timeout cannot mint or transfer twice.
+func (s *session) checkDuplicate(intent string) error {
+	if s.journal == nil {
+		return nil
+	}
+	pending, err := s.journal.unfinished(s.chainID.Uint64(), s.from())
+	if err != nil {
+		return err
+	}
+	for _, e := range pending {
+		if e.Intent == intent {
+			return fmt.Errorf("%q is already pending as %s (journal entry %d, nonce %d); wait for it or use tx speedup/cancel",
+				intent, e.Hash.Hex(), e.ID, e.Nonce)
+		}
+	}
+	return nil
+}
+
+// journalMined records that tx was mined and that every other journaled
+// transaction in others, replacements sharing its nonce, was replaced by it.
+func (s *session) journalMined(tx *types.Transaction, receipt *types.Receipt, others []*types.Transaction) error {
+	if s.journal == nil {
+		return nil
+	}
+	entry, err := s.journal.byHash(tx.Hash())
+	if err != nil {
+		return err
+	}
+	if entry != nil {
+		if err := s.journal.markMined(entry, receipt.BlockNumber.Uint64()); err != nil {
+			return err
+		}
+	}
+	for _, other := range others {
+		if other.Hash() == tx.Hash() {
+			continue
+		}
+		entry, err := s.journal.byHash(other.Hash())
+		if err != nil {
+			return err
+		}
+		if entry != nil && !entry.State.final() {
+			if err := s.journal.markReplaced(entry, tx.Hash()); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// resumeJournal reconciles the unfinished entries of the signing account with
+// the chain. It runs whenever a sending command starts, so that work
+// interrupted by a crash is finished before anything new is sent. An entry
+// that cannot be reconciled now, say because the node is unreachable, is
+// reported and left for the next start rather than failing this one, which
+// may be the speed up or cancel meant to sort it out.
+func (s *session) resumeJournal(ctx context.Context) error {
+	entries, err := s.journal.unfinished(s.chainID.Uint64(), s.from())
+	if err != nil {
+		return err
+	}
+	for _, e := range entries {
+		before := e.State
+		if err := s.reconcile(ctx, e); err != nil {
+			fmt.Fprintf(os.Stderr, "journal: entry %d %q nonce %d: %v (retried on the next start)\n", e.ID, e.Intent, e.Nonce, err)
+			continue
+		}
+		if e.State != before {
+			fmt.Fprintf(os.Stderr, "journal: entry %d %q nonce %d: %s -> %s\n", e.ID, e.Intent, e.Nonce, before, e.State)
+		}
+	}
+	return nil
+}
+
+// reconcile moves a single unfinished entry forward:
+//   - built entries were never signed and are dropped, and so are entries
+//     whose signed bytes no longer decode;
+//   - entries with a receipt are mined;
+//   - entries whose nonce was consumed by another transaction are replaced;
+//   - anything else is rebroadcast from its journaled bytes, which is
+//     harmless if the node already has it, and dropped if the node refuses
+//     it for good.
+func (s *session) reconcile(ctx context.Context, e *journalEntry) error {
+	if e.State == stateBuilt {
+		return s.journal.markState(e, stateDropped, errors.New("interrupted before signing"))
+	}
+	tx, err := e.transaction()
+	if err != nil {
+		return s.journal.markState(e, stateDropped, err)
+	}
+
+	receipt, err := s.client.TransactionReceipt(ctx, e.Hash)
+	if err == nil {
+		return s.journal.markMined(e, receipt.BlockNumber.Uint64())
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		return txerr.Classify(err)
+	}
+
+	mined, err := s.client.NonceAt(ctx, e.From, nil)
+	if err != nil {
+		return txerr.Classify(err)
+	}
+	if mined > e.Nonce {
+		return s.settleUsedNonce(ctx, e)
+	}
+
+	err = s.client.SendTransaction(ctx, tx)
+	if err == nil || strings.Contains(strings.ToLower(err.Error()), "already known") {
+		return s.journal.markState(e, stateBroadcast, nil)
+	}
+	err = txerr.Classify(err)
+	switch {
+	case errors.Is(err, txerr.ErrNonceTooLow):
+		return s.settleUsedNonce(ctx, e)
+	case errors.Is(err, txerr.ErrReplacementUnderpriced):
+		// Another pending transaction holds the nonce, typically our own
+		// speed up or cancel; it gets reconciled on its own.
+		return s.journal.markState(e, stateBroadcast, nil)
+	case refused(err):
+		return s.journal.markState(e, stateDropped, err)
+	default:
+		return err
+	}
+}
+
+// refused reports whether the node answered a broadcast with a final
+// rejection: one txerr knows, or any other error of the node itself, such as
+// "transaction underpriced", as opposed to a failure to reach it.
+func refused(err error) bool {
+	switch txerr.ClassOf(err) {
+	case txerr.Unknown:
+		var rpcErr rpc.Error
+		return errors.As(err, &rpcErr)
+	default:
+		return txerr.ClassOf(err).Rejected()
+	}
+}
+
+// settleUsedNonce settles an entry whose nonce the account has used. The
+// entry's own transaction may have been mined since its receipt was looked
+// up, so it is looked up again before the entry is marked replaced by the
+// transaction that took the nonce. When that one cannot be found, as when the
+// node has pruned the state needed, the entry is still replaced, without a
+// hash and with the reason.
+func (s *session) settleUsedNonce(ctx context.Context, e *journalEntry) error {
+	receipt, err := s.client.TransactionReceipt(ctx, e.Hash)
+	if err == nil {
+		return s.journal.markMined(e, receipt.BlockNumber.Uint64())
+	}
+	if !errors.Is(err, ethereum.NotFound) {
+		return txerr.Classify(err)
+	}
+	by, err := s.replacementOf(ctx, e)
+	if err != nil {
+		return s.journal.update(e, func(e *journalEntry) {
+			e.State, e.Error = stateReplaced, fmt.Sprintf("replacement not found: %v", err)
+		})
+	}
+	return s.journal.markReplaced(e, by)
+}
+
+// replacementOf returns the hash of the transaction that took the nonce of e:
+// a journaled one with a receipt or, failing that, the transaction of e's
+// sender and nonce in the block that moved the account's nonce past e's.
+func (s *session) replacementOf(ctx context.Context, e *journalEntry) (common.Hash, error) {
+	others, err := s.journal.entries(func(o *journalEntry) bool {
+		return o.ChainID == e.ChainID && o.From == e.From && o.Nonce == e.Nonce && o.ID != e.ID && o.Hash != (common.Hash{})
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	for _, o := range others {
+		if o.State == stateMined {
+			return o.Hash, nil
+		}
+		_, err := s.client.TransactionReceipt(ctx, o.Hash)
+		if err == nil {
+			return o.Hash, nil
+		}
+		if !errors.Is(err, ethereum.NotFound) {
+			return common.Hash{}, err
+		}
+	}
+
+	number, err := s.nonceBlock(ctx, e.From, e.Nonce)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	block, err := s.client.BlockByNumber(ctx, new(big.Int).SetUint64(number))
+	if err != nil {
+		return common.Hash{}, err
+	}
+	signer := types.LatestSignerForChainID(s.chainID)
+	for _, tx := range block.Transactions() {
+		if tx.Nonce() != e.Nonce {
+			continue
+		}
+		if from, err := types.Sender(signer, tx); err == nil && from == e.From {
+			return tx.Hash(), nil
+		}
+	}
+	return common.Hash{}, fmt.Errorf("block %d has no transaction of %s with nonce %d", number, e.From.Hex(), e.Nonce)
+}
+
+// nonceBlock returns the block whose transactions moved the nonce of account
+// past nonce. It steps back from the head in doubling strides before
+// bisecting, so that a recent block is found with the state a node that is
+// not an archive still keeps.
+func (s *session) nonceBlock(ctx context.Context, account common.Address, nonce uint64) (uint64, error) {
+	past := func(number uint64) (bool, error) {
+		n, err := s.client.NonceAt(ctx, account, new(big.Int).SetUint64(number))
+		return n > nonce, err
+	}
+	head, err := s.client.BlockNumber(ctx)
+	if err != nil {
+		return 0, err
+	}
+	// The nonce is past it at hi and not yet at lo.
+	hi, lo := head, uint64(0)
+	for stride := uint64(1); ; stride *= 2 {
+		if hi == 0 {
+			return 0, fmt.Errorf("nonce %d of %s was used at genesis", nonce, account.Hex())
+		}
+		probe := uint64(0)
+		if stride < hi {
+			probe = hi - stride
+		}
+		ok, err := past(probe)
+		if err != nil {
+			return 0, err
+		}
+		if !ok {
+			lo = probe
+			break
+		}
+		hi = probe
+	}
+	for hi-lo > 1 {
+		mid := lo + (hi-lo)/2
+		ok, err := past(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			hi = mid
+		} else {
+			lo = mid
+		}
+	}
+	return hi, nil
+}
@@ -0,0 +1,164 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+)
+
+// ledgerCheckpoint is the checkpoint name of the ledger indexer.
+const ledgerCheckpoint = "ledger"
+
+// ledgerConsumer feeds Transfer and Approval logs into the index and keeps
+// the balance and allowance projections. The zero address only mints and
+// burns, so it has no balance and the balances add up to the total supply.
+// The token emits an Approval whenever transferFrom spends an allowance, so
+// the allowances follow the Approval events alone.
+type ledgerConsumer struct {
+	filterer *MainFilterer
+	address  common.Address
+	out      io.Writer // every event is reported here
+}
+
+func (lc *ledgerConsumer) name() string {
+	return ledgerCheckpoint
+}
+
+func (lc *ledgerConsumer) query() ethereum.FilterQuery {
+	return ethereum.FilterQuery{
+		Addresses: []common.Address{lc.address},
+		Topics:    [][]common.Hash{{mainEventID("Transfer"), mainEventID("Approval")}},
+	}
+}
+
+func (lc *ledgerConsumer) apply(tx storeTx, logs []types.Log) error {
+	for _, log := range logs {
+		if len(log.Topics) == 0 {
+			continue
+		}
+		switch log.Topics[0] {
+		case mainEventID("Transfer"):
+			ev, err := lc.filterer.ParseTransfer(log)
+			if err != nil {
+				return err
+			}
+			r := &transferRecord{logRef: logRefOf(log), From: ev.From, To: ev.To, Value: ev.Value}
+			if err := applyTransfer(tx, r); err != nil {
+				return err
+			}
+			fmt.Fprintf(lc.out, "block %d tx %s: transfer %s -> %s %s\n", r.Block, r.TxHash.Hex(), r.From.Hex(), r.To.Hex(), r.Value)
+		case mainEventID("Approval"):
+			ev, err := lc.filterer.ParseApproval(log)
+			if err != nil {
+				return err
+			}
+			r := &approvalRecord{logRef: logRefOf(log), Owner: ev.Owner, Spender: ev.Spender, Value: ev.Value}
+			if err := applyApproval(tx, r); err != nil {
+				return err
+			}
+			fmt.Fprintf(lc.out, "block %d tx %s: approve  %s -> %s %s\n", r.Block, r.TxHash.Hex(), r.Owner.Hex(), r.Spender.Hex(), r.Value)
+		}
+	}
+	return nil
+}
+
+func (lc *ledgerConsumer) rollback(tx storeTx, from uint64) error {
+	return tx.deleteLedger(from)
+}
+
+// applyTransfer stores r and moves its value between the balances. Transfers
+// have to be applied in chain order.
+func applyTransfer(tx storeTx, r *transferRecord) error {
+	if err := tx.putTransfer(r); err != nil {
+		return err
+	}
+	if r.From != (common.Address{}) {
+		if err := addBalance(tx, r.From, r.Block, new(big.Int).Neg(r.Value)); err != nil {
+			return err
+		}
+	}
+	if r.To != (common.Address{}) {
+		return addBalance(tx, r.To, r.Block, r.Value)
+	}
+	return nil
+}
+
+// addBalance adds delta to the balance of account as of block.
+func addBalance(tx storeTx, account common.Address, block uint64, delta *big.Int) error {
+	balance := new(big.Int)
+	current, err := tx.balance(account, block)
+	if err != nil {
+		return err
+	}
+	if current != nil {
+		balance.Set(current.Value)
+	}
+	if balance.Add(balance, delta).Sign() < 0 {
+		return fmt.Errorf("balance of %s goes negative at block %d; the ledger has to be indexed from the token's deployment (deploy_block)", account.Hex(), block)
+	}
+	return tx.putBalance(&balancePoint{Account: account, Block: block, Value: balance})
+}
+
+// applyApproval stores r and sets the allowance it approves.
+func applyApproval(tx storeTx, r *approvalRecord) error {
+	if err := tx.putApproval(r); err != nil {
+		return err
+	}
+	return tx.putAllowance(&allowancePoint{Owner: r.Owner, Spender: r.Spender, Block: r.Block, Value: r.Value})
+}
+
+// ledgerIndexer keeps the ledger of an index in sync with the chain.
+type ledgerIndexer struct {
+	*ingester
+}
+
+func newLedgerIndexer(ix *index, s *session, out io.Writer) *ledgerIndexer {
+	ledger := &ledgerConsumer{filterer: &s.token.MainFilterer, address: s.address, out: out}
+	return &ledgerIndexer{
+		ingester: &ingester{index: ix, chain: s.client, logs: s.logs, consumer: ledger, out: out},
+	}
+}
+
+// follow keeps the ledger at the chain head until ctx is done. Unlike the
+// catalog, the balances depend on the order of every transfer, so pushed
+// events are not applied on their own and the ledger only moves by syncing.
+func (li *ledgerIndexer) follow(ctx context.Context, poll time.Duration) error {
+	ticker := time.NewTicker(poll)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			if err := li.sync(ctx, 0); err != nil && ctx.Err() == nil {
+				return err
+			}
+		}
+	}
+}
+
+// indexedBlock resolves the block a query of the named consumer's data refers
+// to: block itself, or the last indexed block when block is negative. Blocks
+// past the last indexed one are refused since their data is not known yet.
+func (ix *index) indexedBlock(name string, block int64) (uint64, error) {
+	next, found, err := ix.checkpoint(name)
+	if err != nil {
+		return 0, err
+	}
+	if !found || next == 0 {
+		return 0, fmt.Errorf("nothing indexed for %s yet", name)
+	}
+	if block < 0 {
+		return next - 1, nil
+	}
+	if uint64(block) >= next {
+		return 0, fmt.Errorf("block %d is not indexed yet; the index is at block %d", block, next-1)
+	}
+	return uint64(block), nil
+}
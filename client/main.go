@@ -2,20 +2,17 @@ package main
 
 import (
 	"context"
-	"crypto/ecdsa"
+	"errors"
+	"flag"
 	"fmt"
 	"log"
 	"math/big"
 	"os"
-	"strings"
 
-	"github.com/ethereum/go-ethereum"
-	"github.com/ethereum/go-ethereum/accounts/abi"
 	"github.com/ethereum/go-ethereum/accounts/abi/bind"
-	"github.com/ethereum/go-ethereum/common"
-	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
 	"github.com/joho/godotenv"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
 )
 
 func init() {
@@ -24,101 +21,60 @@ func init() {
 	}
 }
 
-func getTransactionOpts(client *ethclient.Client, privateKey *ecdsa.PrivateKey) (*bind.TransactOpts, error) {
-	publicKey := privateKey.Public()
-	publicKeyECDSA, ok := publicKey.(*ecdsa.PublicKey)
-	if !ok {
-		return nil, fmt.Errorf("error casting public key to ECDSA")
-	}
-
-	fromAddress := crypto.PubkeyToAddress(*publicKeyECDSA)
-	nonce, err := client.PendingNonceAt(context.Background(), fromAddress)
-	if err != nil {
-		return nil, err
-	}
-
-	gasPrice, err := client.SuggestGasPrice(context.Background())
+func getTransactionOpts(ctx context.Context, signer Signer, nonce uint64, fees *txFees, gasLimit uint64) (*bind.TransactOpts, error) {
+	auth, err := signer.TransactOpts(ctx)
 	if err != nil {
 		return nil, err
 	}
-
-	auth := bind.NewKeyedTransactor(privateKey)
-	auth.Nonce = big.NewInt(int64(nonce))
-	auth.Value = big.NewInt(0)     // in wei
-	auth.GasLimit = uint64(300000) // in units
-	auth.GasPrice = gasPrice
+	auth.Nonce = new(big.Int).SetUint64(nonce)
+	auth.Value = big.NewInt(0) // in wei
+	auth.GasLimit = gasLimit   // in units, 0 lets the binding estimate it
+	fees.apply(auth)
 
 	return auth, nil
 }
 
-func main() {
-	apiKey := os.Getenv("API_KEY")
-	prKey := os.Getenv("PRIVATE_KEY")
-
-	client, err := ethclient.Dial("https://goerli.infura.io/v3/" + apiKey)
-	if err != nil {
-		panic(err)
-	}
-
-	privateKey, err := crypto.HexToECDSA(prKey)
-	if err != nil {
-		panic(err)
-	}
-
-	authAdd, err := getTransactionOpts(client, privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	address := common.HexToAddress("0x95E72Ebd9F722e0F6AD5fcd3a29F446B7fDf7e5f")
-	instance, err := NewMain(address, client)
-	if err != nil {
-		panic(err)
-	}
-
-	txAdd, err := instance.AddFilm(authAdd, "lol", big.NewInt(2023), 0)
-	if err != nil {
-		panic(err)
-	}
-
-	fmt.Printf("tx add film send: %s\n", txAdd.Hash().Hex())
-
-	authDelete, err := getTransactionOpts(client, privateKey)
-	if err != nil {
-		panic(err)
-	}
-
-	txDel, err := instance.DeleteFilm(authDelete, "lol")
-	if err != nil {
-		panic(err)
+func rootCommand() *command {
+	return &command{
+		name: "emerald",
+		children: []*command{
+			tokenCommand(),
+			filmCommand(),
+			ledgerCommand(),
+			verifyCommand(),
+			ownerCommand(),
+			txCommand(),
+			devnetCommand(),
+			gasCommand(),
+		},
 	}
+}
 
-	fmt.Printf("tx delete film send: %s\n", txDel.Hash().Hex())
+// Exit codes. Automation can retry on exitRetryable and should not on
+// exitFailure; the error line names the txerr class and its retry policy.
+const (
+	exitFailure   = 1
+	exitUsage     = 2
+	exitRetryable = 75 // EX_TEMPFAIL
+)
 
-	logs, err := client.FilterLogs(context.Background(), ethereum.FilterQuery{
-		FromBlock: big.NewInt(8568585),
-        ToBlock: big.NewInt(8568585),
-        Addresses: []common.Address{address},
-	})
-	if err != nil {
-		panic(err)
+func main() {
+	root := rootCommand()
+	err := root.execute(root.name, os.Args[1:])
+	switch {
+	case err == nil, errors.Is(err, flag.ErrHelp):
+	case errors.Is(err, errUsage):
+		os.Exit(exitUsage)
+	default:
+		class := txerr.ClassOf(err)
+		if class == txerr.Unknown {
+			fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		} else {
+			fmt.Fprintf(os.Stderr, "error (%s, %s): %v\n", class, class.Policy(), err)
+		}
+		if class.Retryable() {
+			os.Exit(exitRetryable)
+		}
+		os.Exit(exitFailure)
 	}
-
-    contractAbi, err := abi.JSON(strings.NewReader(string(MainABI)))
-
-
-	for _, vLog := range logs {
-        fmt.Println(vLog.BlockHash.Hex())
-        fmt.Println(vLog.BlockNumber)
-        fmt.Println(vLog.TxHash.Hex())
-        fmt.Println(vLog.Data)
-        p, err := contractAbi.Unpack("FilmAdded", vLog.Data)
-        if err != nil {
-            panic(err)
-        }
-        fmt.Println(p)
-    }
-
-
-	return
 }
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// nonceSource reports the next nonce the node expects from an account,
+// counting transactions that are still in its pool.
+type nonceSource interface {
+	PendingNonceAt(ctx context.Context, account common.Address) (uint64, error)
+}
+
+// nonceManager hands out transaction nonces without asking the node every
+// time, so concurrent senders from the same account never collide. Nonces are
+// reserved locally and either committed once the node accepts the transaction
+// or released, in which case the next reservation reuses them to close the gap.
+type nonceManager struct {
+	source nonceSource
+
+	mu       sync.Mutex
+	accounts map[common.Address]*accountNonces
+}
+
+type accountNonces struct {
+	synced   bool
+	next     uint64              // next never-used nonce
+	gaps     []uint64            // released nonces below next, sorted ascending
+	reserved map[uint64]struct{} // handed out and not yet committed or released
+}
+
+func newNonceManager(source nonceSource) *nonceManager {
+	return &nonceManager{
+		source:   source,
+		accounts: make(map[common.Address]*accountNonces),
+	}
+}
+
+func (m *nonceManager) account(addr common.Address) *accountNonces {
+	acc, ok := m.accounts[addr]
+	if !ok {
+		acc = &accountNonces{reserved: make(map[uint64]struct{})}
+		m.accounts[addr] = acc
+	}
+	return acc
+}
+
+// reserve returns the lowest nonce that is free for addr. The first call for
+// an account seeds the counter from the node.
+func (m *nonceManager) reserve(ctx context.Context, addr common.Address) (uint64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc := m.account(addr)
+	if !acc.synced {
+		if err := m.syncLocked(ctx, addr, acc); err != nil {
+			return 0, err
+		}
+	}
+
+	var nonce uint64
+	if len(acc.gaps) > 0 {
+		nonce, acc.gaps = acc.gaps[0], acc.gaps[1:]
+	} else {
+		nonce = acc.next
+		acc.next++
+	}
+	acc.reserved[nonce] = struct{}{}
+	return nonce, nil
+}
+
+// commit marks a reserved nonce as used by a transaction the node accepted.
+func (m *nonceManager) commit(addr common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.account(addr).reserved, nonce)
+}
+
+// release returns a reserved nonce whose transaction was never accepted, or
+// one whose transaction has been dropped, so that it is handed out again
+// before any higher nonce.
+func (m *nonceManager) release(addr common.Address, nonce uint64) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	acc := m.account(addr)
+	delete(acc.reserved, nonce)
+	if nonce >= acc.next {
+		return
+	}
+	i := sort.Search(len(acc.gaps), func(i int) bool { return acc.gaps[i] >= nonce })
+	if i < len(acc.gaps) && acc.gaps[i] == nonce {
+		return
+	}
+	acc.gaps = append(acc.gaps, 0)
+	copy(acc.gaps[i+1:], acc.gaps[i:])
+	acc.gaps[i] = nonce
+}
+
+// resync reconciles the local state of addr with the node, typically after
+// the node rejected a transaction with "nonce too low" or "replacement
+// transaction underpriced".
+func (m *nonceManager) resync(ctx context.Context, addr common.Address) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	return m.syncLocked(ctx, addr, m.account(addr))
+}
+
+// syncLocked fetches the pending nonce of addr. Everything below it is known
+// to the node and is forgotten; every nonce between it and the local counter
+// that is not currently reserved belongs to a transaction the node no longer
+// has, and becomes a gap to be refilled.
+func (m *nonceManager) syncLocked(ctx context.Context, addr common.Address, acc *accountNonces) error {
+	pending, err := m.source.PendingNonceAt(ctx, addr)
+	if err != nil {
+		return err
+	}
+
+	acc.gaps = acc.gaps[:0]
+	if pending >= acc.next {
+		acc.next = pending
+	} else {
+		for nonce := pending; nonce < acc.next; nonce++ {
+			if _, ok := acc.reserved[nonce]; !ok {
+				acc.gaps = append(acc.gaps, nonce)
+			}
+		}
+	}
+	for nonce := range acc.reserved {
+		if nonce < pending {
+			delete(acc.reserved, nonce)
+		}
+	}
+	acc.synced = true
+	return nil
+}
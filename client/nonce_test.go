@@ -0,0 +1,132 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// pendingNonce is a nonceSource whose pending nonce the test sets.
+type pendingNonce struct {
+	nonce uint64
+	err   error
+	calls int
+}
+
+func (p *pendingNonce) PendingNonceAt(context.Context, common.Address) (uint64, error) {
+	p.calls++
+	return p.nonce, p.err
+}
+
+// TestNonceManager runs sequences of operations against a manager whose node
+// reports a pending nonce of 5. "r" reserves and records the nonce it got,
+// "c3" commits and "x3" releases nonce 3, and "p7" makes the node report 7
+// and resyncs.
+func TestNonceManager(t *testing.T) {
+	for _, tc := range []struct {
+		name string
+		ops  string
+		want string
+	}{
+		{"counts up from the node", "r r r", "5 6 7"},
+		{"commit does not reuse", "r r c5 c6 r", "5 6 7"},
+		{"release refills the gap", "r r r x6 r r", "5 6 7 6 8"},
+		{"lowest gap first", "r r r r x7 x5 x6 r r r r", "5 6 7 8 5 6 7 9"},
+		{"releasing twice", "r r x5 x5 r r", "5 6 5 7"},
+		{"releasing above the counter", "r x9 r", "5 6"},
+		{"resync forward", "r c5 p9 r", "5 9"},
+		{"resync over a reserved nonce", "r r p5 r", "5 6 7"},
+		{"resync reopens dropped nonces", "r r r c5 c6 c7 p6 r r r", "5 6 7 6 7 8"},
+		{"resync forgets mined gaps", "r r r x5 x6 p7 r", "5 6 7 8"},
+		{"resync keeps reserved nonces", "r r r c6 p5 r r", "5 6 7 6 8"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ctx := context.Background()
+			source := &pendingNonce{nonce: 5}
+			m := newNonceManager(source)
+			addr := common.HexToAddress("0x01")
+
+			var got []string
+			for _, op := range strings.Fields(tc.ops) {
+				var arg uint64
+				if len(op) > 1 {
+					n, err := strconv.ParseUint(op[1:], 10, 64)
+					if err != nil {
+						t.Fatalf("bad op %q", op)
+					}
+					arg = n
+				}
+				switch op[0] {
+				case 'r':
+					nonce, err := m.reserve(ctx, addr)
+					if err != nil {
+						t.Fatal(err)
+					}
+					got = append(got, fmt.Sprint(nonce))
+				case 'c':
+					m.commit(addr, arg)
+				case 'x':
+					m.release(addr, arg)
+				case 'p':
+					source.nonce = arg
+					if err := m.resync(ctx, addr); err != nil {
+						t.Fatal(err)
+					}
+				default:
+					t.Fatalf("bad op %q", op)
+				}
+			}
+			if got := strings.Join(got, " "); got != tc.want {
+				t.Errorf("reserved %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestNonceManagerAccounts(t *testing.T) {
+	ctx := context.Background()
+	source := &pendingNonce{nonce: 3}
+	m := newNonceManager(source)
+	a, b := common.HexToAddress("0x01"), common.HexToAddress("0x02")
+
+	for i, want := range []struct {
+		addr  common.Address
+		nonce uint64
+	}{{a, 3}, {a, 4}, {b, 3}, {a, 5}, {b, 4}} {
+		nonce, err := m.reserve(ctx, want.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if nonce != want.nonce {
+			t.Errorf("reservation %d: nonce %d, want %d", i, nonce, want.nonce)
+		}
+	}
+	if source.calls != 2 {
+		t.Errorf("asked the node %d times, want once per account", source.calls)
+	}
+}
+
+func TestNonceManagerSourceError(t *testing.T) {
+	ctx := context.Background()
+	fail := errors.New("connection refused")
+	source := &pendingNonce{nonce: 2, err: fail}
+	m := newNonceManager(source)
+	addr := common.HexToAddress("0x01")
+
+	if _, err := m.reserve(ctx, addr); !errors.Is(err, fail) {
+		t.Fatalf("reserve: %v, want %v", err, fail)
+	}
+	source.err = nil
+	nonce, err := m.reserve(ctx, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if nonce != 2 {
+		t.Errorf("nonce %d after the node recovered, want 2", nonce)
+	}
+}
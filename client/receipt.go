@@ -0,0 +1,196 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// receiptPollInterval is how often the node is asked for a receipt or a new
+// head while waiting for a transaction.
+const receiptPollInterval = time.Second
+
+// receiptBackend is the part of a node client needed to follow a transaction
+// until it is mined and confirmed.
+type receiptBackend interface {
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*types.Receipt, error)
+	BlockNumber(ctx context.Context) (uint64, error)
+	CallContract(ctx context.Context, call ethereum.CallMsg, blockNumber *big.Int) ([]byte, error)
+}
+
+// txFailedError is returned for a transaction that was mined with status 0. It
+// unwraps to the txerr classification of its revert reason.
+type txFailedError struct {
+	TxHash  common.Hash
+	Receipt *types.Receipt
+	Reason  string // decoded revert reason, empty when the node gave none
+}
+
+func (e *txFailedError) Error() string {
+	if e.Reason == "" {
+		return fmt.Sprintf("transaction %s reverted in block %s", e.TxHash.Hex(), e.Receipt.BlockNumber)
+	}
+	return fmt.Sprintf("transaction %s reverted in block %s: %s", e.TxHash.Hex(), e.Receipt.BlockNumber, e.Reason)
+}
+
+func (e *txFailedError) Unwrap() error {
+	return txerr.FromRevertReason(e.Reason)
+}
+
+// waitMined polls for the receipt of tx until it has confirmations blocks on
+// top of it, or ctx is done. A receipt that disappears or moves to another
+// block while waiting, because of a reorg, restarts the wait.
+func waitMined(ctx context.Context, backend receiptBackend, tx *types.Transaction, confirmations uint64) (*types.Receipt, error) {
+	_, receipt, err := waitAnyMined(ctx, backend, []*types.Transaction{tx}, confirmations)
+	return receipt, err
+}
+
+// waitAnyMined is waitMined for a set of transactions sharing one nonce, such
+// as a transaction and its replacements. It returns whichever gets mined.
+func waitAnyMined(ctx context.Context, backend receiptBackend, txs []*types.Transaction, confirmations uint64) (*types.Transaction, *types.Receipt, error) {
+	ticker := time.NewTicker(receiptPollInterval)
+	defer ticker.Stop()
+
+	for {
+		for _, tx := range txs {
+			receipt, err := backend.TransactionReceipt(ctx, tx.Hash())
+			if errors.Is(err, ethereum.NotFound) {
+				continue
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+			head, err := backend.BlockNumber(ctx)
+			if err != nil {
+				return nil, nil, err
+			}
+			if head >= receipt.BlockNumber.Uint64()+confirmations {
+				return tx, receipt, nil
+			}
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, nil, fmt.Errorf("waiting for transaction %s: %w", txs[len(txs)-1].Hash().Hex(), ctx.Err())
+		case <-ticker.C:
+		}
+	}
+}
+
+// checkReceipt turns a receipt with status 0 into a txFailedError carrying
+// the revert reason, which is recovered by replaying the call at the block the
+// transaction was mined in.
+func checkReceipt(ctx context.Context, backend receiptBackend, tx *types.Transaction, receipt *types.Receipt) error {
+	if receipt.Status == types.ReceiptStatusSuccessful {
+		return nil
+	}
+	return &txFailedError{
+		TxHash:  tx.Hash(),
+		Receipt: receipt,
+		Reason:  revertReason(ctx, backend, tx, receipt.BlockNumber),
+	}
+}
+
+// revertReason replays tx at block and returns the reason it reverted with.
+// It is empty when the replay fails for any other cause, such as a node that
+// cannot be reached or no longer has the state of block, since such an error
+// says nothing about why tx failed.
+func revertReason(ctx context.Context, backend receiptBackend, tx *types.Transaction, block *big.Int) string {
+	from, err := types.Sender(types.LatestSignerForChainID(tx.ChainId()), tx)
+	if err != nil {
+		return ""
+	}
+	msg := ethereum.CallMsg{
+		From:  from,
+		To:    tx.To(),
+		Gas:   tx.Gas(),
+		Value: tx.Value(),
+		Data:  tx.Data(),
+	}
+	_, err = backend.CallContract(ctx, msg, block)
+	if err == nil || !errors.Is(txerr.Classify(err), txerr.ErrReverted) {
+		return ""
+	}
+	return txerr.RevertReason(err)
+}
+
+// txEvents are the EmeraldToken events emitted by one transaction.
+type txEvents struct {
+	Transfers          []*MainTransfer
+	Approvals          []*MainApproval
+	FilmsAdded         []*MainFilmAdded
+	FilmsDeleted       []*MainFilmDeleted
+	OwnershipTransfers []*MainOwnershipTransferred
+}
+
+// txResult is the outcome of a successfully mined transaction.
+type txResult struct {
+	Tx      *types.Transaction
+	Receipt *types.Receipt
+	Events  txEvents
+}
+
+// decodeEvents parses the logs of receipt emitted by the contract at address.
+func decodeEvents(filterer *MainFilterer, address common.Address, receipt *types.Receipt) (txEvents, error) {
+	var events txEvents
+	for _, log := range receipt.Logs {
+		if log.Address != address || len(log.Topics) == 0 {
+			continue
+		}
+		var err error
+		switch log.Topics[0] {
+		case mainEventID("Transfer"):
+			var ev *MainTransfer
+			if ev, err = filterer.ParseTransfer(*log); err == nil {
+				events.Transfers = append(events.Transfers, ev)
+			}
+		case mainEventID("Approval"):
+			var ev *MainApproval
+			if ev, err = filterer.ParseApproval(*log); err == nil {
+				events.Approvals = append(events.Approvals, ev)
+			}
+		case mainEventID("FilmAdded"):
+			var ev *MainFilmAdded
+			if ev, err = filterer.ParseFilmAdded(*log); err == nil {
+				events.FilmsAdded = append(events.FilmsAdded, ev)
+			}
+		case mainEventID("FilmDeleted"):
+			var ev *MainFilmDeleted
+			if ev, err = filterer.ParseFilmDeleted(*log); err == nil {
+				events.FilmsDeleted = append(events.FilmsDeleted, ev)
+			}
+		case mainEventID("OwnershipTransferred"):
+			var ev *MainOwnershipTransferred
+			if ev, err = filterer.ParseOwnershipTransferred(*log); err == nil {
+				events.OwnershipTransfers = append(events.OwnershipTransfers, ev)
+			}
+		}
+		if err != nil {
+			return events, fmt.Errorf("decoding log %d of %s: %w", log.Index, log.TxHash.Hex(), err)
+		}
+	}
+	return events, nil
+}
+
+// mainABI is the parsed EmeraldToken ABI.
+var mainABI = func() *abi.ABI {
+	parsed, err := MainMetaData.GetAbi()
+	if err != nil {
+		panic(err)
+	}
+	return parsed
+}()
+
+// mainEventID returns the topic hash of the named EmeraldToken event.
+func mainEventID(name string) common.Hash {
+	return mainABI.Events[name].ID
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// callError is a JSON-RPC error as ethclient returns it.
+type callError struct {
+	code    int
+	message string
+	data    interface{}
+}
+
+func (e *callError) Error() string          { return e.message }
+func (e *callError) ErrorCode() int         { return e.code }
+func (e *callError) ErrorData() interface{} { return e.data }
+
+// replayBackend fails every call with err.
+type replayBackend struct {
+	receiptBackend
+	err error
+}
+
+func (b replayBackend) CallContract(context.Context, ethereum.CallMsg, *big.Int) ([]byte, error) {
+	return nil, b.err
+}
+
+func TestRevertReason(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	to := common.HexToAddress("0xe")
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(devnetChainID), &types.LegacyTx{To: &to, Gas: 21000, GasPrice: big.NewInt(1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// Error(string) of "Ownable: caller is not the owner".
+	ownable := "0x08c379a0" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"0000000000000000000000000000000000000000000000000000000000000020" +
+		"4f776e61626c653a2063616c6c6572206973206e6f7420746865206f776e6572"
+	for _, tc := range []struct {
+		name string
+		err  error
+		want string
+	}{
+		{"no revert", nil, ""},
+		{"revert data", &callError{code: 3, message: "execution reverted", data: ownable}, "Ownable: caller is not the owner"},
+		{"revert message", errors.New("execution reverted: ERC20: insufficient allowance"), "ERC20: insufficient allowance"},
+		{"bare revert", errors.New("execution reverted"), ""},
+		{"unreachable node", errors.New("dial tcp 127.0.0.1:8545: connect: connection refused"), ""},
+		{"pruned state", &callError{code: -32000, message: "missing trie node 0123 (path ) <nil>"}, ""},
+		{"timeout", context.DeadlineExceeded, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			got := revertReason(context.Background(), replayBackend{err: tc.err}, tx, big.NewInt(1))
+			if got != tc.want {
+				t.Errorf("revertReason = %q, want %q", got, tc.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// minPriceBump is the fee increase, in percent, that go-ethereum's txpool
+// requires before it replaces a pending transaction (txpool.pricebump).
+const minPriceBump = 10
+
+// maxReplaceAttempts bounds how often the fee is bumped again when the node
+// still considers a replacement underpriced.
+const maxReplaceAttempts = 5
+
+// bumpPercent raises x by percent, rounding up so the result never falls
+// short of the node's threshold.
+func bumpPercent(x *big.Int, percent uint64) *big.Int {
+	y := new(big.Int).Mul(x, new(big.Int).SetUint64(100+percent))
+	y.Add(y, big.NewInt(99))
+	return y.Div(y, big.NewInt(100))
+}
+
+func maxBig(a, b *big.Int) *big.Int {
+	if b != nil && (a == nil || b.Cmp(a) > 0) {
+		return b
+	}
+	return a
+}
+
+// bumpFees prices a replacement for old: its fees raised by percent, or the
+// current market fees when those are higher. The replacement keeps the
+// transaction type of old, since the pool compares like with like.
+func bumpFees(old *types.Transaction, current *txFees, percent uint64) *txFees {
+	if old.Type() == types.LegacyTxType {
+		price := bumpPercent(old.GasPrice(), percent)
+		return &txFees{gasPrice: maxBig(price, maxBig(current.gasPrice, current.gasFeeCap))}
+	}
+	tip := maxBig(bumpPercent(old.GasTipCap(), percent), current.gasTipCap)
+	feeCap := maxBig(bumpPercent(old.GasFeeCap(), percent), current.gasFeeCap)
+	feeCap = maxBig(feeCap, tip)
+	return &txFees{gasFeeCap: feeCap, gasTipCap: tip}
+}
+
+// replacementTx builds an unsigned transaction with the nonce of old. A speed
+// up repeats old's call; a cancel is a zero-value transfer to the sender.
+func replacementTx(old *types.Transaction, from common.Address, chainID *big.Int, fees *txFees, cancel bool) *types.Transaction {
+	to, value, data, gas := old.To(), old.Value(), old.Data(), old.Gas()
+	if cancel {
+		to, value, data, gas = &from, new(big.Int), nil, params.TxGas
+	}
+	if fees.gasPrice != nil {
+		return types.NewTx(&types.LegacyTx{
+			Nonce:    old.Nonce(),
+			GasPrice: fees.gasPrice,
+			Gas:      gas,
+			To:       to,
+			Value:    value,
+			Data:     data,
+		})
+	}
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     old.Nonce(),
+		GasTipCap: fees.gasTipCap,
+		GasFeeCap: fees.gasFeeCap,
+		Gas:       gas,
+		To:        to,
+		Value:     value,
+		Data:      data,
+	})
+}
+
+// errAlreadyMined is returned when the transaction to replace is no longer
+// pending.
+var errAlreadyMined = errors.New("transaction is already mined")
+
+// replace broadcasts a speed up or cancel for the pending transaction hash.
+// It returns the original transaction followed by every replacement that was
+// sent, since any of them may end up mined.
+func (s *session) replace(ctx context.Context, hash common.Hash, cancel bool, percent uint64) ([]*types.Transaction, error) {
+	if percent < minPriceBump {
+		return nil, fmt.Errorf("fee bump must be at least %d%%", minPriceBump)
+	}
+
+	old, pending, err := s.client.TransactionByHash(ctx, hash)
+	if err != nil {
+		return nil, txerr.Classify(err)
+	}
+	if !pending {
+		return []*types.Transaction{old}, errAlreadyMined
+	}
+
+	from := s.from()
+	sender, err := types.Sender(types.LatestSignerForChainID(s.chainID), old)
+	if err != nil {
+		return nil, err
+	}
+	if sender != from {
+		return nil, fmt.Errorf("transaction %s was sent by %s, not by the signing account %s", hash.Hex(), sender.Hex(), from.Hex())
+	}
+
+	current, err := suggestFees(ctx, s.client, s.feeMode)
+	if err != nil {
+		return nil, txerr.Classify(err)
+	}
+
+	intent := "tx speedup --hash " + hash.Hex()
+	if cancel {
+		intent = "tx cancel --hash " + hash.Hex()
+	}
+
+	sent := []*types.Transaction{old}
+	prev := old
+	for attempt := 0; attempt < maxReplaceAttempts; attempt++ {
+		fees := bumpFees(prev, current, percent)
+
+		unsigned := replacementTx(prev, from, s.chainID, fees, cancel)
+		tx, err := s.sendJournaled(ctx, intent, old.Nonce(), func() (*types.Transaction, error) {
+			auth, err := s.signer.TransactOpts(ctx)
+			if err != nil {
+				return nil, err
+			}
+			return auth.Signer(from, unsigned)
+		})
+		if err == nil {
+			return append(sent, tx), nil
+		}
+		err = txerr.Classify(err)
+		if tx != nil && !txerr.ClassOf(err).Rejected() {
+			// The node may hold the replacement after all.
+			sent = append(sent, tx)
+		}
+		if !errors.Is(err, txerr.ErrReplacementUnderpriced) {
+			return sent, err
+		}
+		// Bump on top of the fees just rejected and try again.
+		prev = tx
+	}
+	return sent, fmt.Errorf("replacement still underpriced after %d attempts", maxReplaceAttempts)
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/params"
+)
+
+func TestBumpPercent(t *testing.T) {
+	for _, tc := range []struct {
+		x       int64
+		percent uint64
+		want    int64
+	}{
+		{100, 10, 110},
+		{1000, 12, 1120},
+		{1, 10, 2},
+		{9, 10, 10},
+		{10, 10, 11},
+		{11, 10, 13},
+		{0, 10, 0},
+		{1_000_000_007, 10, 1_100_000_008},
+		{50, 100, 100},
+	} {
+		if got := bumpPercent(big.NewInt(tc.x), tc.percent); got.Int64() != tc.want {
+			t.Errorf("%d bumped by %d%% = %s, want %d", tc.x, tc.percent, got, tc.want)
+		}
+	}
+}
+
+func legacyTx(gasPrice int64) *types.Transaction {
+	return types.NewTx(&types.LegacyTx{Nonce: 4, GasPrice: big.NewInt(gasPrice), Gas: 50_000})
+}
+
+func dynamicTx(feeCap, tip int64) *types.Transaction {
+	return types.NewTx(&types.DynamicFeeTx{
+		ChainID:   big.NewInt(1337),
+		Nonce:     4,
+		GasFeeCap: big.NewInt(feeCap),
+		GasTipCap: big.NewInt(tip),
+		Gas:       50_000,
+	})
+}
+
+func TestBumpFees(t *testing.T) {
+	legacy := func(price int64) *txFees { return &txFees{gasPrice: big.NewInt(price)} }
+	london := func(feeCap, tip int64) *txFees {
+		return &txFees{gasFeeCap: big.NewInt(feeCap), gasTipCap: big.NewInt(tip)}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		old     *types.Transaction
+		current *txFees
+		percent uint64
+		want    string
+	}{
+		{"legacy bumped", legacyTx(100), legacy(50), 10, "gasPrice=110"},
+		{"legacy rounds up", legacyTx(101), legacy(50), 10, "gasPrice=112"},
+		{"legacy market is higher", legacyTx(100), legacy(200), 10, "gasPrice=200"},
+		{"legacy market fee cap is higher", legacyTx(100), london(300, 5), 10, "gasPrice=300"},
+		{"legacy larger bump", legacyTx(100), legacy(120), 25, "gasPrice=125"},
+		{"london bumped", dynamicTx(200, 10), london(100, 5), 10, "maxFeePerGas=220 maxPriorityFeePerGas=11"},
+		{"london market tip is higher", dynamicTx(200, 10), london(100, 30), 10, "maxFeePerGas=220 maxPriorityFeePerGas=30"},
+		{"london market cap is higher", dynamicTx(200, 10), london(500, 5), 10, "maxFeePerGas=500 maxPriorityFeePerGas=11"},
+		{"london cap covers the tip", dynamicTx(20, 10), london(15, 40), 10, "maxFeePerGas=40 maxPriorityFeePerGas=40"},
+		{"london from legacy market", dynamicTx(200, 10), legacy(1000), 10, "maxFeePerGas=220 maxPriorityFeePerGas=11"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			if got := bumpFees(tc.old, tc.current, tc.percent).String(); got != tc.want {
+				t.Errorf("replacement fees %s, want %s", got, tc.want)
+			}
+		})
+	}
+}
+
+func TestReplacementTx(t *testing.T) {
+	from := common.HexToAddress("0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266")
+	to := common.HexToAddress("0x5FbDB2315678afecb367f032d93F642f64180aa3")
+	chainID := big.NewInt(1337)
+	old := types.NewTx(&types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     7,
+		GasFeeCap: big.NewInt(200),
+		GasTipCap: big.NewInt(10),
+		Gas:       80_000,
+		To:        &to,
+		Value:     big.NewInt(3),
+		Data:      []byte{0xa9, 0x05, 0x9c, 0xbb},
+	})
+
+	for _, tc := range []struct {
+		name   string
+		fees   *txFees
+		cancel bool
+		txType uint8
+		to     common.Address
+		value  int64
+		gas    uint64
+		data   int
+	}{
+		{"speed up", &txFees{gasFeeCap: big.NewInt(220), gasTipCap: big.NewInt(11)}, false, types.DynamicFeeTxType, to, 3, 80_000, 4},
+		{"speed up legacy", &txFees{gasPrice: big.NewInt(220)}, false, types.LegacyTxType, to, 3, 80_000, 4},
+		{"cancel", &txFees{gasFeeCap: big.NewInt(220), gasTipCap: big.NewInt(11)}, true, types.DynamicFeeTxType, from, 0, params.TxGas, 0},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			tx := replacementTx(old, from, chainID, tc.fees, tc.cancel)
+			if tx.Nonce() != old.Nonce() {
+				t.Errorf("nonce %d, want %d", tx.Nonce(), old.Nonce())
+			}
+			if tx.Type() != tc.txType {
+				t.Errorf("type %d, want %d", tx.Type(), tc.txType)
+			}
+			if *tx.To() != tc.to || tx.Value().Int64() != tc.value || tx.Gas() != tc.gas || len(tx.Data()) != tc.data {
+				t.Errorf("to %s value %s gas %d data %x", tx.To(), tx.Value(), tx.Gas(), tx.Data())
+			}
+			if tc.fees.gasPrice != nil {
+				if tx.GasPrice().Cmp(tc.fees.gasPrice) != 0 {
+					t.Errorf("gas price %s, want %s", tx.GasPrice(), tc.fees.gasPrice)
+				}
+				return
+			}
+			if tx.ChainId().Cmp(chainID) != 0 || tx.GasFeeCap().Cmp(tc.fees.gasFeeCap) != 0 || tx.GasTipCap().Cmp(tc.fees.gasTipCap) != 0 {
+				t.Errorf("chain %s fee cap %s tip %s", tx.ChainId(), tx.GasFeeCap(), tx.GasTipCap())
+			}
+		})
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// connFlags are the flags shared by every command that talks to the chain.
+type connFlags struct {
+	config   string
+	network  string
+	rpc      string
+	contract string
+
+	signerKind    string
+	key           string
+	keystore      string
+	hdPath        string
+	accountIndex  uint
+	remoteSigner  string
+	remoteMethod  string
+	signerAddress string
+
+	gasMargin     uint64
+	gasLimit      uint64
+	feeMode       string
+	wait          bool
+	timeout       time.Duration
+	confirmations int64
+	journalPath   string
+	sends         bool // registerTx was called
+
+	logChunk    uint64
+	logParallel int
+}
+
+func (c *connFlags) register(fs *flag.FlagSet) {
+	fs.StringVar(&c.config, "config", "", "network config file (default $EMERALD_CONFIG or "+defaultConfigPath+")")
+	fs.StringVar(&c.network, "network", "", "network profile to use (default $EMERALD_NETWORK or the config default)")
+	fs.StringVar(&c.rpc, "rpc", "", "override the JSON-RPC endpoint of the profile")
+	fs.StringVar(&c.contract, "contract", "", "override the EmeraldToken address of the profile")
+	fs.StringVar(&c.signerKind, "signer", signerKey, "signing backend: key, keystore, mnemonic or remote")
+	fs.StringVar(&c.key, "key", "", "hex private key for --signer key (default $PRIVATE_KEY)")
+	fs.StringVar(&c.keystore, "keystore", "", "keystore file for --signer keystore (passphrase from $KEYSTORE_PASSPHRASE or prompt)")
+	fs.StringVar(&c.hdPath, "hd-path", defaultHDPath, "BIP-32 base path for --signer mnemonic ($MNEMONIC)")
+	fs.UintVar(&c.accountIndex, "account-index", 0, "account index appended to --hd-path")
+	fs.StringVar(&c.remoteSigner, "remote-signer", "", "HTTP JSON-RPC endpoint for --signer remote")
+	fs.StringVar(&c.remoteMethod, "remote-method", remoteMethodEth, "signing method of the remote signer ("+remoteMethodEth+" or "+remoteMethodAccount+")")
+	fs.StringVar(&c.signerAddress, "from", "", "account of the remote signer (default: its first account)")
+}
+
+// registerTx adds the flags that control how transactions are priced. Only
+// commands that send transactions register them.
+func (c *connFlags) registerTx(fs *flag.FlagSet) {
+	c.sends = true
+	fs.Uint64Var(&c.gasMargin, "gas-margin", 20, "percentage added to the estimated gas limit")
+	fs.Uint64Var(&c.gasLimit, "gas-limit", 0, "fixed gas limit, skipping estimation")
+	fs.StringVar(&c.feeMode, "fee-mode", feeModeAuto, "fee pricing: auto, london (EIP-1559) or legacy")
+	fs.BoolVar(&c.wait, "wait", true, "wait for the receipt and report the result")
+	fs.DurationVar(&c.timeout, "timeout", 5*time.Minute, "how long to wait for the receipt")
+	fs.Int64Var(&c.confirmations, "confirmations", -1, "blocks to wait on top of the receipt (default: the profile's confirmations)")
+	fs.StringVar(&c.journalPath, "journal", "", "transaction journal file (default $EMERALD_JOURNAL or "+defaultJournalPath+")")
+}
+
+// profile loads the config file and resolves the selected network, applying
+// the --rpc and --contract overrides on top of it.
+func (c *connFlags) profile() (string, *networkProfile, error) {
+	path := c.config
+	if path == "" {
+		path = os.Getenv("EMERALD_CONFIG")
+	}
+	if path == "" {
+		path = defaultConfigPath
+	}
+	cfg, err := loadConfig(path)
+	if err != nil {
+		return "", nil, err
+	}
+
+	name := c.network
+	if name == "" {
+		name = os.Getenv("EMERALD_NETWORK")
+	}
+	if name == "" {
+		name = cfg.Default
+	}
+	profile, err := cfg.profile(name)
+	if err != nil {
+		return "", nil, err
+	}
+
+	resolved := *profile
+	resolved.Contracts = make(map[string]string, len(profile.Contracts))
+	for k, v := range profile.Contracts {
+		resolved.Contracts[k] = v
+	}
+	if c.rpc != "" {
+		resolved.RPC = c.rpc
+	}
+	if c.contract != "" {
+		resolved.Contracts[tokenContract] = c.contract
+	}
+	return name, &resolved, nil
+}
+
+// session is an open connection to the node and the bound token contract.
+type session struct {
+	client        *ethclient.Client
+	networkName   string
+	network       *networkProfile
+	chainID       *big.Int
+	address       common.Address
+	token         *Main
+	signer        Signer
+	nonces        *nonceManager
+	gasLimit      uint64
+	feeMode       string
+	wait          bool
+	timeout       time.Duration
+	confirmations uint64
+	journal       *journal
+	logs          *logBackfill
+}
+
+// open dials the node of the selected network, checks that it serves the
+// expected chain and binds the contract. The signing key is only loaded when
+// withKey is set, so read-only commands work without one.
+func (c *connFlags) open(withKey bool) (*session, error) {
+	name, network, err := c.profile()
+	if err != nil {
+		return nil, err
+	}
+	address, err := network.contract(tokenContract)
+	if err != nil {
+		return nil, fmt.Errorf("network %q: %w", name, err)
+	}
+
+	client, err := ethclient.Dial(network.endpoint())
+	if err != nil {
+		return nil, err
+	}
+
+	chainID, err := resolveChainID(context.Background(), client, network.ChainID)
+	if err != nil {
+		client.Close()
+		return nil, fmt.Errorf("network %q: %w", name, err)
+	}
+
+	var signer Signer
+	if withKey {
+		if signer, err = c.signer(context.Background(), chainID); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	token, err := NewMain(address, &gasMarginBackend{ContractBackend: client, marginPercent: c.gasMargin})
+	if err != nil {
+		client.Close()
+		return nil, err
+	}
+
+	var jrnl *journal
+	if signer != nil && c.sends {
+		path := c.journalPath
+		if path == "" {
+			path = os.Getenv("EMERALD_JOURNAL")
+		}
+		if path == "" {
+			path = defaultJournalPath
+		}
+		if jrnl, err = openJournal(path); err != nil {
+			client.Close()
+			return nil, err
+		}
+	}
+
+	confirmations := network.Confirmations
+	if c.confirmations >= 0 {
+		confirmations = uint64(c.confirmations)
+	}
+
+	s := &session{
+		client:        client,
+		networkName:   name,
+		network:       network,
+		chainID:       chainID,
+		address:       address,
+		token:         token,
+		signer:        signer,
+		nonces:        newNonceManager(client),
+		gasLimit:      c.gasLimit,
+		feeMode:       c.feeMode,
+		wait:          c.wait,
+		timeout:       c.timeout,
+		confirmations: confirmations,
+		journal:       jrnl,
+		logs:          newLogBackfill(client, c.logChunk, c.logParallel),
+	}
+	if jrnl != nil {
+		if err := s.resumeJournal(context.Background()); err != nil {
+			s.Close()
+			return nil, err
+		}
+	}
+	return s, nil
+}
+
+func (s *session) Close() {
+	if s.journal != nil {
+		s.journal.Close()
+	}
+	if closer, ok := s.signer.(interface{ Close() }); ok {
+		closer.Close()
+	}
+	s.client.Close()
+}
+
+func (s *session) from() common.Address {
+	return s.signer.Address()
+}
+
+// staleNonce reports whether the node rejected a transaction because its nonce
+// is already taken, by a mined or by a pending transaction. Either way a fresh
+// nonce from the node fixes it.
+func staleNonce(err error) bool {
+	return errors.Is(err, txerr.ErrNonceTooLow) || errors.Is(err, txerr.ErrReplacementUnderpriced)
+}
+
+// maxNonceRetries bounds how often a transaction is rebuilt after the node
+// reported a stale nonce.
+const maxNonceRetries = 3
+
+// transact sends the transaction built by send with a nonce from the session's
+// nonce manager, journaling it under intent. When the node rejects the nonce,
+// the manager is resynced and the transaction rebuilt; any other rejection
+// releases the nonce again. A broadcast that failed without a definite
+// rejection keeps its nonce, since the node may have the transaction.
+func (s *session) transact(intent string, send func(*bind.TransactOpts) (*types.Transaction, error)) (*types.Transaction, error) {
+	ctx := context.Background()
+	from := s.from()
+	if err := s.checkDuplicate(intent); err != nil {
+		return nil, err
+	}
+	fees, err := suggestFees(ctx, s.client, s.feeMode)
+	if err != nil {
+		return nil, txerr.Classify(err)
+	}
+	for attempt := 0; ; attempt++ {
+		nonce, err := s.nonces.reserve(ctx, from)
+		if err != nil {
+			return nil, txerr.Classify(err)
+		}
+
+		auth, err := getTransactionOpts(ctx, s.signer, nonce, fees, s.gasLimit)
+		if err != nil {
+			s.nonces.release(from, nonce)
+			return nil, err
+		}
+		// The binding only builds and signs; sendJournaled broadcasts.
+		auth.NoSend = true
+
+		tx, err := s.sendJournaled(ctx, intent, nonce, func() (*types.Transaction, error) {
+			return send(auth)
+		})
+		if err == nil {
+			s.nonces.commit(from, nonce)
+			return tx, nil
+		}
+		err = txerr.Classify(err)
+		if tx != nil && !txerr.ClassOf(err).Rejected() {
+			// The node may hold tx after all, so its nonce stays taken.
+			s.nonces.commit(from, nonce)
+			return nil, err
+		}
+		if !staleNonce(err) || attempt >= maxNonceRetries {
+			s.nonces.release(from, nonce)
+			return nil, err
+		}
+		s.nonces.commit(from, nonce)
+		if err := s.nonces.resync(ctx, from); err != nil {
+			return nil, txerr.Classify(err)
+		}
+	}
+}
+
+// confirm waits until tx is mined with the session's confirmation depth and
+// decodes the contract events it emitted. A reverted transaction is reported
+// as a *txFailedError.
+func (s *session) confirm(tx *types.Transaction) (*txResult, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), s.timeout)
+	defer cancel()
+
+	receipt, err := waitMined(ctx, s.client, tx, s.confirmations)
+	if err != nil {
+		return nil, txerr.Classify(err)
+	}
+	if err := s.journalMined(tx, receipt, nil); err != nil {
+		return nil, err
+	}
+	if err := checkReceipt(ctx, s.client, tx, receipt); err != nil {
+		return nil, txerr.Classify(err)
+	}
+	events, err := decodeEvents(&s.token.MainFilterer, s.address, receipt)
+	if err != nil {
+		return nil, err
+	}
+	return &txResult{Tx: tx, Receipt: receipt, Events: events}, nil
+}
+
+// report waits for tx unless --wait=false was given and prints the outcome.
+func (s *session) report(tx *types.Transaction) error {
+	if !s.wait {
+		return nil
+	}
+	res, err := s.confirm(tx)
+	if err != nil {
+		return err
+	}
+	printResult(os.Stdout, res)
+	return nil
+}
+
+func printResult(w io.Writer, res *txResult) {
+	fmt.Fprintf(w, "mined in block %s (%s), gas used %d\n",
+		res.Receipt.BlockNumber, res.Receipt.BlockHash.Hex(), res.Receipt.GasUsed)
+	for _, ev := range res.Events.Transfers {
+		fmt.Fprintf(w, "  Transfer %s -> %s: %s\n", ev.From.Hex(), ev.To.Hex(), ev.Value)
+	}
+	for _, ev := range res.Events.Approvals {
+		fmt.Fprintf(w, "  Approval %s -> %s: %s\n", ev.Owner.Hex(), ev.Spender.Hex(), ev.Value)
+	}
+	for _, ev := range res.Events.FilmsAdded {
+		fmt.Fprintf(w, "  FilmAdded %q year=%s genre=%s\n", ev.Title, ev.Year, genreName(ev.Genre))
+	}
+	for _, ev := range res.Events.FilmsDeleted {
+		fmt.Fprintf(w, "  FilmDeleted %q\n", ev.Title)
+	}
+	for _, ev := range res.Events.OwnershipTransfers {
+		fmt.Fprintf(w, "  OwnershipTransferred %s -> %s\n", ev.PreviousOwner.Hex(), ev.NewOwner.Hex())
+	}
+}
@@ -0,0 +1,758 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// newTestDevnet starts a devnet with three accounts and the token deployed
+// from the first, and serves it in-process.
+func newTestDevnet(t *testing.T) (*devnet, *rpc.Server) {
+	t.Helper()
+	d, err := newDevnet(&devnetConfig{
+		mnemonic: devnetMnemonic,
+		accounts: 3,
+		balance:  big.NewInt(params.Ether),
+		gasLimit: 30_000_000,
+		supply:   big.NewInt(testSupply),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(d.Close)
+	server, err := d.newRPCServer()
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(server.Stop)
+	return d, server
+}
+
+// newTestSession opens a session that signs with key, talks to the devnet
+// through ethclient like the commands do and journals to a temporary file.
+func newTestSession(t *testing.T, d *devnet, server *rpc.Server, key *ecdsa.PrivateKey) *session {
+	t.Helper()
+	client := ethclient.NewClient(rpc.DialInProc(server))
+	signer, err := newKeySigner(key, devnetChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := NewMain(d.token, &gasMarginBackend{ContractBackend: client, marginPercent: 20})
+	if err != nil {
+		t.Fatal(err)
+	}
+	jrnl, err := openJournal(filepath.Join(t.TempDir(), "journal.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := &session{
+		client:      client,
+		networkName: "devnet",
+		network:     &networkProfile{ChainID: devnetChainID.Uint64(), DeployBlock: d.deployBlock},
+		chainID:     devnetChainID,
+		address:     d.token,
+		token:       token,
+		signer:      signer,
+		nonces:      newNonceManager(client),
+		feeMode:     feeModeAuto,
+		wait:        true,
+		timeout:     10 * time.Second,
+		journal:     jrnl,
+		logs:        newLogBackfill(client, 0, 0),
+	}
+	t.Cleanup(s.Close)
+	return s
+}
+
+func TestSessionTransact(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	alice := d.accounts[1].address
+
+	intent := "token transfer --to " + alice.Hex() + " --amount 1000"
+	tx, err := s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, alice, big.NewInt(1000))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The deployment used nonce 0.
+	if tx.Nonce() != 1 {
+		t.Errorf("nonce = %d, want 1", tx.Nonce())
+	}
+	res, err := s.confirm(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events.Transfers) != 1 {
+		t.Fatalf("got %d Transfer events, want 1", len(res.Events.Transfers))
+	}
+	ev := res.Events.Transfers[0]
+	if ev.From != s.from() || ev.To != alice || ev.Value.Int64() != 1000 {
+		t.Errorf("Transfer %s -> %s: %s, want %s -> %s: 1000", ev.From.Hex(), ev.To.Hex(), ev.Value, s.from().Hex(), alice.Hex())
+	}
+
+	entry, err := s.journal.byHash(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.State != stateMined || entry.Block != res.Receipt.BlockNumber.Uint64() || entry.Intent != intent {
+		t.Fatalf("journal entry = %+v, want %q mined in block %s", entry, intent, res.Receipt.BlockNumber)
+	}
+
+	// A mined intent may be sent again.
+	tx, err = s.transact(intent, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, alice, big.NewInt(1000))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != 2 {
+		t.Errorf("nonce = %d, want 2", tx.Nonce())
+	}
+}
+
+func TestSessionFilmEvents(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[1].key)
+
+	tx, err := s.transact("film add", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.AddFilm(auth, "Alien", big.NewInt(1979), 0)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := s.confirm(tx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var out bytes.Buffer
+	printResult(&out, res)
+	if want := `FilmAdded "Alien" year=1979 genre=` + genreName(0); !strings.Contains(out.String(), want) {
+		t.Errorf("printed result\n%s\ndoes not contain %s", out.String(), want)
+	}
+
+	tx, err = s.transact("film delete", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.DeleteFilm(auth, "Alien")
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if res, err = s.confirm(tx); err != nil {
+		t.Fatal(err)
+	}
+	if len(res.Events.FilmsDeleted) != 1 || res.Events.FilmsDeleted[0].Title != "Alien" {
+		t.Errorf("deleteFilm decoded to %+v, want FilmDeleted \"Alien\"", res.Events.FilmsDeleted)
+	}
+	if len(res.Events.FilmsAdded) != 0 || len(res.Events.Transfers) != 0 {
+		t.Errorf("deleteFilm decoded to unrelated events %+v", res.Events)
+	}
+}
+
+// TestSessionRevertBeforeSending checks that a call that reverts during gas
+// estimation is classified, never reaches the chain and gives its nonce back.
+func TestSessionRevertBeforeSending(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[1].key)
+
+	_, err := s.transact("token mint", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Mint(auth, s.from(), big.NewInt(1))
+	})
+	if !errors.Is(err, txerr.ErrNotOwner) || !errors.Is(err, txerr.ErrReverted) {
+		t.Fatalf("mint by a non-owner failed with %v, want txerr.ErrNotOwner", err)
+	}
+	if txerr.Retryable(err) {
+		t.Errorf("%v is retryable", err)
+	}
+	entries, err := s.journal.entries(func(*journalEntry) bool { return true })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 1 || entries[0].State != stateDropped || !strings.Contains(entries[0].Error, "caller is not the owner") {
+		t.Fatalf("journal entries = %+v, want one dropped for the revert", entries)
+	}
+
+	tx, err := s.transact("token approve", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Approve(auth, d.accounts[0].address, big.NewInt(1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != 0 {
+		t.Errorf("nonce after a revert = %d, want the released 0", tx.Nonce())
+	}
+}
+
+// TestSessionRevertMined checks that a transaction mined with status 0 is
+// reported with the reason replayed from its block.
+func TestSessionRevertMined(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[1].key)
+	// A fixed gas limit skips the estimation that would catch the revert.
+	s.gasLimit = 100_000
+
+	tx, err := s.transact("token mint", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Mint(auth, s.from(), big.NewInt(1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Gas() != 100_000 {
+		t.Errorf("gas limit = %d, want the fixed 100000", tx.Gas())
+	}
+	_, err = s.confirm(tx)
+	var failed *txFailedError
+	if !errors.As(err, &failed) {
+		t.Fatalf("confirm returned %v, want a *txFailedError", err)
+	}
+	if failed.TxHash != tx.Hash() || failed.Reason != "Ownable: caller is not the owner" {
+		t.Errorf("failure of %s with reason %q, want %s with the Ownable reason", failed.TxHash.Hex(), failed.Reason, tx.Hash().Hex())
+	}
+	if !errors.Is(err, txerr.ErrNotOwner) {
+		t.Errorf("%v does not match txerr.ErrNotOwner", err)
+	}
+	entry, err := s.journal.byHash(tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if entry == nil || entry.State != stateMined {
+		t.Errorf("journal entry = %+v, want mined: the nonce is spent", entry)
+	}
+}
+
+// TestSessionNonceResync sends from the session's account behind its back,
+// so that the nonce manager hands out a used nonce and has to resync.
+func TestSessionNonceResync(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	alice := d.accounts[1].address
+	transfer := func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, alice, big.NewInt(1))
+	}
+
+	if _, err := s.transact("first", transfer); err != nil {
+		t.Fatal(err)
+	}
+	other := newTestSession(t, d, server, d.accounts[0].key)
+	stolen, err := other.transact("elsewhere", transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tx, err := s.transact("second", transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != stolen.Nonce()+1 {
+		t.Errorf("nonce after resync = %d, want %d", tx.Nonce(), stolen.Nonce()+1)
+	}
+	entries, err := s.journal.entries(func(e *journalEntry) bool { return e.Intent == "second" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || !strings.Contains(entries[0].Error, "nonce too low") {
+		t.Errorf("journal entries = %+v, want a dropped nonce too low attempt and the resent one", entries)
+	}
+}
+
+// sendRawHook replaces eth_sendRawTransaction of a test devnet once
+// registered on its server under "eth".
+type sendRawHook func(ctx context.Context, tx *types.Transaction) error
+
+func (send sendRawHook) SendRawTransaction(ctx context.Context, input hexutil.Bytes) (common.Hash, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(input); err != nil {
+		return common.Hash{}, err
+	}
+	if err := send(ctx, tx); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// lossyTransport serves JSON-RPC over HTTP from server and, while lose is
+// set, loses the response to every eth_sendRawTransaction the node accepted,
+// as a client timing out after the node took the transaction would.
+type lossyTransport struct {
+	server *rpc.Server
+	lose   bool
+}
+
+func (lt *lossyTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := io.ReadAll(req.Body)
+	if err != nil {
+		return nil, err
+	}
+	req.Body = io.NopCloser(bytes.NewReader(body))
+	rec := httptest.NewRecorder()
+	lt.server.ServeHTTP(rec, req)
+	if lt.lose && bytes.Contains(body, []byte("eth_sendRawTransaction")) && bytes.Contains(rec.Body.Bytes(), []byte(`"result"`)) {
+		return nil, transportTimeout{}
+	}
+	return rec.Result(), nil
+}
+
+type transportTimeout struct{}
+
+func (transportTimeout) Error() string   { return "i/o timeout" }
+func (transportTimeout) Timeout() bool   { return true }
+func (transportTimeout) Temporary() bool { return true }
+
+// lossyClient has s talk to server over a lossyTransport.
+func lossyClient(t *testing.T, s *session, server *rpc.Server) *lossyTransport {
+	t.Helper()
+	lt := &lossyTransport{server: server}
+	c, err := rpc.DialHTTPWithClient("http://devnet", &http.Client{Transport: lt})
+	if err != nil {
+		t.Fatal(err)
+	}
+	s.client = ethclient.NewClient(c)
+	return lt
+}
+
+// TestSessionReplaceUnderpriced has the node refuse two replacements in a row
+// as underpriced; every attempt must bump the fees of the one just refused.
+func TestSessionReplaceUnderpriced(t *testing.T) {
+	d, server := newTestDevnet(t)
+	if err := d.setAutomine(false); err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	alice := d.accounts[1].address
+
+	stuck, err := s.transact("stuck", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, alice, big.NewInt(1))
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var sent []*types.Transaction
+	err = server.RegisterName("eth", sendRawHook(func(_ context.Context, tx *types.Transaction) error {
+		sent = append(sent, tx)
+		if len(sent) <= 2 {
+			return errors.New("replacement transaction underpriced")
+		}
+		// The devnet has no pool to replace in; take the third as accepted.
+		return nil
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	replaced, err := s.replace(context.Background(), stuck.Hash(), false, minPriceBump)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(sent) != 3 {
+		t.Fatalf("sent %d replacements, want 3", len(sent))
+	}
+	if len(replaced) != 2 || replaced[0].Hash() != stuck.Hash() || replaced[1].Hash() != sent[2].Hash() {
+		t.Errorf("replace returned %d transactions, want the stuck one and the accepted replacement", len(replaced))
+	}
+	prev := stuck
+	for i, tx := range sent {
+		if tx.Nonce() != stuck.Nonce() {
+			t.Errorf("replacement %d has nonce %d, want %d", i, tx.Nonce(), stuck.Nonce())
+		}
+		if tx.GasFeeCap().Cmp(bumpPercent(prev.GasFeeCap(), minPriceBump)) < 0 || tx.GasTipCap().Cmp(bumpPercent(prev.GasTipCap(), minPriceBump)) < 0 {
+			t.Errorf("replacement %d pays %s/%s, not %d%% more than %s/%s", i, tx.GasFeeCap(), tx.GasTipCap(), minPriceBump, prev.GasFeeCap(), prev.GasTipCap())
+		}
+		prev = tx
+	}
+
+	entries, err := s.journal.entries(func(e *journalEntry) bool { return e.Nonce == stuck.Nonce() && e.Intent != "stuck" })
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 3 || entries[0].State != stateDropped || entries[1].State != stateDropped || entries[2].State != stateBroadcast {
+		t.Errorf("journal entries = %+v, want two dropped replacements and a broadcast one", entries)
+	}
+}
+
+// TestSessionAmbiguousSend fails broadcasts without a definite rejection: one
+// that reached the node and one that did not. Both stay signed in the journal
+// until resumeJournal finds or rebroadcasts them.
+func TestSessionAmbiguousSend(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	alice := d.accounts[1].address
+	transfer := func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, alice, big.NewInt(1))
+	}
+
+	lossy := lossyClient(t, s, server)
+	var fail error
+	deliver := true
+	err := server.RegisterName("eth", sendRawHook(func(ctx context.Context, tx *types.Transaction) error {
+		if deliver {
+			if err := d.sendTransaction(ctx, tx); err != nil {
+				return err
+			}
+		}
+		return fail
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	signed := func(intent string) *journalEntry {
+		t.Helper()
+		entries, err := s.journal.entries(func(e *journalEntry) bool { return e.Intent == intent })
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(entries) != 1 || entries[0].State != stateSigned || entries[0].Error == "" {
+			t.Fatalf("journal entries = %+v, want one signed with the error", entries)
+		}
+		return entries[0]
+	}
+
+	lossy.lose = true
+	if _, err := s.transact("timed out", transfer); !errors.Is(err, txerr.ErrTimeout) {
+		t.Fatalf("transact returned %v, want txerr.ErrTimeout", err)
+	}
+	timedOut := signed("timed out")
+	lossy.lose = false
+	tx, err := s.transact("next", transfer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tx.Nonce() != timedOut.Nonce+1 {
+		t.Errorf("nonce after a timed out send = %d, want %d", tx.Nonce(), timedOut.Nonce+1)
+	}
+
+	fail, deliver = errors.New("connection reset by peer"), false
+	if _, err := s.transact("lost", transfer); err == nil {
+		t.Fatal("transact succeeded")
+	}
+	lost := signed("lost")
+	if _, err := s.transact("lost", transfer); err == nil || !strings.Contains(err.Error(), "already pending") {
+		t.Errorf("sending a pending intent again returned %v", err)
+	}
+
+	fail, deliver = nil, true
+	if err := s.resumeJournal(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []struct {
+		hash  common.Hash
+		state journalState
+	}{{timedOut.Hash, stateMined}, {lost.Hash, stateBroadcast}} {
+		entry, err := s.journal.byHash(want.hash)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if entry.State != want.state {
+			t.Errorf("entry %d %q reconciled to %s, want %s", entry.ID, entry.Intent, entry.State, want.state)
+		}
+	}
+	if _, err := s.client.TransactionReceipt(context.Background(), lost.Hash); err != nil {
+		t.Errorf("the lost transaction was not rebroadcast: %v", err)
+	}
+}
+
+// receiptHook replaces eth_getTransactionReceipt of a test devnet once
+// registered on its server under "eth". It answers null for a hash as many
+// times as hidden says before it shows the receipt.
+type receiptHook struct {
+	api    *devnetEthAPI
+	hidden map[common.Hash]int
+}
+
+func (h *receiptHook) GetTransactionReceipt(ctx context.Context, hash common.Hash) (map[string]interface{}, error) {
+	if h.hidden[hash] > 0 {
+		h.hidden[hash]--
+		return nil, nil
+	}
+	return h.api.GetTransactionReceipt(ctx, hash)
+}
+
+// journalTransfer signs a transfer of one wei from the first devnet account
+// with nonce and journals it as signed but not broadcast, as a crash right
+// after signing leaves it.
+func journalTransfer(t *testing.T, s *session, d *devnet, intent string, nonce uint64, gasPrice int64) (*types.Transaction, *journalEntry) {
+	t.Helper()
+	to := d.accounts[1].address
+	tx, err := types.SignNewTx(d.accounts[0].key, d.signer, &types.LegacyTx{
+		Nonce:    nonce,
+		Gas:      21000,
+		GasPrice: big.NewInt(gasPrice),
+		To:       &to,
+		Value:    big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := &journalEntry{Intent: intent, ChainID: s.chainID.Uint64(), From: s.from(), Nonce: nonce}
+	if err := s.journal.create(entry); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.journal.markSigned(entry, tx); err != nil {
+		t.Fatal(err)
+	}
+	return tx, entry
+}
+
+func reloadEntry(t *testing.T, s *session, entry *journalEntry) *journalEntry {
+	t.Helper()
+	entries, err := s.journal.entries(func(e *journalEntry) bool { return e.ID == entry.ID })
+	if err != nil || len(entries) != 1 {
+		t.Fatalf("journal entry %d: %v, %v", entry.ID, entries, err)
+	}
+	return entries[0]
+}
+
+// TestResumeJournalRefused has the node refuse rebroadcasts for good and
+// once for now. Neither may keep the session from sending, and the refused
+// entries are dropped.
+func TestResumeJournalRefused(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	nonce, err := s.client.PendingNonceAt(context.Background(), s.from())
+	if err != nil {
+		t.Fatal(err)
+	}
+	underpriced, underpricedEntry := journalTransfer(t, s, d, "underpriced", nonce, params.GWei)
+	poor, poorEntry := journalTransfer(t, s, d, "poor", nonce, 2*params.GWei)
+	_, timedOutEntry := journalTransfer(t, s, d, "timed out", nonce, 3*params.GWei)
+	// The node refuses the first two; the response for the last one is lost
+	// although the node did not keep it.
+	lossy := lossyClient(t, s, server)
+	lossy.lose = true
+	err = server.RegisterName("eth", sendRawHook(func(ctx context.Context, tx *types.Transaction) error {
+		switch tx.Hash() {
+		case underpriced.Hash():
+			return errors.New("transaction underpriced")
+		case poor.Hash():
+			return errors.New("insufficient funds for gas * price + value")
+		default:
+			return nil
+		}
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.resumeJournal(context.Background()); err != nil {
+		t.Fatalf("resumeJournal: %v", err)
+	}
+	for _, want := range []struct {
+		entry *journalEntry
+		state journalState
+	}{{underpricedEntry, stateDropped}, {poorEntry, stateDropped}, {timedOutEntry, stateSigned}} {
+		if got := reloadEntry(t, s, want.entry); got.State != want.state {
+			t.Errorf("entry %q reconciled to %s, want %s", got.Intent, got.State, want.state)
+		}
+	}
+
+	lossy.lose = false
+	err = server.RegisterName("eth", sendRawHook(func(ctx context.Context, tx *types.Transaction) error {
+		return d.sendTransaction(ctx, tx)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := s.transact("after", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.Transfer(auth, d.accounts[1].address, big.NewInt(1))
+	})
+	if err != nil {
+		t.Fatalf("sending after the refusals: %v", err)
+	}
+	// The transaction just sent took the nonce of the one that timed out.
+	if err := s.resumeJournal(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloadEntry(t, s, timedOutEntry); got.State != stateReplaced || got.ReplacedBy != tx.Hash() {
+		t.Errorf("entry %q is %s by %s, want replaced by %s", got.Intent, got.State, got.ReplacedBy.Hex(), tx.Hash().Hex())
+	}
+}
+
+// TestResumeJournalUsedNonce reconciles entries whose nonce the chain has
+// moved past: one replaced by a transaction the journal does not know, found
+// on the chain, and one mined between the lookups of its receipt and of the
+// nonce.
+func TestResumeJournalUsedNonce(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	ctx := context.Background()
+	nonce, err := s.client.PendingNonceAt(ctx, s.from())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, replacedEntry := journalTransfer(t, s, d, "replaced", nonce, params.GWei)
+	other := fundingTx(t, d, d.accounts[2].address)
+	if other.Nonce() != nonce {
+		t.Fatalf("nonce %d, want %d", other.Nonce(), nonce)
+	}
+	if err := d.sendTransaction(ctx, other); err != nil {
+		t.Fatal(err)
+	}
+	late, lateEntry := journalTransfer(t, s, d, "late", nonce+1, params.GWei)
+	if err := d.sendTransaction(ctx, late); err != nil {
+		t.Fatal(err)
+	}
+	d.mu.Lock()
+	for i := 0; i < 5; i++ {
+		if err := d.mine(nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.mu.Unlock()
+
+	hook := &receiptHook{api: &devnetEthAPI{d: d}, hidden: map[common.Hash]int{late.Hash(): 1}}
+	if err := server.RegisterName("eth", hook); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.resumeJournal(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if got := reloadEntry(t, s, replacedEntry); got.State != stateReplaced || got.ReplacedBy != other.Hash() {
+		t.Errorf("entry %q is %s by %s, want replaced by %s", got.Intent, got.State, got.ReplacedBy.Hex(), other.Hash().Hex())
+	}
+	if got := reloadEntry(t, s, lateEntry); got.State != stateMined {
+		t.Errorf("entry %q is %s, want mined", got.Intent, got.State)
+	}
+}
+
+func TestSessionInsufficientFunds(t *testing.T) {
+	d, server := newTestDevnet(t)
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	s := newTestSession(t, d, server, key)
+	s.gasLimit = 100_000
+
+	_, err = s.transact("film add", func(auth *bind.TransactOpts) (*types.Transaction, error) {
+		return s.token.AddFilm(auth, "Alien", big.NewInt(1979), 0)
+	})
+	if !errors.Is(err, txerr.ErrInsufficientFunds) {
+		t.Fatalf("sending without ether failed with %v, want txerr.ErrInsufficientFunds", err)
+	}
+	if txerr.Retryable(err) {
+		t.Errorf("%v is retryable", err)
+	}
+}
+
+func TestSessionTransactionOpts(t *testing.T) {
+	d, server := newTestDevnet(t)
+	s := newTestSession(t, d, server, d.accounts[0].key)
+	ctx := context.Background()
+	alice := d.accounts[1].address
+
+	data, err := mainABI.Pack("transfer", alice, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		mode   string
+		txType uint8
+	}{
+		{feeModeLegacy, types.LegacyTxType},
+		{feeModeLondon, types.DynamicFeeTxType},
+		{feeModeAuto, types.DynamicFeeTxType},
+	} {
+		t.Run(tc.mode, func(t *testing.T) {
+			s.feeMode = tc.mode
+			fees, err := suggestFees(ctx, s.client, tc.mode)
+			if err != nil {
+				t.Fatal(err)
+			}
+			estimate, err := s.client.EstimateGas(ctx, ethereum.CallMsg{From: s.from(), To: &d.token, Data: data})
+			if err != nil {
+				t.Fatal(err)
+			}
+			tx, err := s.transact("transfer "+tc.mode, func(auth *bind.TransactOpts) (*types.Transaction, error) {
+				if auth.Value.Sign() != 0 || auth.GasLimit != 0 {
+					t.Errorf("options carry value %s and gas limit %d, want 0 and 0", auth.Value, auth.GasLimit)
+				}
+				return s.token.Transfer(auth, alice, big.NewInt(1))
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx.Type() != tc.txType {
+				t.Errorf("transaction type = %d, want %d", tx.Type(), tc.txType)
+			}
+			if tx.ChainId().Cmp(devnetChainID) != 0 {
+				t.Errorf("chain id = %s, want %s", tx.ChainId(), devnetChainID)
+			}
+			if fees.gasPrice != nil && tx.GasPrice().Cmp(fees.gasPrice) != 0 {
+				t.Errorf("gas price = %s, want %s", tx.GasPrice(), fees.gasPrice)
+			}
+			if fees.gasFeeCap != nil && (tx.GasFeeCap().Cmp(fees.gasFeeCap) != 0 || tx.GasTipCap().Cmp(fees.gasTipCap) != 0) {
+				t.Errorf("fees = %s/%s, want %s", tx.GasFeeCap(), tx.GasTipCap(), fees)
+			}
+			if want := estimate + estimate*20/100; tx.Gas() != want {
+				t.Errorf("gas limit = %d, want the estimate %d with a 20%% margin, %d", tx.Gas(), estimate, want)
+			}
+			if _, err := s.confirm(tx); err != nil {
+				t.Fatal(err)
+			}
+		})
+	}
+
+	if _, err := suggestFees(ctx, s.client, "cheap"); err == nil {
+		t.Error("unknown fee mode accepted")
+	}
+}
+
+func TestKeySignerGuardsChainID(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signer, err := newKeySigner(key, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	auth, err := signer.TransactOpts(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := types.NewTx(&types.DynamicFeeTx{ChainID: devnetChainID, Gas: params.TxGas, GasFeeCap: big.NewInt(1), GasTipCap: big.NewInt(1)})
+	if _, err := auth.Signer(signer.Address(), other); !errors.Is(err, errChainIDMismatch) {
+		t.Errorf("signing for chain %s with a chain 1 signer returned %v, want errChainIDMismatch", devnetChainID, err)
+	}
+
+	legacy := types.NewTx(&types.LegacyTx{Gas: params.TxGas, GasPrice: big.NewInt(1), To: &common.Address{}})
+	signed, err := auth.Signer(signer.Address(), legacy)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if signed.ChainId().Cmp(big.NewInt(1)) != 0 {
+		t.Errorf("legacy transaction signed for chain %s, want 1", signed.ChainId())
+	}
+
+	if _, err := resolveChainID(context.Background(), chainIDFunc(func() *big.Int { return devnetChainID }), 1); !errors.Is(err, errChainIDMismatch) {
+		t.Errorf("a node on chain %s configured as chain 1 returned %v, want errChainIDMismatch", devnetChainID, err)
+	}
+}
+
+// chainIDFunc is a chainIDReader reporting a fixed id.
+type chainIDFunc func() *big.Int
+
+func (f chainIDFunc) ChainID(context.Context) (*big.Int, error) { return f(), nil }
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// errChainIDMismatch is returned when the chain a transaction would be signed
+// for differs from the chain the client was configured for.
+var errChainIDMismatch = errors.New("chain id mismatch")
+
+// Signer produces transaction options that sign for a single account on a
+// single chain. Implementations differ only in where the key lives.
+type Signer interface {
+	// Address is the account transactions are sent from.
+	Address() common.Address
+	// TransactOpts returns fresh options whose Signer signs for Address.
+	TransactOpts(ctx context.Context) (*bind.TransactOpts, error)
+}
+
+// Signing backends selectable with --signer.
+const (
+	signerKey      = "key"
+	signerKeystore = "keystore"
+	signerMnemonic = "mnemonic"
+	signerRemote   = "remote"
+)
+
+// signer builds the signing backend selected by the flags for chainID.
+func (c *connFlags) signer(ctx context.Context, chainID *big.Int) (Signer, error) {
+	switch c.signerKind {
+	case signerKey, "":
+		value := c.key
+		if value == "" {
+			value = os.Getenv("PRIVATE_KEY")
+		}
+		if value == "" {
+			return nil, errors.New("no signing key: pass --key or set PRIVATE_KEY")
+		}
+		key, err := crypto.HexToECDSA(strings.TrimPrefix(value, "0x"))
+		if err != nil {
+			return nil, fmt.Errorf("invalid private key: %w", err)
+		}
+		return newKeySigner(key, chainID)
+
+	case signerKeystore:
+		if c.keystore == "" {
+			return nil, errors.New("--signer keystore requires --keystore")
+		}
+		return newKeystoreSigner(c.keystore, chainID)
+
+	case signerMnemonic:
+		mnemonic := os.Getenv("MNEMONIC")
+		if mnemonic == "" {
+			return nil, errors.New("--signer mnemonic requires $MNEMONIC")
+		}
+		return newMnemonicSigner(mnemonic, os.Getenv("MNEMONIC_PASSPHRASE"), c.hdPath, uint32(c.accountIndex), chainID)
+
+	case signerRemote:
+		if c.remoteSigner == "" {
+			return nil, errors.New("--signer remote requires --remote-signer")
+		}
+		var from common.Address
+		if c.signerAddress != "" {
+			var err error
+			if from, err = parseAddress("from", c.signerAddress); err != nil {
+				return nil, err
+			}
+		}
+		return newRemoteSigner(ctx, c.remoteSigner, c.remoteMethod, from, chainID)
+
+	default:
+		return nil, fmt.Errorf("unknown signer %q (want %s, %s, %s or %s)", c.signerKind, signerKey, signerKeystore, signerMnemonic, signerRemote)
+	}
+}
+
+// chainIDReader is implemented by clients that can report the chain id.
+type chainIDReader interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+}
+
+// resolveChainID asks the node for its chain id and checks it against the
+// configured one. A configured id of zero accepts whatever the node reports.
+func resolveChainID(ctx context.Context, node chainIDReader, configured uint64) (*big.Int, error) {
+	chainID, err := node.ChainID(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if configured != 0 && (!chainID.IsUint64() || chainID.Uint64() != configured) {
+		return nil, fmt.Errorf("%w: configured %d, node reports %s", errChainIDMismatch, configured, chainID)
+	}
+	return chainID, nil
+}
+
+// keySigner signs transactions with an in-memory private key, always with
+// EIP-155 replay protection for a single chain. The raw key, keystore and
+// mnemonic backends all end up here once the key is decrypted or derived.
+type keySigner struct {
+	key     *ecdsa.PrivateKey
+	chainID *big.Int
+}
+
+func newKeySigner(key *ecdsa.PrivateKey, chainID *big.Int) (*keySigner, error) {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return nil, errors.New("signing requires a chain id")
+	}
+	return &keySigner{key: key, chainID: new(big.Int).Set(chainID)}, nil
+}
+
+func (s *keySigner) Address() common.Address {
+	return crypto.PubkeyToAddress(s.key.PublicKey)
+}
+
+// TransactOpts returns options whose Signer refuses transactions that carry a
+// different chain id than the one the signer was created for.
+func (s *keySigner) TransactOpts(ctx context.Context) (*bind.TransactOpts, error) {
+	opts, err := bind.NewKeyedTransactorWithChainID(s.key, s.chainID)
+	if err != nil {
+		return nil, err
+	}
+	opts.Signer = guardChainID(s.chainID, opts.Signer)
+	opts.Context = ctx
+	return opts, nil
+}
+
+// guardChainID wraps sign so that typed transactions built for another chain
+// are rejected before a signature exists. Transactions without a chain id of
+// their own, such as unsigned legacy ones, get the signer's id.
+func guardChainID(chainID *big.Int, sign bind.SignerFn) bind.SignerFn {
+	return func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		if tx.Type() != types.LegacyTxType && tx.ChainId().Sign() != 0 && tx.ChainId().Cmp(chainID) != 0 {
+			return nil, fmt.Errorf("%w: transaction for chain %s, signer for chain %s", errChainIDMismatch, tx.ChainId(), chainID)
+		}
+		return sign(from, tx)
+	}
+}
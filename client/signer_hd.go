@@ -0,0 +1,89 @@
+package main
+
+import (
+	"crypto/ecdsa"
+	"crypto/hmac"
+	"crypto/sha512"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/tyler-smith/go-bip39"
+)
+
+// defaultHDPath is the BIP-44 Ethereum account path; the account index is
+// appended as the last component.
+const defaultHDPath = "m/44'/60'/0'/0"
+
+// newMnemonicSigner derives the key at basePath/index from a BIP-39 mnemonic
+// and optional BIP-39 passphrase.
+func newMnemonicSigner(mnemonic, passphrase, basePath string, index uint32, chainID *big.Int) (*keySigner, error) {
+	seed, err := bip39.NewSeedWithErrorChecking(mnemonic, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("invalid mnemonic: %w", err)
+	}
+
+	path, err := accounts.ParseDerivationPath(basePath)
+	if err != nil {
+		return nil, err
+	}
+	path = append(path, index)
+
+	key, err := deriveHDKey(seed, path)
+	if err != nil {
+		return nil, err
+	}
+	return newKeySigner(key, chainID)
+}
+
+// deriveHDKey walks a BIP-32 derivation path from the master key of seed.
+func deriveHDKey(seed []byte, path accounts.DerivationPath) (*ecdsa.PrivateKey, error) {
+	mac := hmac.New(sha512.New, []byte("Bitcoin seed"))
+	mac.Write(seed)
+	sum := mac.Sum(nil)
+	key, chainCode := new(big.Int).SetBytes(sum[:32]), sum[32:]
+
+	n := crypto.S256().Params().N
+	if key.Sign() == 0 || key.Cmp(n) >= 0 {
+		return nil, errors.New("seed produces an invalid master key")
+	}
+
+	for _, index := range path {
+		var data []byte
+		if index >= 0x80000000 {
+			// Hardened child: 0x00 || ser256(k) || ser32(i)
+			data = append([]byte{0}, ser256(key)...)
+		} else {
+			// Normal child: serP(point(k)) || ser32(i)
+			priv, err := crypto.ToECDSA(ser256(key))
+			if err != nil {
+				return nil, err
+			}
+			data = crypto.CompressPubkey(&priv.PublicKey)
+		}
+		data = binary.BigEndian.AppendUint32(data, index)
+
+		mac := hmac.New(sha512.New, chainCode)
+		mac.Write(data)
+		sum := mac.Sum(nil)
+
+		tweak := new(big.Int).SetBytes(sum[:32])
+		if tweak.Cmp(n) >= 0 {
+			return nil, fmt.Errorf("derivation path %s: invalid child at index %d", path, index)
+		}
+		key = tweak.Add(tweak, key).Mod(tweak, n)
+		if key.Sign() == 0 {
+			return nil, fmt.Errorf("derivation path %s: invalid child at index %d", path, index)
+		}
+		chainCode = sum[32:]
+	}
+	return crypto.ToECDSA(ser256(key))
+}
+
+// ser256 serializes a scalar as a 32-byte big-endian number.
+func ser256(k *big.Int) []byte {
+	return k.FillBytes(make([]byte, 32))
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/accounts/keystore"
+	"golang.org/x/term"
+)
+
+// newKeystoreSigner decrypts a go-ethereum scrypt keystore file. The
+// passphrase is taken from $KEYSTORE_PASSPHRASE when set and otherwise read
+// from the terminal without echo.
+func newKeystoreSigner(path string, chainID *big.Int) (*keySigner, error) {
+	keyJSON, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	passphrase, ok := os.LookupEnv("KEYSTORE_PASSPHRASE")
+	if !ok {
+		if passphrase, err = promptPassphrase(fmt.Sprintf("Passphrase for %s: ", path)); err != nil {
+			return nil, err
+		}
+	}
+
+	key, err := keystore.DecryptKey(keyJSON, passphrase)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+	return newKeySigner(key.PrivateKey, chainID)
+}
+
+func promptPassphrase(prompt string) (string, error) {
+	fd := int(os.Stdin.Fd())
+	if !term.IsTerminal(fd) {
+		return "", errors.New("no terminal to prompt for the keystore passphrase: set KEYSTORE_PASSPHRASE")
+	}
+	fmt.Fprint(os.Stderr, prompt)
+	passphrase, err := term.ReadPassword(fd)
+	fmt.Fprintln(os.Stderr)
+	if err != nil {
+		return "", err
+	}
+	return string(passphrase), nil
+}
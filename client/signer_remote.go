@@ -0,0 +1,175 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Remote signing methods. web3signer serves eth_signTransaction and returns
+// the raw transaction; Clef serves account_signTransaction and returns an
+// object holding it. Either response shape is accepted for either method.
+const (
+	remoteMethodEth     = "eth_signTransaction"
+	remoteMethodAccount = "account_signTransaction"
+)
+
+// remoteSigner delegates signing to an external process over HTTP JSON-RPC,
+// so the key never enters this process.
+type remoteSigner struct {
+	client  *rpc.Client
+	method  string
+	from    common.Address
+	chainID *big.Int
+}
+
+// newRemoteSigner connects to the signer at url. When from is the zero
+// address, the first account the signer reports through eth_accounts is used.
+func newRemoteSigner(ctx context.Context, url, method string, from common.Address, chainID *big.Int) (*remoteSigner, error) {
+	if chainID == nil || chainID.Sign() <= 0 {
+		return nil, errors.New("signing requires a chain id")
+	}
+	if method == "" {
+		method = remoteMethodEth
+	}
+	client, err := rpc.DialHTTP(url)
+	if err != nil {
+		return nil, err
+	}
+
+	if from == (common.Address{}) {
+		var accounts []common.Address
+		if err := client.CallContext(ctx, &accounts, "eth_accounts"); err != nil {
+			client.Close()
+			return nil, fmt.Errorf("remote signer: listing accounts: %w", err)
+		}
+		if len(accounts) == 0 {
+			client.Close()
+			return nil, errors.New("remote signer has no accounts")
+		}
+		from = accounts[0]
+	}
+
+	return &remoteSigner{client: client, method: method, from: from, chainID: new(big.Int).Set(chainID)}, nil
+}
+
+func (s *remoteSigner) Address() common.Address {
+	return s.from
+}
+
+func (s *remoteSigner) Close() {
+	s.client.Close()
+}
+
+func (s *remoteSigner) TransactOpts(ctx context.Context) (*bind.TransactOpts, error) {
+	sign := func(from common.Address, tx *types.Transaction) (*types.Transaction, error) {
+		if from != s.from {
+			return nil, bind.ErrNotAuthorized
+		}
+		return s.signTx(ctx, tx)
+	}
+	return &bind.TransactOpts{
+		From:    s.from,
+		Signer:  guardChainID(s.chainID, sign),
+		Context: ctx,
+	}, nil
+}
+
+// remoteTxArgs is the transaction object of eth_signTransaction.
+type remoteTxArgs struct {
+	From                 common.Address  `json:"from"`
+	To                   *common.Address `json:"to,omitempty"`
+	Gas                  hexutil.Uint64  `json:"gas"`
+	GasPrice             *hexutil.Big    `json:"gasPrice,omitempty"`
+	MaxFeePerGas         *hexutil.Big    `json:"maxFeePerGas,omitempty"`
+	MaxPriorityFeePerGas *hexutil.Big    `json:"maxPriorityFeePerGas,omitempty"`
+	Value                *hexutil.Big    `json:"value"`
+	Nonce                hexutil.Uint64  `json:"nonce"`
+	Data                 hexutil.Bytes   `json:"data"`
+	ChainID              *hexutil.Big    `json:"chainId"`
+}
+
+func (s *remoteSigner) signTx(ctx context.Context, tx *types.Transaction) (*types.Transaction, error) {
+	args := remoteTxArgs{
+		From:    s.from,
+		To:      tx.To(),
+		Gas:     hexutil.Uint64(tx.Gas()),
+		Value:   (*hexutil.Big)(tx.Value()),
+		Nonce:   hexutil.Uint64(tx.Nonce()),
+		Data:    tx.Data(),
+		ChainID: (*hexutil.Big)(s.chainID),
+	}
+	switch tx.Type() {
+	case types.LegacyTxType:
+		args.GasPrice = (*hexutil.Big)(tx.GasPrice())
+	case types.DynamicFeeTxType:
+		args.MaxFeePerGas = (*hexutil.Big)(tx.GasFeeCap())
+		args.MaxPriorityFeePerGas = (*hexutil.Big)(tx.GasTipCap())
+	default:
+		return nil, fmt.Errorf("remote signer: unsupported transaction type %d", tx.Type())
+	}
+
+	var result json.RawMessage
+	if err := s.client.CallContext(ctx, &result, s.method, args); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	raw, err := decodeSignResult(result)
+	if err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+
+	signed := new(types.Transaction)
+	if err := signed.UnmarshalBinary(raw); err != nil {
+		return nil, fmt.Errorf("remote signer: decoding signed transaction: %w", err)
+	}
+	if err := s.checkSigned(tx, signed); err != nil {
+		return nil, fmt.Errorf("remote signer: %w", err)
+	}
+	return signed, nil
+}
+
+// decodeSignResult accepts both the raw hex string returned by web3signer and
+// the {"raw": ..., "tx": ...} object returned by Clef.
+func decodeSignResult(result json.RawMessage) ([]byte, error) {
+	var raw hexutil.Bytes
+	if err := json.Unmarshal(result, &raw); err == nil {
+		return raw, nil
+	}
+	var obj struct {
+		Raw hexutil.Bytes `json:"raw"`
+	}
+	if err := json.Unmarshal(result, &obj); err != nil || len(obj.Raw) == 0 {
+		return nil, fmt.Errorf("unexpected response %s", result)
+	}
+	return obj.Raw, nil
+}
+
+// checkSigned makes sure the remote side signed what was asked for, from the
+// expected account and for the expected chain.
+func (s *remoteSigner) checkSigned(want, got *types.Transaction) error {
+	if got.ChainId().Cmp(s.chainID) != 0 {
+		return fmt.Errorf("%w: signed for chain %s, expected %s", errChainIDMismatch, got.ChainId(), s.chainID)
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(s.chainID), got)
+	if err != nil {
+		return err
+	}
+	if sender != s.from {
+		return fmt.Errorf("signed by %s, expected %s", sender.Hex(), s.from.Hex())
+	}
+	if got.Nonce() != want.Nonce() || got.Gas() != want.Gas() ||
+		got.GasFeeCap().Cmp(want.GasFeeCap()) != 0 || got.GasTipCap().Cmp(want.GasTipCap()) != 0 ||
+		got.Value().Cmp(want.Value()) != 0 || string(got.Data()) != string(want.Data()) ||
+		(got.To() == nil) != (want.To() == nil) || (got.To() != nil && *got.To() != *want.To()) {
+		return errors.New("signed transaction does not match the request")
+	}
+	return nil
+}
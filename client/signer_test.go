@@ -0,0 +1,293 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/params"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// TestDeriveHDKey checks the private keys of test vector 1 of BIP-32.
+func TestDeriveHDKey(t *testing.T) {
+	seed, err := hex.DecodeString("000102030405060708090a0b0c0d0e0f")
+	if err != nil {
+		t.Fatal(err)
+	}
+	const h = 0x80000000
+	for _, tc := range []struct {
+		path accounts.DerivationPath
+		key  string
+	}{
+		{accounts.DerivationPath{}, "e8f32e723decf4051aefac8e2c93c9c5b214313817cdb01a1494b917c8436b35"},
+		{accounts.DerivationPath{h + 0}, "edb2e14f9ee77d26dd93b4ecede8d16ed408ce149b6cd80b0715a2d911a0afea"},
+		{accounts.DerivationPath{h + 0, 1}, "3c6cb8d0f6a264c91ea8b5030fadaa8e538b020f0a387421a12de9319dc93368"},
+		{accounts.DerivationPath{h + 0, 1, h + 2}, "cbce0d719ecf7431d88e6a89fa1483e02e35092af60c042b1df2ff59fa424dca"},
+		{accounts.DerivationPath{h + 0, 1, h + 2, 2}, "0f479245fb19a38a1954c5c7c0ebab2f9bdfd96a17563ef28a6a4b1a2a764ef4"},
+		{accounts.DerivationPath{h + 0, 1, h + 2, 2, 1000000000}, "471b76e389e528d6de6d816857e012c5455051cad6660850e58372a6c3e6e7c8"},
+	} {
+		key, err := deriveHDKey(seed, tc.path)
+		if err != nil {
+			t.Fatalf("%v: %v", tc.path, err)
+		}
+		if got := hex.EncodeToString(crypto.FromECDSA(key)); got != tc.key {
+			t.Errorf("%v: key %s, want %s", tc.path, got, tc.key)
+		}
+	}
+}
+
+func TestMnemonicSigner(t *testing.T) {
+	const mnemonic = "test test test test test test test test test test test junk"
+	for _, tc := range []struct {
+		index   uint32
+		address string
+	}{
+		{0, "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266"},
+		{1, "0x70997970C51812dc3A010C7d01b50e0d17dc79C8"},
+	} {
+		signer, err := newMnemonicSigner(mnemonic, "", defaultHDPath, tc.index, big.NewInt(1))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := signer.Address().Hex(); got != tc.address {
+			t.Errorf("%s/%d: address %s, want %s", defaultHDPath, tc.index, got, tc.address)
+		}
+	}
+
+	withPassphrase, err := newMnemonicSigner(mnemonic, "secret", defaultHDPath, 0, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if withPassphrase.Address().Hex() == "0xf39Fd6e51aad88F6F4ce6aB8827279cffFb92266" {
+		t.Error("the passphrase did not change the derived account")
+	}
+	if _, err := newMnemonicSigner("test test test test test test test test test test test test", "", defaultHDPath, 0, big.NewInt(1)); err == nil {
+		t.Error("a mnemonic with a bad checksum was accepted")
+	}
+	if _, err := newMnemonicSigner(mnemonic, "", "m/44'/sixty'", 0, big.NewInt(1)); err == nil {
+		t.Error("an invalid derivation path was accepted")
+	}
+}
+
+// TestRemoteSignerDevnet signs through the devnet's eth_signTransaction over
+// HTTP and sends the result.
+func TestRemoteSignerDevnet(t *testing.T) {
+	d, server := newTestDevnet(t)
+	srv := httptest.NewServer(server)
+	t.Cleanup(srv.Close)
+	ctx := context.Background()
+	client, err := ethclient.Dial(srv.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(client.Close)
+
+	signer, err := newRemoteSigner(ctx, srv.URL, "", common.Address{}, devnetChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(signer.Close)
+	from, to := d.accounts[0].address, d.accounts[1].address
+	if signer.Address() != from {
+		t.Fatalf("remote signer uses %s, want the first account %s", signer.Address().Hex(), from.Hex())
+	}
+	auth, err := signer.TransactOpts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	nonce, err := client.PendingNonceAt(ctx, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, unsigned := range []*types.Transaction{
+		types.NewTx(&types.LegacyTx{Nonce: nonce, GasPrice: big.NewInt(params.GWei), Gas: params.TxGas, To: &to, Value: big.NewInt(1)}),
+		types.NewTx(&types.DynamicFeeTx{
+			ChainID: devnetChainID, Nonce: nonce + 1, GasFeeCap: big.NewInt(params.GWei), GasTipCap: big.NewInt(1),
+			Gas: params.TxGas, To: &to, Value: big.NewInt(2),
+		}),
+	} {
+		tx, err := auth.Signer(from, unsigned)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sender, err := types.Sender(types.LatestSignerForChainID(devnetChainID), tx)
+		if err != nil || sender != from {
+			t.Fatalf("type %d transaction signed by %s (%v), want %s", tx.Type(), sender.Hex(), err, from.Hex())
+		}
+		if err := client.SendTransaction(ctx, tx); err != nil {
+			t.Fatal(err)
+		}
+		receipt, err := client.TransactionReceipt(ctx, tx.Hash())
+		if err != nil {
+			t.Fatal(err)
+		}
+		if receipt.Status != types.ReceiptStatusSuccessful {
+			t.Errorf("type %d transaction failed", tx.Type())
+		}
+	}
+
+	if _, err := auth.Signer(to, types.NewTx(&types.LegacyTx{Gas: params.TxGas, To: &to})); !errors.Is(err, bind.ErrNotAuthorized) {
+		t.Errorf("signing for another account returned %v, want bind.ErrNotAuthorized", err)
+	}
+
+	second, err := newRemoteSigner(ctx, srv.URL, remoteMethodEth, to, devnetChainID)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(second.Close)
+	if second.Address() != to {
+		t.Errorf("remote signer uses %s, want the requested %s", second.Address().Hex(), to.Hex())
+	}
+
+	mainnet, err := newRemoteSigner(ctx, srv.URL, "", common.Address{}, big.NewInt(1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(mainnet.Close)
+	auth, err = mainnet.TransactOpts(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := auth.Signer(from, types.NewTx(&types.LegacyTx{Gas: params.TxGas, To: &to})); err == nil {
+		t.Error("the devnet signed for chain 1")
+	}
+}
+
+// fakeRemoteSigner serves eth_accounts and both signing methods, answering
+// the latter with respond.
+type fakeRemoteSigner struct {
+	from    common.Address
+	respond func(args remoteTxArgs) (interface{}, error)
+}
+
+func (f *fakeRemoteSigner) Accounts() []common.Address {
+	return []common.Address{f.from}
+}
+
+func (f *fakeRemoteSigner) SignTransaction(args remoteTxArgs) (interface{}, error) {
+	return f.respond(args)
+}
+
+// signArgs signs the transaction args describe with key for chainID, after
+// change had a chance to alter it.
+func signArgs(args remoteTxArgs, key *ecdsa.PrivateKey, chainID *big.Int, change func(*types.DynamicFeeTx)) (hexutil.Bytes, error) {
+	tx := &types.DynamicFeeTx{
+		ChainID:   chainID,
+		Nonce:     uint64(args.Nonce),
+		GasTipCap: args.MaxPriorityFeePerGas.ToInt(),
+		GasFeeCap: args.MaxFeePerGas.ToInt(),
+		Gas:       uint64(args.Gas),
+		To:        args.To,
+		Value:     args.Value.ToInt(),
+		Data:      args.Data,
+	}
+	if change != nil {
+		change(tx)
+	}
+	signed, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return nil, err
+	}
+	return signed.MarshalBinary()
+}
+
+func TestRemoteSignerResponses(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	other, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+	chainID := big.NewInt(5)
+
+	for _, tc := range []struct {
+		name    string
+		method  string
+		respond func(args remoteTxArgs) (interface{}, error)
+		wantErr string
+	}{
+		{"raw", remoteMethodEth, func(args remoteTxArgs) (interface{}, error) {
+			return signArgs(args, key, chainID, nil)
+		}, ""},
+		{"clef object", remoteMethodAccount, func(args remoteTxArgs) (interface{}, error) {
+			raw, err := signArgs(args, key, chainID, nil)
+			return map[string]interface{}{"raw": raw, "tx": map[string]string{}}, err
+		}, ""},
+		{"other chain", remoteMethodEth, func(args remoteTxArgs) (interface{}, error) {
+			return signArgs(args, key, big.NewInt(1), nil)
+		}, "chain id mismatch"},
+		{"other key", remoteMethodEth, func(args remoteTxArgs) (interface{}, error) {
+			return signArgs(args, other, chainID, nil)
+		}, "signed by"},
+		{"changed nonce", remoteMethodEth, func(args remoteTxArgs) (interface{}, error) {
+			return signArgs(args, key, chainID, func(tx *types.DynamicFeeTx) { tx.Nonce++ })
+		}, "does not match"},
+		{"changed recipient", remoteMethodAccount, func(args remoteTxArgs) (interface{}, error) {
+			return signArgs(args, key, chainID, func(tx *types.DynamicFeeTx) { tx.To = &from })
+		}, "does not match"},
+		{"no signature", remoteMethodAccount, func(args remoteTxArgs) (interface{}, error) {
+			return map[string]string{"tx": "pending approval"}, nil
+		}, "unexpected response"},
+		{"refused", remoteMethodEth, func(args remoteTxArgs) (interface{}, error) {
+			return nil, errors.New("request denied")
+		}, "request denied"},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			server := rpc.NewServer()
+			t.Cleanup(server.Stop)
+			fake := &fakeRemoteSigner{from: from, respond: tc.respond}
+			for _, namespace := range []string{"eth", "account"} {
+				if err := server.RegisterName(namespace, fake); err != nil {
+					t.Fatal(err)
+				}
+			}
+			srv := httptest.NewServer(server)
+			t.Cleanup(srv.Close)
+
+			signer, err := newRemoteSigner(context.Background(), srv.URL, tc.method, common.Address{}, chainID)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(signer.Close)
+			auth, err := signer.TransactOpts(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			to := common.HexToAddress("0x5FbDB2315678afecb367f032d93F642f64180aa3")
+			unsigned := types.NewTx(&types.DynamicFeeTx{
+				ChainID: chainID, Nonce: 3, GasFeeCap: big.NewInt(100), GasTipCap: big.NewInt(2),
+				Gas: 60_000, To: &to, Data: []byte{0xa9, 0x05, 0x9c, 0xbb},
+			})
+			tx, err := auth.Signer(from, unsigned)
+			if tc.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tc.wantErr) {
+					t.Fatalf("signing returned %v, want an error containing %q", err, tc.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if tx.Nonce() != 3 || *tx.To() != to || tx.ChainId().Cmp(chainID) != 0 {
+				t.Errorf("signed nonce %d to %s on chain %s", tx.Nonce(), tx.To().Hex(), tx.ChainId())
+			}
+		})
+	}
+}
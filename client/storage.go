@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+// filmsSlot is the storage slot of the films mapping. EmeraldToken inherits
+// Ownable (_owner at 0) and then ERC20 (_balances, _allowances, _totalSupply,
+// _name and _symbol at 1 to 5), so its own state starts at slot 6.
+const filmsSlot = 6
+
+// storageReader is the part of a node client that reads contract storage.
+type storageReader interface {
+	StorageAt(ctx context.Context, account common.Address, key common.Hash, blockNumber *big.Int) ([]byte, error)
+}
+
+// storedFilm is a Film struct as read from contract storage.
+type storedFilm struct {
+	Title string
+	Year  *big.Int
+	Genre uint8
+}
+
+// filmSlot is the first slot of the Film stored under title: Solidity places
+// the value of a mapping with a string key at keccak256(key . slot), the key
+// unpadded and the slot as a 32-byte word.
+func filmSlot(title string) common.Hash {
+	return crypto.Keccak256Hash([]byte(title), common.BigToHash(big.NewInt(filmsSlot)).Bytes())
+}
+
+// slotAdd returns the slot n places after slot.
+func slotAdd(slot common.Hash, n int64) common.Hash {
+	return common.BigToHash(new(big.Int).Add(slot.Big(), big.NewInt(n)))
+}
+
+// filmStorage reads films straight from the contract storage, which is
+// authoritative where the event-derived catalog is reconstructed.
+type filmStorage struct {
+	reader  storageReader
+	address common.Address
+}
+
+func (fs *filmStorage) word(ctx context.Context, slot common.Hash, block *big.Int) (common.Hash, error) {
+	data, err := fs.reader.StorageAt(ctx, fs.address, slot, block)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	return common.BytesToHash(data), nil
+}
+
+// film returns the film stored under title at block, or the latest block when
+// block is nil, and nil when there is none. The struct takes three slots:
+// title, then year, then genre; year fills a whole word so genre is not
+// packed with it and sits alone in the low-order byte of the third. A film
+// with an empty title, year 0 and the first genre cannot be told apart from
+// an empty entry.
+func (fs *filmStorage) film(ctx context.Context, title string, block *big.Int) (*storedFilm, error) {
+	base := filmSlot(title)
+	stored, err := fs.string(ctx, base, block)
+	if err != nil {
+		return nil, err
+	}
+	year, err := fs.word(ctx, slotAdd(base, 1), block)
+	if err != nil {
+		return nil, err
+	}
+	genre, err := fs.word(ctx, slotAdd(base, 2), block)
+	if err != nil {
+		return nil, err
+	}
+	f := &storedFilm{Title: stored, Year: year.Big(), Genre: genre[common.HashLength-1]}
+	if f.Title == "" && f.Year.Sign() == 0 && genre == (common.Hash{}) {
+		return nil, nil
+	}
+	return f, nil
+}
+
+// string decodes the string stored at slot. A string of up to 31 bytes sits
+// in the high-order bytes of the slot with twice its length in the lowest
+// byte; a longer one keeps twice its length plus one in the slot and its
+// bytes in the slots from keccak256(slot) on.
+func (fs *filmStorage) string(ctx context.Context, slot common.Hash, block *big.Int) (string, error) {
+	head, err := fs.word(ctx, slot, block)
+	if err != nil {
+		return "", err
+	}
+	if head[common.HashLength-1]&1 == 0 {
+		n := int(head[common.HashLength-1] / 2)
+		if n >= common.HashLength {
+			return "", fmt.Errorf("invalid short string encoding %s at slot %s", head.Hex(), slot.Hex())
+		}
+		return string(head[:n]), nil
+	}
+
+	length := new(big.Int).Rsh(head.Big(), 1)
+	if !length.IsInt64() || length.Int64() > maxStoredString {
+		return "", fmt.Errorf("string of %s bytes at slot %s is too long to read", length, slot.Hex())
+	}
+	n := int(length.Int64())
+	data := make([]byte, 0, n+common.HashLength)
+	start := crypto.Keccak256Hash(slot.Bytes())
+	for i := int64(0); len(data) < n; i++ {
+		word, err := fs.word(ctx, slotAdd(start, i), block)
+		if err != nil {
+			return "", err
+		}
+		data = append(data, word.Bytes()...)
+	}
+	return string(data[:n]), nil
+}
+
+// maxStoredString bounds the long strings read from storage, one request per
+// 32 bytes.
+const maxStoredString = 1 << 16
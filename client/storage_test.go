@@ -0,0 +1,126 @@
+package main
+
+import (
+	"context"
+	"math/big"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+)
+
+func TestFilmSlot(t *testing.T) {
+	for title, want := range map[string]string{
+		// keccak256(uint256(6)), where the data of a dynamic array at slot 6 would start.
+		"":               "0xf652222313e28459528d920b65115c16c04f3efc82aaedc97be59f3f377c0d3f",
+		"Alien":          "0x6c35fc131a973da5c45652194d5bced1bd3a2e74b7e91cde8cc14e111e9522bc",
+		"The Matrix":     "0x05ddd792bf4f69f10a0ff423681a210b6b655d3720af1d838bd2acc4428a3c18",
+		"Звёздные войны": "0x5d53b54189bd30e0c164c5f1f635791ca77016f66d849238cf7f2b9c207d27b1",
+	} {
+		if got := filmSlot(title).Hex(); got != want {
+			t.Errorf("filmSlot(%q) = %s, want %s", title, got, want)
+		}
+	}
+}
+
+func TestSlotAdd(t *testing.T) {
+	for _, tc := range []struct {
+		slot string
+		n    int64
+		want string
+	}{
+		{"0x00", 0, "0x00"},
+		{"0x06", 1, "0x07"},
+		{"0xff", 1, "0x0100"},
+		{"0xf652222313e28459528d920b65115c16c04f3efc82aaedc97be59f3f377c0d3f", 2, "0xf652222313e28459528d920b65115c16c04f3efc82aaedc97be59f3f377c0d41"},
+		{"0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff", 1, "0x00"},
+	} {
+		got := slotAdd(common.HexToHash(tc.slot), tc.n)
+		if want := common.HexToHash(tc.want); got != want {
+			t.Errorf("%s + %d = %s, want %s", tc.slot, tc.n, got.Hex(), want.Hex())
+		}
+	}
+}
+
+// fakeStorage is a storageReader over a map of slots; missing slots are zero.
+type fakeStorage map[common.Hash]common.Hash
+
+func (s fakeStorage) StorageAt(_ context.Context, _ common.Address, key common.Hash, _ *big.Int) ([]byte, error) {
+	word := s[key]
+	return word.Bytes(), nil
+}
+
+// storeString lays out value at slot the way Solidity stores a string.
+func (s fakeStorage) storeString(slot common.Hash, value string) {
+	if len(value) < common.HashLength {
+		var head common.Hash
+		copy(head[:], value)
+		head[common.HashLength-1] = byte(2 * len(value))
+		s[slot] = head
+		return
+	}
+	s[slot] = common.BigToHash(big.NewInt(int64(2*len(value) + 1)))
+	start := crypto.Keccak256Hash(slot.Bytes())
+	for i := 0; i*common.HashLength < len(value); i++ {
+		var word common.Hash
+		copy(word[:], value[i*common.HashLength:])
+		s[slotAdd(start, int64(i))] = word
+	}
+}
+
+func TestFilmStorageString(t *testing.T) {
+	for _, n := range []int{0, 1, 31, 32, 33, 64, 100, 1000} {
+		title := strings.Repeat("F", n)
+		storage := fakeStorage{}
+		storage.storeString(filmSlot(title), title)
+		fs := &filmStorage{reader: storage}
+		got, err := fs.string(context.Background(), filmSlot(title), nil)
+		if err != nil {
+			t.Fatalf("%d bytes: %v", n, err)
+		}
+		if got != title {
+			t.Errorf("%d bytes: read %d bytes", n, len(got))
+		}
+	}
+}
+
+func TestFilmStorageInvalidString(t *testing.T) {
+	slot := filmSlot("Alien")
+	for name, head := range map[string]common.Hash{
+		"short string of 32 bytes": common.BigToHash(big.NewInt(64)),
+		"too long":                 common.BigToHash(big.NewInt(2*(maxStoredString+1) + 1)),
+		"length overflows":         common.HexToHash("0xffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffffff"),
+	} {
+		fs := &filmStorage{reader: fakeStorage{slot: head}}
+		if got, err := fs.string(context.Background(), slot, nil); err == nil {
+			t.Errorf("%s: read %q, want an error", name, got)
+		}
+	}
+}
+
+func TestFilmStorageFilm(t *testing.T) {
+	storage := fakeStorage{}
+	title := "Alien"
+	base := filmSlot(title)
+	storage.storeString(base, title)
+	storage[slotAdd(base, 1)] = common.BigToHash(big.NewInt(1979))
+	storage[slotAdd(base, 2)] = common.BigToHash(big.NewInt(3))
+	fs := &filmStorage{reader: storage}
+
+	film, err := fs.film(context.Background(), title, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if film == nil || film.Title != title || film.Year.Int64() != 1979 || film.Genre != 3 {
+		t.Errorf("film %+v, want Alien from 1979 of genre 3", film)
+	}
+
+	missing, err := fs.film(context.Background(), "Aliens", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if missing != nil {
+		t.Errorf("film %+v for a title never added", missing)
+	}
+}
@@ -0,0 +1,543 @@
+package main
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	bolt "go.etcd.io/bbolt"
+)
+
+var (
+	boltMetaBucket        = []byte("meta")
+	boltCheckpointsBucket = []byte("checkpoints")
+	boltBlocksBucket      = []byte("blocks")
+	boltFilmEventsBucket  = []byte("film-events")
+	boltFilmsBucket       = []byte("films")
+	boltTransfersBucket   = []byte("transfers")
+	boltApprovalsBucket   = []byte("approvals")
+	boltBalancesBucket    = []byte("balances")
+	boltAllowancesBucket  = []byte("allowances")
+)
+
+var boltBuckets = [][]byte{
+	boltMetaBucket,
+	boltCheckpointsBucket,
+	boltBlocksBucket,
+	boltFilmEventsBucket,
+	boltFilmsBucket,
+	boltTransfersBucket,
+	boltApprovalsBucket,
+	boltBalancesBucket,
+	boltAllowancesBucket,
+}
+
+// boltStore keeps the index in a BoltDB file. Events are JSON values keyed by
+// their chain position, so cursors walk them in chain order. Balances and
+// allowances are keyed by account, or owner and spender, followed by the
+// block, and hold the big-endian value.
+type boltStore struct {
+	db *bolt.DB
+}
+
+func openBoltStore(path string, readOnly bool) (*boltStore, error) {
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, missingIndex(path, err)
+		}
+	}
+	db, err := bolt.Open(path, 0o600, &bolt.Options{Timeout: time.Second, ReadOnly: readOnly})
+	if err != nil {
+		if errors.Is(err, bolt.ErrTimeout) {
+			return nil, fmt.Errorf("index %s is locked by another process", path)
+		}
+		return nil, err
+	}
+	if !readOnly {
+		err = db.Update(func(tx *bolt.Tx) error {
+			for _, name := range boltBuckets {
+				if _, err := tx.CreateBucketIfNotExists(name); err != nil {
+					return err
+				}
+			}
+			return nil
+		})
+		if err != nil {
+			db.Close()
+			return nil, err
+		}
+	}
+	return &boltStore{db: db}, nil
+}
+
+func (s *boltStore) update(fn func(storeTx) error) error {
+	return s.db.Update(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+}
+
+func (s *boltStore) view(fn func(storeTx) error) error {
+	return s.db.View(func(tx *bolt.Tx) error { return fn(boltTx{tx}) })
+}
+
+func (s *boltStore) Close() error {
+	return s.db.Close()
+}
+
+type boltTx struct {
+	tx *bolt.Tx
+}
+
+// errNoBucket is returned when writing to an index created by an older
+// version; reopening it for writing adds the bucket.
+var errNoBucket = errors.New("index is missing a bucket")
+
+func (t boltTx) bucket(name []byte) (*bolt.Bucket, error) {
+	b := t.tx.Bucket(name)
+	if b == nil {
+		return nil, fmt.Errorf("%w: %s", errNoBucket, name)
+	}
+	return b, nil
+}
+
+func blockKey(number uint64) []byte {
+	return binary.BigEndian.AppendUint64(nil, number)
+}
+
+// eventKey orders the event buckets by chain position.
+func eventKey(block uint64, index uint) []byte {
+	return binary.BigEndian.AppendUint64(blockKey(block), uint64(index))
+}
+
+// filmKey is the key of a title in the films bucket. Titles are hashed since
+// the contract accepts any string, including the empty one bolt cannot store.
+func filmKey(title string) []byte {
+	return crypto.Keccak256([]byte(title))
+}
+
+func (t boltTx) meta(key string) (string, bool, error) {
+	b := t.tx.Bucket(boltMetaBucket)
+	if b == nil {
+		return "", false, nil
+	}
+	value := b.Get([]byte(key))
+	return string(value), value != nil, nil
+}
+
+func (t boltTx) setMeta(key, value string) error {
+	b, err := t.bucket(boltMetaBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(key), []byte(value))
+}
+
+func (t boltTx) checkpoint(name string) (uint64, bool, error) {
+	b := t.tx.Bucket(boltCheckpointsBucket)
+	if b == nil {
+		return 0, false, nil
+	}
+	data := b.Get([]byte(name))
+	if data == nil {
+		return 0, false, nil
+	}
+	return binary.BigEndian.Uint64(data), true, nil
+}
+
+func (t boltTx) setCheckpoint(name string, next uint64) error {
+	b, err := t.bucket(boltCheckpointsBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put([]byte(name), blockKey(next))
+}
+
+func (t boltTx) consumerBlocks(name string) *bolt.Bucket {
+	blocks := t.tx.Bucket(boltBlocksBucket)
+	if blocks == nil {
+		return nil
+	}
+	return blocks.Bucket([]byte(name))
+}
+
+func (t boltTx) blockHash(name string, number uint64) (common.Hash, bool, error) {
+	b := t.consumerBlocks(name)
+	if b == nil {
+		return common.Hash{}, false, nil
+	}
+	data := b.Get(blockKey(number))
+	return common.BytesToHash(data), data != nil, nil
+}
+
+func (t boltTx) blockHashes(name string) ([]blockRef, error) {
+	b := t.consumerBlocks(name)
+	if b == nil {
+		return nil, nil
+	}
+	var refs []blockRef
+	err := b.ForEach(func(k, v []byte) error {
+		refs = append(refs, blockRef{number: binary.BigEndian.Uint64(k), hash: common.BytesToHash(v)})
+		return nil
+	})
+	return refs, err
+}
+
+func (t boltTx) putBlockHash(name string, number uint64, hash common.Hash) error {
+	blocks, err := t.bucket(boltBlocksBucket)
+	if err != nil {
+		return err
+	}
+	b, err := blocks.CreateBucketIfNotExists([]byte(name))
+	if err != nil {
+		return err
+	}
+	return b.Put(blockKey(number), hash.Bytes())
+}
+
+func (t boltTx) deleteBlockHashes(name string, from, to uint64) error {
+	b := t.consumerBlocks(name)
+	if b == nil {
+		return nil
+	}
+	var stale [][]byte
+	c := b.Cursor()
+	for k, _ := c.Seek(blockKey(from)); k != nil && binary.BigEndian.Uint64(k) <= to; k, _ = c.Next() {
+		stale = append(stale, k)
+	}
+	return deleteKeys(b, stale)
+}
+
+// deleteKeys removes keys from b. Deleting while a cursor walks the bucket
+// skips entries, so callers collect the keys first.
+func deleteKeys(b *bolt.Bucket, keys [][]byte) error {
+	for _, k := range keys {
+		if err := b.Delete(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (t boltTx) putEvent(bucket []byte, ref logRef, v any) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return b.Put(eventKey(ref.Block, ref.LogIndex), data)
+}
+
+// eventRange decodes the events of bucket in the blocks from..to with
+// decode, which unmarshals one value.
+func (t boltTx) eventRange(bucket []byte, from, to uint64, decode func([]byte) error) error {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil
+	}
+	c := b.Cursor()
+	for k, v := c.Seek(blockKey(from)); k != nil && binary.BigEndian.Uint64(k) <= to; k, v = c.Next() {
+		if err := decode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// deleteEvents removes the events of bucket from block from on, passing each
+// to visit first when it is not nil.
+func (t boltTx) deleteEvents(bucket []byte, from uint64, visit func([]byte) error) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	var stale [][]byte
+	c := b.Cursor()
+	for k, v := c.Seek(blockKey(from)); k != nil; k, v = c.Next() {
+		if visit != nil {
+			if err := visit(v); err != nil {
+				return err
+			}
+		}
+		stale = append(stale, k)
+	}
+	return deleteKeys(b, stale)
+}
+
+func (t boltTx) putFilmEvent(r *filmRecord) error {
+	return t.putEvent(boltFilmEventsBucket, r.logRef, r)
+}
+
+func (t boltTx) filmEvents(from, to uint64) ([]*filmRecord, error) {
+	var records []*filmRecord
+	err := t.eventRange(boltFilmEventsBucket, from, to, func(data []byte) error {
+		r := new(filmRecord)
+		records = append(records, r)
+		return json.Unmarshal(data, r)
+	})
+	return records, err
+}
+
+func (t boltTx) deleteFilmEvents(from uint64) ([]*filmRecord, error) {
+	var records []*filmRecord
+	err := t.deleteEvents(boltFilmEventsBucket, from, func(data []byte) error {
+		r := new(filmRecord)
+		records = append(records, r)
+		return json.Unmarshal(data, r)
+	})
+	return records, err
+}
+
+func (t boltTx) lastFilmEvent(title string) (*filmRecord, error) {
+	b := t.tx.Bucket(boltFilmEventsBucket)
+	if b == nil {
+		return nil, nil
+	}
+	c := b.Cursor()
+	for k, v := c.Last(); k != nil; k, v = c.Prev() {
+		r := new(filmRecord)
+		if err := json.Unmarshal(v, r); err != nil {
+			return nil, err
+		}
+		if r.Title == title {
+			return r, nil
+		}
+	}
+	return nil, nil
+}
+
+func (t boltTx) film(title string) (*filmRecord, error) {
+	b := t.tx.Bucket(boltFilmsBucket)
+	if b == nil {
+		return nil, nil
+	}
+	data := b.Get(filmKey(title))
+	if data == nil {
+		return nil, nil
+	}
+	r := new(filmRecord)
+	return r, json.Unmarshal(data, r)
+}
+
+func (t boltTx) putFilm(r *filmRecord) error {
+	b, err := t.bucket(boltFilmsBucket)
+	if err != nil {
+		return err
+	}
+	data, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	return b.Put(filmKey(r.Title), data)
+}
+
+func (t boltTx) deleteFilm(title string) error {
+	b, err := t.bucket(boltFilmsBucket)
+	if err != nil {
+		return err
+	}
+	return b.Delete(filmKey(title))
+}
+
+func (t boltTx) films() ([]*filmRecord, error) {
+	b := t.tx.Bucket(boltFilmsBucket)
+	if b == nil {
+		return nil, nil
+	}
+	var records []*filmRecord
+	err := b.ForEach(func(_, data []byte) error {
+		r := new(filmRecord)
+		records = append(records, r)
+		return json.Unmarshal(data, r)
+	})
+	return records, err
+}
+
+func (t boltTx) putTransfer(r *transferRecord) error {
+	return t.putEvent(boltTransfersBucket, r.logRef, r)
+}
+
+func (t boltTx) transfers(from, to uint64) ([]*transferRecord, error) {
+	var records []*transferRecord
+	err := t.eventRange(boltTransfersBucket, from, to, func(data []byte) error {
+		r := new(transferRecord)
+		records = append(records, r)
+		return json.Unmarshal(data, r)
+	})
+	return records, err
+}
+
+func (t boltTx) putApproval(r *approvalRecord) error {
+	return t.putEvent(boltApprovalsBucket, r.logRef, r)
+}
+
+func (t boltTx) approvals(from, to uint64) ([]*approvalRecord, error) {
+	var records []*approvalRecord
+	err := t.eventRange(boltApprovalsBucket, from, to, func(data []byte) error {
+		r := new(approvalRecord)
+		records = append(records, r)
+		return json.Unmarshal(data, r)
+	})
+	return records, err
+}
+
+// pointKey is the key of a balance or allowance: the accounts it belongs to
+// followed by the block.
+func pointKey(block uint64, accounts ...common.Address) []byte {
+	var key []byte
+	for _, a := range accounts {
+		key = append(key, a.Bytes()...)
+	}
+	return binary.BigEndian.AppendUint64(key, block)
+}
+
+// pointAt returns the key and value of the latest point under prefix at or
+// before block, or nil.
+func (t boltTx) pointAt(bucket []byte, prefix []byte, block uint64) ([]byte, []byte) {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return nil, nil
+	}
+	c := b.Cursor()
+	seek := binary.BigEndian.AppendUint64(append([]byte(nil), prefix...), block)
+	k, v := c.Seek(seek)
+	switch {
+	case k == nil:
+		k, v = c.Last()
+	case !bytes.Equal(k, seek):
+		k, v = c.Prev()
+	}
+	if k == nil || !bytes.HasPrefix(k, prefix) {
+		return nil, nil
+	}
+	return k, v
+}
+
+// pointsAt calls visit with the latest point of every account, or owner and
+// spender, at or before block. Keys are prefix bytes of accounts followed by
+// the block.
+func (t boltTx) pointsAt(bucket []byte, prefix int, block uint64, visit func(k, v []byte)) {
+	b := t.tx.Bucket(bucket)
+	if b == nil {
+		return
+	}
+	var lastK, lastV []byte
+	b.ForEach(func(k, v []byte) error {
+		if lastK != nil && !bytes.Equal(k[:prefix], lastK[:prefix]) {
+			visit(lastK, lastV)
+			lastK = nil
+		}
+		if binary.BigEndian.Uint64(k[prefix:]) <= block {
+			lastK, lastV = k, v
+		}
+		return nil
+	})
+	if lastK != nil {
+		visit(lastK, lastV)
+	}
+}
+
+// deletePoints removes the points of block from and later ones.
+func (t boltTx) deletePoints(bucket []byte, prefix int, from uint64) error {
+	b, err := t.bucket(bucket)
+	if err != nil {
+		return err
+	}
+	var stale [][]byte
+	err = b.ForEach(func(k, _ []byte) error {
+		if binary.BigEndian.Uint64(k[prefix:]) >= from {
+			stale = append(stale, k)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	return deleteKeys(b, stale)
+}
+
+func (t boltTx) putBalance(p *balancePoint) error {
+	b, err := t.bucket(boltBalancesBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(pointKey(p.Block, p.Account), p.Value.Bytes())
+}
+
+func balanceFromKey(k, v []byte) *balancePoint {
+	return &balancePoint{
+		Account: common.BytesToAddress(k[:common.AddressLength]),
+		Block:   binary.BigEndian.Uint64(k[common.AddressLength:]),
+		Value:   new(big.Int).SetBytes(v),
+	}
+}
+
+func (t boltTx) balance(account common.Address, block uint64) (*balancePoint, error) {
+	k, v := t.pointAt(boltBalancesBucket, account.Bytes(), block)
+	if k == nil {
+		return nil, nil
+	}
+	return balanceFromKey(k, v), nil
+}
+
+func (t boltTx) balances(block uint64) ([]*balancePoint, error) {
+	var points []*balancePoint
+	t.pointsAt(boltBalancesBucket, common.AddressLength, block, func(k, v []byte) {
+		points = append(points, balanceFromKey(k, v))
+	})
+	return points, nil
+}
+
+func (t boltTx) putAllowance(p *allowancePoint) error {
+	b, err := t.bucket(boltAllowancesBucket)
+	if err != nil {
+		return err
+	}
+	return b.Put(pointKey(p.Block, p.Owner, p.Spender), p.Value.Bytes())
+}
+
+func allowanceFromKey(k, v []byte) *allowancePoint {
+	return &allowancePoint{
+		Owner:   common.BytesToAddress(k[:common.AddressLength]),
+		Spender: common.BytesToAddress(k[common.AddressLength : 2*common.AddressLength]),
+		Block:   binary.BigEndian.Uint64(k[2*common.AddressLength:]),
+		Value:   new(big.Int).SetBytes(v),
+	}
+}
+
+func (t boltTx) allowance(owner, spender common.Address, block uint64) (*allowancePoint, error) {
+	prefix := append(owner.Bytes(), spender.Bytes()...)
+	k, v := t.pointAt(boltAllowancesBucket, prefix, block)
+	if k == nil {
+		return nil, nil
+	}
+	return allowanceFromKey(k, v), nil
+}
+
+func (t boltTx) allowances(block uint64) ([]*allowancePoint, error) {
+	var points []*allowancePoint
+	t.pointsAt(boltAllowancesBucket, 2*common.AddressLength, block, func(k, v []byte) {
+		points = append(points, allowanceFromKey(k, v))
+	})
+	return points, nil
+}
+
+func (t boltTx) deleteLedger(from uint64) error {
+	if err := t.deleteEvents(boltTransfersBucket, from, nil); err != nil {
+		return err
+	}
+	if err := t.deleteEvents(boltApprovalsBucket, from, nil); err != nil {
+		return err
+	}
+	if err := t.deletePoints(boltBalancesBucket, common.AddressLength, from); err != nil {
+		return err
+	}
+	return t.deletePoints(boltAllowancesBucket, 2*common.AddressLength, from)
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// memStore keeps the index in memory. Each update works on a copy that
+// replaces the data only when it succeeds, which is simple rather than fast:
+// the store is meant for tests and short-lived tools.
+type memStore struct {
+	mu   sync.RWMutex
+	data *memData
+}
+
+type memData struct {
+	values      map[string]string
+	nexts       map[string]uint64
+	hashes      map[string]map[uint64]common.Hash
+	filmLog     []*filmRecord // in chain order
+	catalog     map[string]*filmRecord
+	transferLog []*transferRecord                       // in chain order
+	approvalLog []*approvalRecord                       // in chain order
+	balanceLog  map[common.Address][]*balancePoint      // by block
+	allowLog    map[[2]common.Address][]*allowancePoint // by block
+}
+
+func newMemStore() *memStore {
+	return &memStore{data: &memData{
+		values:     make(map[string]string),
+		nexts:      make(map[string]uint64),
+		hashes:     make(map[string]map[uint64]common.Hash),
+		catalog:    make(map[string]*filmRecord),
+		balanceLog: make(map[common.Address][]*balancePoint),
+		allowLog:   make(map[[2]common.Address][]*allowancePoint),
+	}}
+}
+
+func (s *memStore) update(fn func(storeTx) error) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	work := s.data.clone()
+	if err := fn(work); err != nil {
+		return err
+	}
+	s.data = work
+	return nil
+}
+
+func (s *memStore) view(fn func(storeTx) error) error {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return fn(s.data)
+}
+
+func (s *memStore) Close() error {
+	return nil
+}
+
+// clone copies the containers; records are never modified once stored, so
+// they are shared.
+func (d *memData) clone() *memData {
+	c := &memData{
+		values:      make(map[string]string, len(d.values)),
+		nexts:       make(map[string]uint64, len(d.nexts)),
+		hashes:      make(map[string]map[uint64]common.Hash, len(d.hashes)),
+		filmLog:     append([]*filmRecord(nil), d.filmLog...),
+		catalog:     make(map[string]*filmRecord, len(d.catalog)),
+		transferLog: append([]*transferRecord(nil), d.transferLog...),
+		approvalLog: append([]*approvalRecord(nil), d.approvalLog...),
+		balanceLog:  make(map[common.Address][]*balancePoint, len(d.balanceLog)),
+		allowLog:    make(map[[2]common.Address][]*allowancePoint, len(d.allowLog)),
+	}
+	for k, v := range d.values {
+		c.values[k] = v
+	}
+	for k, v := range d.nexts {
+		c.nexts[k] = v
+	}
+	for name, hashes := range d.hashes {
+		copied := make(map[uint64]common.Hash, len(hashes))
+		for n, h := range hashes {
+			copied[n] = h
+		}
+		c.hashes[name] = copied
+	}
+	for k, v := range d.catalog {
+		c.catalog[k] = v
+	}
+	for k, v := range d.balanceLog {
+		c.balanceLog[k] = append([]*balancePoint(nil), v...)
+	}
+	for k, v := range d.allowLog {
+		c.allowLog[k] = append([]*allowancePoint(nil), v...)
+	}
+	return c
+}
+
+func (d *memData) meta(key string) (string, bool, error) {
+	value, ok := d.values[key]
+	return value, ok, nil
+}
+
+func (d *memData) setMeta(key, value string) error {
+	d.values[key] = value
+	return nil
+}
+
+func (d *memData) checkpoint(name string) (uint64, bool, error) {
+	next, ok := d.nexts[name]
+	return next, ok, nil
+}
+
+func (d *memData) setCheckpoint(name string, next uint64) error {
+	d.nexts[name] = next
+	return nil
+}
+
+func (d *memData) blockHash(name string, number uint64) (common.Hash, bool, error) {
+	hash, ok := d.hashes[name][number]
+	return hash, ok, nil
+}
+
+func (d *memData) blockHashes(name string) ([]blockRef, error) {
+	var refs []blockRef
+	for n, h := range d.hashes[name] {
+		refs = append(refs, blockRef{number: n, hash: h})
+	}
+	sort.Slice(refs, func(i, j int) bool { return refs[i].number < refs[j].number })
+	return refs, nil
+}
+
+func (d *memData) putBlockHash(name string, number uint64, hash common.Hash) error {
+	if d.hashes[name] == nil {
+		d.hashes[name] = make(map[uint64]common.Hash)
+	}
+	d.hashes[name][number] = hash
+	return nil
+}
+
+func (d *memData) deleteBlockHashes(name string, from, to uint64) error {
+	for n := range d.hashes[name] {
+		if n >= from && n <= to {
+			delete(d.hashes[name], n)
+		}
+	}
+	return nil
+}
+
+// refBefore orders log positions the way the chain does.
+func refBefore(a, b logRef) bool {
+	if a.Block != b.Block {
+		return a.Block < b.Block
+	}
+	return a.LogIndex < b.LogIndex
+}
+
+// putOrdered inserts r into the chain-ordered events, replacing an event at the
+// same position.
+func putOrdered[T any](events []T, r T, ref func(T) logRef) []T {
+	i := sort.Search(len(events), func(i int) bool { return !refBefore(ref(events[i]), ref(r)) })
+	if i < len(events) && ref(events[i]) == ref(r) {
+		events[i] = r
+		return events
+	}
+	events = append(events, r)
+	copy(events[i+1:], events[i:])
+	events[i] = r
+	return events
+}
+
+// orderedRange returns the events of the blocks from..to.
+func orderedRange[T any](events []T, from, to uint64, ref func(T) logRef) []T {
+	lo := sort.Search(len(events), func(i int) bool { return ref(events[i]).Block >= from })
+	hi := sort.Search(len(events), func(i int) bool { return ref(events[i]).Block > to })
+	if lo >= hi {
+		return nil
+	}
+	return append([]T(nil), events[lo:hi]...)
+}
+
+// firstFrom returns the index of the first event of block from or later.
+func firstFrom[T any](events []T, from uint64, ref func(T) logRef) int {
+	return sort.Search(len(events), func(i int) bool { return ref(events[i]).Block >= from })
+}
+
+func filmRef(r *filmRecord) logRef         { return r.logRef }
+func transferRef(r *transferRecord) logRef { return r.logRef }
+func approvalRef(r *approvalRecord) logRef { return r.logRef }
+
+func (d *memData) putFilmEvent(r *filmRecord) error {
+	copied := *r
+	d.filmLog = putOrdered(d.filmLog, &copied, filmRef)
+	return nil
+}
+
+func (d *memData) filmEvents(from, to uint64) ([]*filmRecord, error) {
+	return orderedRange(d.filmLog, from, to, filmRef), nil
+}
+
+func (d *memData) deleteFilmEvents(from uint64) ([]*filmRecord, error) {
+	i := firstFrom(d.filmLog, from, filmRef)
+	deleted := append([]*filmRecord(nil), d.filmLog[i:]...)
+	d.filmLog = d.filmLog[:i]
+	return deleted, nil
+}
+
+func (d *memData) lastFilmEvent(title string) (*filmRecord, error) {
+	for i := len(d.filmLog) - 1; i >= 0; i-- {
+		if d.filmLog[i].Title == title {
+			return d.filmLog[i], nil
+		}
+	}
+	return nil, nil
+}
+
+func (d *memData) film(title string) (*filmRecord, error) {
+	return d.catalog[title], nil
+}
+
+func (d *memData) putFilm(r *filmRecord) error {
+	copied := *r
+	d.catalog[r.Title] = &copied
+	return nil
+}
+
+func (d *memData) deleteFilm(title string) error {
+	delete(d.catalog, title)
+	return nil
+}
+
+func (d *memData) films() ([]*filmRecord, error) {
+	records := make([]*filmRecord, 0, len(d.catalog))
+	for _, r := range d.catalog {
+		records = append(records, r)
+	}
+	return records, nil
+}
+
+func (d *memData) putTransfer(r *transferRecord) error {
+	copied := *r
+	d.transferLog = putOrdered(d.transferLog, &copied, transferRef)
+	return nil
+}
+
+func (d *memData) transfers(from, to uint64) ([]*transferRecord, error) {
+	return orderedRange(d.transferLog, from, to, transferRef), nil
+}
+
+func (d *memData) putApproval(r *approvalRecord) error {
+	copied := *r
+	d.approvalLog = putOrdered(d.approvalLog, &copied, approvalRef)
+	return nil
+}
+
+func (d *memData) approvals(from, to uint64) ([]*approvalRecord, error) {
+	return orderedRange(d.approvalLog, from, to, approvalRef), nil
+}
+
+// putPoint inserts p into the points of one account or allowance, ordered by
+// block, replacing the point of the same block.
+func putPoint[T any](points []T, p T, block func(T) uint64) []T {
+	i := sort.Search(len(points), func(i int) bool { return block(points[i]) >= block(p) })
+	if i < len(points) && block(points[i]) == block(p) {
+		points[i] = p
+		return points
+	}
+	points = append(points, p)
+	copy(points[i+1:], points[i:])
+	points[i] = p
+	return points
+}
+
+// pointAt returns the latest of points at or before block.
+func pointAt[T any](points []T, block uint64, blockOf func(T) uint64) (T, bool) {
+	i := sort.Search(len(points), func(i int) bool { return blockOf(points[i]) > block })
+	if i == 0 {
+		var zero T
+		return zero, false
+	}
+	return points[i-1], true
+}
+
+// truncatePoints drops the points of block from and later ones.
+func truncatePoints[T any](points []T, from uint64, block func(T) uint64) []T {
+	return points[:sort.Search(len(points), func(i int) bool { return block(points[i]) >= from })]
+}
+
+func balanceBlock(p *balancePoint) uint64     { return p.Block }
+func allowanceBlock(p *allowancePoint) uint64 { return p.Block }
+
+func (d *memData) putBalance(p *balancePoint) error {
+	copied := *p
+	d.balanceLog[p.Account] = putPoint(d.balanceLog[p.Account], &copied, balanceBlock)
+	return nil
+}
+
+func (d *memData) balance(account common.Address, block uint64) (*balancePoint, error) {
+	p, _ := pointAt(d.balanceLog[account], block, balanceBlock)
+	return p, nil
+}
+
+func (d *memData) balances(block uint64) ([]*balancePoint, error) {
+	var points []*balancePoint
+	for _, log := range d.balanceLog {
+		if p, ok := pointAt(log, block, balanceBlock); ok {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+func (d *memData) putAllowance(p *allowancePoint) error {
+	copied := *p
+	key := [2]common.Address{p.Owner, p.Spender}
+	d.allowLog[key] = putPoint(d.allowLog[key], &copied, allowanceBlock)
+	return nil
+}
+
+func (d *memData) allowance(owner, spender common.Address, block uint64) (*allowancePoint, error) {
+	p, _ := pointAt(d.allowLog[[2]common.Address{owner, spender}], block, allowanceBlock)
+	return p, nil
+}
+
+func (d *memData) allowances(block uint64) ([]*allowancePoint, error) {
+	var points []*allowancePoint
+	for _, log := range d.allowLog {
+		if p, ok := pointAt(log, block, allowanceBlock); ok {
+			points = append(points, p)
+		}
+	}
+	return points, nil
+}
+
+func (d *memData) deleteLedger(from uint64) error {
+	d.transferLog = d.transferLog[:firstFrom(d.transferLog, from, transferRef)]
+	d.approvalLog = d.approvalLog[:firstFrom(d.approvalLog, from, approvalRef)]
+	for account, log := range d.balanceLog {
+		if log = truncatePoints(log, from, balanceBlock); len(log) == 0 {
+			delete(d.balanceLog, account)
+		} else {
+			d.balanceLog[account] = log
+		}
+	}
+	for key, log := range d.allowLog {
+		if log = truncatePoints(log, from, allowanceBlock); len(log) == 0 {
+			delete(d.allowLog, key)
+		} else {
+			d.allowLog[key] = log
+		}
+	}
+	return nil
+}
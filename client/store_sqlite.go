@@ -0,0 +1,570 @@
+package main
+
+import (
+	"database/sql"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+
+	"github.com/ethereum/go-ethereum/common"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+// sqliteSchema creates the tables of a SQLite index. Block numbers fit the
+// signed 64-bit INTEGER; token amounts and years are uint256 and are kept as
+// decimal TEXT.
+const sqliteSchema = `
+CREATE TABLE IF NOT EXISTS meta (
+	key   TEXT PRIMARY KEY,
+	value TEXT NOT NULL
+);
+CREATE TABLE IF NOT EXISTS checkpoints (
+	name TEXT PRIMARY KEY,
+	next INTEGER NOT NULL
+);
+CREATE TABLE IF NOT EXISTS blocks (
+	consumer TEXT NOT NULL,
+	number   INTEGER NOT NULL,
+	hash     BLOB NOT NULL,
+	PRIMARY KEY (consumer, number)
+);
+CREATE TABLE IF NOT EXISTS film_events (
+	block      INTEGER NOT NULL,
+	log_index  INTEGER NOT NULL,
+	block_hash BLOB NOT NULL,
+	tx_hash    BLOB NOT NULL,
+	title      TEXT NOT NULL,
+	year       TEXT,
+	genre      INTEGER NOT NULL,
+	deleted    INTEGER NOT NULL,
+	overwrite  INTEGER NOT NULL DEFAULT 0,
+	PRIMARY KEY (block, log_index)
+);
+CREATE INDEX IF NOT EXISTS film_events_title ON film_events (title, block, log_index);
+CREATE TABLE IF NOT EXISTS films (
+	title      TEXT PRIMARY KEY,
+	block      INTEGER NOT NULL,
+	log_index  INTEGER NOT NULL,
+	block_hash BLOB NOT NULL,
+	tx_hash    BLOB NOT NULL,
+	year       TEXT,
+	genre      INTEGER NOT NULL,
+	deleted    INTEGER NOT NULL,
+	overwrite  INTEGER NOT NULL DEFAULT 0
+);
+CREATE TABLE IF NOT EXISTS transfers (
+	block      INTEGER NOT NULL,
+	log_index  INTEGER NOT NULL,
+	block_hash BLOB NOT NULL,
+	tx_hash    BLOB NOT NULL,
+	sender     TEXT NOT NULL,
+	recipient  TEXT NOT NULL,
+	value      TEXT NOT NULL,
+	PRIMARY KEY (block, log_index)
+);
+CREATE TABLE IF NOT EXISTS approvals (
+	block      INTEGER NOT NULL,
+	log_index  INTEGER NOT NULL,
+	block_hash BLOB NOT NULL,
+	tx_hash    BLOB NOT NULL,
+	owner      TEXT NOT NULL,
+	spender    TEXT NOT NULL,
+	value      TEXT NOT NULL,
+	PRIMARY KEY (block, log_index)
+);
+CREATE TABLE IF NOT EXISTS balances (
+	account TEXT NOT NULL,
+	block   INTEGER NOT NULL,
+	value   TEXT NOT NULL,
+	PRIMARY KEY (account, block)
+);
+CREATE TABLE IF NOT EXISTS allowances (
+	owner   TEXT NOT NULL,
+	spender TEXT NOT NULL,
+	block   INTEGER NOT NULL,
+	value   TEXT NOT NULL,
+	PRIMARY KEY (owner, spender, block)
+);
+`
+
+// sqliteColumns are the columns added to the tables since they were first
+// created; indexes created earlier get them on open.
+var sqliteColumns = []struct{ table, column, decl string }{
+	{"film_events", "overwrite", "INTEGER NOT NULL DEFAULT 0"},
+	{"films", "overwrite", "INTEGER NOT NULL DEFAULT 0"},
+}
+
+// sqliteStore keeps the index in a SQLite database. It runs in WAL mode, so
+// readers such as film list work while an indexer writes.
+type sqliteStore struct {
+	db *sql.DB
+}
+
+func openSQLiteStore(path string, readOnly bool) (*sqliteStore, error) {
+	dsn := "file:" + path + "?_busy_timeout=1000"
+	if readOnly {
+		if _, err := os.Stat(path); err != nil {
+			return nil, missingIndex(path, err)
+		}
+		dsn += "&mode=ro"
+	} else {
+		dsn += "&_journal_mode=WAL"
+	}
+	db, err := sql.Open("sqlite3", dsn)
+	if err != nil {
+		return nil, err
+	}
+	// A single connection serializes the transactions of this process.
+	db.SetMaxOpenConns(1)
+	if readOnly {
+		err = checkSQLiteColumns(db)
+	} else {
+		err = migrateSQLite(db)
+	}
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("index %s: %w", path, err)
+	}
+	return &sqliteStore{db: db}, nil
+}
+
+func hasSQLiteColumn(db *sql.DB, table, column string) (bool, error) {
+	var n int
+	err := db.QueryRow(`SELECT COUNT(*) FROM pragma_table_info(?) WHERE name = ?`, table, column).Scan(&n)
+	return n > 0, err
+}
+
+func migrateSQLite(db *sql.DB) error {
+	if _, err := db.Exec(sqliteSchema); err != nil {
+		return err
+	}
+	for _, c := range sqliteColumns {
+		ok, err := hasSQLiteColumn(db, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			if _, err := db.Exec(`ALTER TABLE ` + c.table + ` ADD COLUMN ` + c.column + ` ` + c.decl); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+var errOldSQLiteIndex = errors.New("index was created by an older version; run an indexer on it once to upgrade it")
+
+// sqliteTables are the tables of sqliteSchema.
+var sqliteTables = []string{"meta", "checkpoints", "blocks", "film_events", "films", "transfers", "approvals", "balances", "allowances"}
+
+// checkSQLiteColumns refuses an index that a read-only open cannot migrate.
+func checkSQLiteColumns(db *sql.DB) error {
+	var n int
+	if err := db.QueryRow(`SELECT COUNT(*) FROM sqlite_master WHERE type = 'table'`).Scan(&n); err != nil {
+		return err
+	}
+	if n < len(sqliteTables) {
+		return errOldSQLiteIndex
+	}
+	for _, c := range sqliteColumns {
+		ok, err := hasSQLiteColumn(db, c.table, c.column)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			return errOldSQLiteIndex
+		}
+	}
+	return nil
+}
+
+func (s *sqliteStore) update(fn func(storeTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	if err := fn(sqliteTx{tx}); err != nil {
+		tx.Rollback()
+		return err
+	}
+	return tx.Commit()
+}
+
+func (s *sqliteStore) view(fn func(storeTx) error) error {
+	tx, err := s.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+	return fn(sqliteTx{tx})
+}
+
+func (s *sqliteStore) Close() error {
+	return s.db.Close()
+}
+
+type sqliteTx struct {
+	tx *sql.Tx
+}
+
+// bigText and textBig convert uint256 values to and from their TEXT column.
+func bigText(x *big.Int) sql.NullString {
+	if x == nil {
+		return sql.NullString{}
+	}
+	return sql.NullString{String: x.String(), Valid: true}
+}
+
+func textBig(s sql.NullString) (*big.Int, error) {
+	if !s.Valid {
+		return nil, nil
+	}
+	x, ok := new(big.Int).SetString(s.String, 10)
+	if !ok {
+		return nil, fmt.Errorf("invalid number %q in index", s.String)
+	}
+	return x, nil
+}
+
+// dbRow is implemented by *sql.Row and *sql.Rows.
+type dbRow interface {
+	Scan(dest ...any) error
+}
+
+func (t sqliteTx) meta(key string) (string, bool, error) {
+	var value string
+	err := t.tx.QueryRow(`SELECT value FROM meta WHERE key = ?`, key).Scan(&value)
+	if errors.Is(err, sql.ErrNoRows) {
+		return "", false, nil
+	}
+	return value, err == nil, err
+}
+
+func (t sqliteTx) setMeta(key, value string) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO meta (key, value) VALUES (?, ?)`, key, value)
+	return err
+}
+
+func (t sqliteTx) checkpoint(name string) (uint64, bool, error) {
+	var next uint64
+	err := t.tx.QueryRow(`SELECT next FROM checkpoints WHERE name = ?`, name).Scan(&next)
+	if errors.Is(err, sql.ErrNoRows) {
+		return 0, false, nil
+	}
+	return next, err == nil, err
+}
+
+func (t sqliteTx) setCheckpoint(name string, next uint64) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO checkpoints (name, next) VALUES (?, ?)`, name, next)
+	return err
+}
+
+func (t sqliteTx) blockHash(name string, number uint64) (common.Hash, bool, error) {
+	var hash []byte
+	err := t.tx.QueryRow(`SELECT hash FROM blocks WHERE consumer = ? AND number = ?`, name, number).Scan(&hash)
+	if errors.Is(err, sql.ErrNoRows) {
+		return common.Hash{}, false, nil
+	}
+	return common.BytesToHash(hash), err == nil, err
+}
+
+func (t sqliteTx) blockHashes(name string) ([]blockRef, error) {
+	rows, err := t.tx.Query(`SELECT number, hash FROM blocks WHERE consumer = ? ORDER BY number`, name)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var refs []blockRef
+	for rows.Next() {
+		var (
+			ref  blockRef
+			hash []byte
+		)
+		if err := rows.Scan(&ref.number, &hash); err != nil {
+			return nil, err
+		}
+		ref.hash = common.BytesToHash(hash)
+		refs = append(refs, ref)
+	}
+	return refs, rows.Err()
+}
+
+func (t sqliteTx) putBlockHash(name string, number uint64, hash common.Hash) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO blocks (consumer, number, hash) VALUES (?, ?, ?)`, name, number, hash.Bytes())
+	return err
+}
+
+func (t sqliteTx) deleteBlockHashes(name string, from, to uint64) error {
+	_, err := t.tx.Exec(`DELETE FROM blocks WHERE consumer = ? AND number >= ? AND number <= ?`, name, from, clampInt64(to))
+	return err
+}
+
+// clampInt64 keeps an open-ended upper bound within SQLite's INTEGER range.
+func clampInt64(x uint64) uint64 {
+	const maxInt64 = 1<<63 - 1
+	if x > maxInt64 {
+		return maxInt64
+	}
+	return x
+}
+
+const filmColumns = `title, block, log_index, block_hash, tx_hash, year, genre, deleted, overwrite`
+
+func scanFilm(row dbRow) (*filmRecord, error) {
+	var (
+		r                 filmRecord
+		blockHash, txHash []byte
+		year              sql.NullString
+	)
+	if err := row.Scan(&r.Title, &r.Block, &r.LogIndex, &blockHash, &txHash, &year, &r.Genre, &r.Deleted, &r.Overwrite); err != nil {
+		return nil, err
+	}
+	r.BlockHash, r.TxHash = common.BytesToHash(blockHash), common.BytesToHash(txHash)
+	var err error
+	r.Year, err = textBig(year)
+	return &r, err
+}
+
+func filmArgs(r *filmRecord) []any {
+	return []any{r.Title, r.Block, r.LogIndex, r.BlockHash.Bytes(), r.TxHash.Bytes(), bigText(r.Year), r.Genre, r.Deleted, r.Overwrite}
+}
+
+func (t sqliteTx) queryFilms(query string, args ...any) ([]*filmRecord, error) {
+	rows, err := t.tx.Query(query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []*filmRecord
+	for rows.Next() {
+		r, err := scanFilm(rows)
+		if err != nil {
+			return nil, err
+		}
+		records = append(records, r)
+	}
+	return records, rows.Err()
+}
+
+func (t sqliteTx) putFilmEvent(r *filmRecord) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO film_events (`+filmColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, filmArgs(r)...)
+	return err
+}
+
+func (t sqliteTx) filmEvents(from, to uint64) ([]*filmRecord, error) {
+	return t.queryFilms(`SELECT `+filmColumns+` FROM film_events WHERE block >= ? AND block <= ? ORDER BY block, log_index`, from, clampInt64(to))
+}
+
+func (t sqliteTx) deleteFilmEvents(from uint64) ([]*filmRecord, error) {
+	records, err := t.queryFilms(`SELECT `+filmColumns+` FROM film_events WHERE block >= ? ORDER BY block, log_index`, from)
+	if err != nil {
+		return nil, err
+	}
+	_, err = t.tx.Exec(`DELETE FROM film_events WHERE block >= ?`, from)
+	return records, err
+}
+
+func (t sqliteTx) lastFilmEvent(title string) (*filmRecord, error) {
+	r, err := scanFilm(t.tx.QueryRow(`SELECT `+filmColumns+` FROM film_events WHERE title = ? ORDER BY block DESC, log_index DESC LIMIT 1`, title))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return r, err
+}
+
+func (t sqliteTx) film(title string) (*filmRecord, error) {
+	r, err := scanFilm(t.tx.QueryRow(`SELECT `+filmColumns+` FROM films WHERE title = ?`, title))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return r, err
+}
+
+func (t sqliteTx) putFilm(r *filmRecord) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO films (`+filmColumns+`) VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`, filmArgs(r)...)
+	return err
+}
+
+func (t sqliteTx) deleteFilm(title string) error {
+	_, err := t.tx.Exec(`DELETE FROM films WHERE title = ?`, title)
+	return err
+}
+
+func (t sqliteTx) films() ([]*filmRecord, error) {
+	return t.queryFilms(`SELECT ` + filmColumns + ` FROM films`)
+}
+
+func (t sqliteTx) putTransfer(r *transferRecord) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO transfers (block, log_index, block_hash, tx_hash, sender, recipient, value) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Block, r.LogIndex, r.BlockHash.Bytes(), r.TxHash.Bytes(), r.From.Hex(), r.To.Hex(), bigText(r.Value))
+	return err
+}
+
+func (t sqliteTx) transfers(from, to uint64) ([]*transferRecord, error) {
+	rows, err := t.tx.Query(`SELECT block, log_index, block_hash, tx_hash, sender, recipient, value FROM transfers
+		WHERE block >= ? AND block <= ? ORDER BY block, log_index`, from, clampInt64(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []*transferRecord
+	for rows.Next() {
+		var (
+			r                 transferRecord
+			blockHash, txHash []byte
+			sender, recipient string
+			value             sql.NullString
+		)
+		if err := rows.Scan(&r.Block, &r.LogIndex, &blockHash, &txHash, &sender, &recipient, &value); err != nil {
+			return nil, err
+		}
+		r.BlockHash, r.TxHash = common.BytesToHash(blockHash), common.BytesToHash(txHash)
+		r.From, r.To = common.HexToAddress(sender), common.HexToAddress(recipient)
+		if r.Value, err = textBig(value); err != nil {
+			return nil, err
+		}
+		records = append(records, &r)
+	}
+	return records, rows.Err()
+}
+
+func (t sqliteTx) putApproval(r *approvalRecord) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO approvals (block, log_index, block_hash, tx_hash, owner, spender, value) VALUES (?, ?, ?, ?, ?, ?, ?)`,
+		r.Block, r.LogIndex, r.BlockHash.Bytes(), r.TxHash.Bytes(), r.Owner.Hex(), r.Spender.Hex(), bigText(r.Value))
+	return err
+}
+
+func (t sqliteTx) approvals(from, to uint64) ([]*approvalRecord, error) {
+	rows, err := t.tx.Query(`SELECT block, log_index, block_hash, tx_hash, owner, spender, value FROM approvals
+		WHERE block >= ? AND block <= ? ORDER BY block, log_index`, from, clampInt64(to))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var records []*approvalRecord
+	for rows.Next() {
+		var (
+			r                 approvalRecord
+			blockHash, txHash []byte
+			owner, spender    string
+			value             sql.NullString
+		)
+		if err := rows.Scan(&r.Block, &r.LogIndex, &blockHash, &txHash, &owner, &spender, &value); err != nil {
+			return nil, err
+		}
+		r.BlockHash, r.TxHash = common.BytesToHash(blockHash), common.BytesToHash(txHash)
+		r.Owner, r.Spender = common.HexToAddress(owner), common.HexToAddress(spender)
+		if r.Value, err = textBig(value); err != nil {
+			return nil, err
+		}
+		records = append(records, &r)
+	}
+	return records, rows.Err()
+}
+
+func (t sqliteTx) putBalance(p *balancePoint) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO balances (account, block, value) VALUES (?, ?, ?)`,
+		p.Account.Hex(), p.Block, bigText(p.Value))
+	return err
+}
+
+func scanBalance(row dbRow) (*balancePoint, error) {
+	var (
+		p       balancePoint
+		account string
+		value   sql.NullString
+	)
+	if err := row.Scan(&account, &p.Block, &value); err != nil {
+		return nil, err
+	}
+	p.Account = common.HexToAddress(account)
+	var err error
+	p.Value, err = textBig(value)
+	return &p, err
+}
+
+func (t sqliteTx) balance(account common.Address, block uint64) (*balancePoint, error) {
+	p, err := scanBalance(t.tx.QueryRow(`SELECT account, block, value FROM balances
+		WHERE account = ? AND block <= ? ORDER BY block DESC LIMIT 1`, account.Hex(), clampInt64(block)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+// The projections below rely on SQLite taking the bare columns of a MAX
+// aggregate from the row holding the maximum.
+
+func (t sqliteTx) balances(block uint64) ([]*balancePoint, error) {
+	rows, err := t.tx.Query(`SELECT account, MAX(block), value FROM balances WHERE block <= ? GROUP BY account`, clampInt64(block))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []*balancePoint
+	for rows.Next() {
+		p, err := scanBalance(rows)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (t sqliteTx) putAllowance(p *allowancePoint) error {
+	_, err := t.tx.Exec(`INSERT OR REPLACE INTO allowances (owner, spender, block, value) VALUES (?, ?, ?, ?)`,
+		p.Owner.Hex(), p.Spender.Hex(), p.Block, bigText(p.Value))
+	return err
+}
+
+func scanAllowance(row dbRow) (*allowancePoint, error) {
+	var (
+		p              allowancePoint
+		owner, spender string
+		value          sql.NullString
+	)
+	if err := row.Scan(&owner, &spender, &p.Block, &value); err != nil {
+		return nil, err
+	}
+	p.Owner, p.Spender = common.HexToAddress(owner), common.HexToAddress(spender)
+	var err error
+	p.Value, err = textBig(value)
+	return &p, err
+}
+
+func (t sqliteTx) allowance(owner, spender common.Address, block uint64) (*allowancePoint, error) {
+	p, err := scanAllowance(t.tx.QueryRow(`SELECT owner, spender, block, value FROM allowances
+		WHERE owner = ? AND spender = ? AND block <= ? ORDER BY block DESC LIMIT 1`, owner.Hex(), spender.Hex(), clampInt64(block)))
+	if errors.Is(err, sql.ErrNoRows) {
+		return nil, nil
+	}
+	return p, err
+}
+
+func (t sqliteTx) allowances(block uint64) ([]*allowancePoint, error) {
+	rows, err := t.tx.Query(`SELECT owner, spender, MAX(block), value FROM allowances WHERE block <= ? GROUP BY owner, spender`, clampInt64(block))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+	var points []*allowancePoint
+	for rows.Next() {
+		p, err := scanAllowance(rows)
+		if err != nil {
+			return nil, err
+		}
+		points = append(points, p)
+	}
+	return points, rows.Err()
+}
+
+func (t sqliteTx) deleteLedger(from uint64) error {
+	for _, table := range []string{"transfers", "approvals", "balances", "allowances"} {
+		if _, err := t.tx.Exec(`DELETE FROM `+table+` WHERE block >= ?`, from); err != nil {
+			return err
+		}
+	}
+	return nil
+}
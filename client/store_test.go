@@ -0,0 +1,537 @@
+package main
+
+import (
+	"errors"
+	"math"
+	"math/big"
+	"path/filepath"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/common"
+)
+
+func TestMemStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store { return newMemStore() })
+}
+
+func TestBoltStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store {
+		s, err := openBoltStore(filepath.Join(t.TempDir(), "index.db"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestSQLiteStore(t *testing.T) {
+	testStore(t, func(t *testing.T) store {
+		s, err := openSQLiteStore(filepath.Join(t.TempDir(), "index.sqlite"), false)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	})
+}
+
+func TestIndexSource(t *testing.T) {
+	ix := &index{newMemStore()}
+	token := common.HexToAddress("0x5FbDB2315678afecb367f032d93F642f64180aa3")
+	if err := ix.checkSource(1337, token); err == nil {
+		t.Error("checkSource accepted an unbound index")
+	}
+	if err := ix.bindSource(1337, token); err != nil {
+		t.Fatal(err)
+	}
+	for _, tc := range []struct {
+		chainID uint64
+		address common.Address
+		ok      bool
+	}{
+		{1337, token, true},
+		{11155111, token, false},
+		{1337, common.HexToAddress("0x01"), false},
+	} {
+		if err := ix.checkSource(tc.chainID, tc.address); (err == nil) != tc.ok {
+			t.Errorf("checkSource(%d, %s) = %v", tc.chainID, tc.address.Hex(), err)
+		}
+		if err := ix.bindSource(tc.chainID, tc.address); (err == nil) != tc.ok {
+			t.Errorf("bindSource(%d, %s) = %v", tc.chainID, tc.address.Hex(), err)
+		}
+	}
+}
+
+// testStore is the conformance suite every store backend has to pass. open
+// returns a new, empty store.
+func testStore(t *testing.T, open func(t *testing.T) store) {
+	for _, tc := range []struct {
+		name string
+		run  func(t *testing.T, s store)
+	}{
+		{"meta", testStoreMeta},
+		{"checkpoints", testStoreCheckpoints},
+		{"block hashes", testStoreBlockHashes},
+		{"film events", testStoreFilmEvents},
+		{"catalog", testStoreCatalog},
+		{"ledger", testStoreLedger},
+		{"balances", testStoreBalances},
+		{"allowances", testStoreAllowances},
+		{"failed update", testStoreFailedUpdate},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			s := open(t)
+			defer s.Close()
+			tc.run(t, s)
+		})
+	}
+}
+
+func mustUpdate(t *testing.T, s store, fn func(storeTx) error) {
+	t.Helper()
+	if err := s.update(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func mustView(t *testing.T, s store, fn func(storeTx) error) {
+	t.Helper()
+	if err := s.view(fn); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func testRef(block uint64, index uint) logRef {
+	return logRef{
+		Block:     block,
+		BlockHash: common.BigToHash(new(big.Int).SetUint64(block)),
+		TxHash:    common.BigToHash(new(big.Int).SetUint64(block<<16 | uint64(index))),
+		LogIndex:  index,
+	}
+}
+
+func testFilm(block uint64, index uint, title string, year int64) *filmRecord {
+	if year < 0 {
+		return &filmRecord{logRef: testRef(block, index), Title: title, Deleted: true}
+	}
+	return &filmRecord{logRef: testRef(block, index), Title: title, Year: big.NewInt(year), Genre: uint8(year % 5)}
+}
+
+func titles(records []*filmRecord) []string {
+	var out []string
+	for _, r := range records {
+		out = append(out, r.Title)
+	}
+	return out
+}
+
+func testStoreMeta(t *testing.T, s store) {
+	mustView(t, s, func(tx storeTx) error {
+		if _, ok, err := tx.meta("source"); err != nil || ok {
+			t.Errorf("meta of an empty store = %v, %v; want not found", ok, err)
+		}
+		return nil
+	})
+	mustUpdate(t, s, func(tx storeTx) error { return tx.setMeta("source", "1:0xabc") })
+	mustUpdate(t, s, func(tx storeTx) error { return tx.setMeta("source", "5:0xdef") })
+	mustView(t, s, func(tx storeTx) error {
+		if v, ok, err := tx.meta("source"); err != nil || !ok || v != "5:0xdef" {
+			t.Errorf("meta = %q, %v, %v; want the last value", v, ok, err)
+		}
+		return nil
+	})
+}
+
+func testStoreCheckpoints(t *testing.T, s store) {
+	mustUpdate(t, s, func(tx storeTx) error {
+		if err := tx.setCheckpoint("films", 10); err != nil {
+			return err
+		}
+		if err := tx.setCheckpoint("ledger", 0); err != nil {
+			return err
+		}
+		return tx.setCheckpoint("films", 42)
+	})
+	mustView(t, s, func(tx storeTx) error {
+		for name, want := range map[string]uint64{"films": 42, "ledger": 0} {
+			if next, ok, err := tx.checkpoint(name); err != nil || !ok || next != want {
+				t.Errorf("checkpoint(%s) = %d, %v, %v; want %d", name, next, ok, err, want)
+			}
+		}
+		if _, ok, err := tx.checkpoint("other"); err != nil || ok {
+			t.Errorf("checkpoint of a consumer that never ran = %v, %v; want not found", ok, err)
+		}
+		return nil
+	})
+}
+
+func testStoreBlockHashes(t *testing.T, s store) {
+	hash := func(n uint64) common.Hash { return common.BigToHash(new(big.Int).SetUint64(n + 1000)) }
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, n := range []uint64{7, 3, 5, 1, 9} {
+			if err := tx.putBlockHash("films", n, hash(n)); err != nil {
+				return err
+			}
+		}
+		return tx.putBlockHash("ledger", 5, common.Hash{1})
+	})
+	mustUpdate(t, s, func(tx storeTx) error {
+		if err := tx.deleteBlockHashes("films", 0, 2); err != nil {
+			return err
+		}
+		return tx.deleteBlockHashes("films", 8, math.MaxUint64)
+	})
+	mustView(t, s, func(tx storeTx) error {
+		refs, err := tx.blockHashes("films")
+		if err != nil {
+			return err
+		}
+		want := []blockRef{{3, hash(3)}, {5, hash(5)}, {7, hash(7)}}
+		if !reflect.DeepEqual(refs, want) {
+			t.Errorf("blockHashes = %v, want %v", refs, want)
+		}
+		if h, ok, err := tx.blockHash("films", 5); err != nil || !ok || h != hash(5) {
+			t.Errorf("blockHash(5) = %s, %v, %v", h, ok, err)
+		}
+		if _, ok, err := tx.blockHash("films", 9); err != nil || ok {
+			t.Errorf("blockHash of a deleted block = %v, %v; want not found", ok, err)
+		}
+		if h, ok, err := tx.blockHash("ledger", 5); err != nil || !ok || h != (common.Hash{1}) {
+			t.Errorf("blockHash of another consumer = %s, %v, %v", h, ok, err)
+		}
+		if refs, err := tx.blockHashes("unknown"); err != nil || len(refs) != 0 {
+			t.Errorf("blockHashes of an unknown consumer = %v, %v", refs, err)
+		}
+		return nil
+	})
+}
+
+func testStoreFilmEvents(t *testing.T, s store) {
+	events := []*filmRecord{
+		testFilm(4, 1, "Heat", 1995),
+		testFilm(2, 0, "Alien", 1979),
+		testFilm(4, 0, "Alien", 1986),
+		testFilm(6, 3, "Alien", -1),
+		testFilm(9, 0, "", 2001),
+	}
+	events[2].Overwrite = true
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, r := range events {
+			if err := tx.putFilmEvent(r); err != nil {
+				return err
+			}
+		}
+		// Putting an event again replaces it.
+		return tx.putFilmEvent(testFilm(4, 1, "Heat", 1995))
+	})
+	mustView(t, s, func(tx storeTx) error {
+		all, err := tx.filmEvents(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		want := []*filmRecord{events[1], events[2], events[0], events[3], events[4]}
+		if !reflect.DeepEqual(all, want) {
+			t.Errorf("filmEvents = %v, want %v", titles(all), titles(want))
+		}
+		ranged, err := tx.filmEvents(4, 6)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(ranged, want[1:4]) {
+			t.Errorf("filmEvents(4, 6) = %v, want %v", titles(ranged), titles(want[1:4]))
+		}
+		last, err := tx.lastFilmEvent("Alien")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(last, events[3]) {
+			t.Errorf("lastFilmEvent(Alien) = %+v, want the deletion", last)
+		}
+		if last, err := tx.lastFilmEvent("Ran"); err != nil || last != nil {
+			t.Errorf("lastFilmEvent of an unknown title = %+v, %v", last, err)
+		}
+		return nil
+	})
+
+	mustUpdate(t, s, func(tx storeTx) error {
+		removed, err := tx.deleteFilmEvents(6)
+		if err != nil {
+			return err
+		}
+		if want := []*filmRecord{events[3], events[4]}; !reflect.DeepEqual(removed, want) {
+			t.Errorf("deleteFilmEvents(6) = %v, want %v", titles(removed), titles(want))
+		}
+		return nil
+	})
+	mustView(t, s, func(tx storeTx) error {
+		last, err := tx.lastFilmEvent("Alien")
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(last, events[2]) {
+			t.Errorf("lastFilmEvent(Alien) after the rollback = %+v, want the 1986 one", last)
+		}
+		rest, err := tx.filmEvents(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if len(rest) != 3 {
+			t.Errorf("%d film events left, want 3", len(rest))
+		}
+		return nil
+	})
+}
+
+func testStoreCatalog(t *testing.T, s store) {
+	heat := testFilm(4, 1, "Heat", 1995)
+	heat.Overwrite = true
+	alien := testFilm(6, 3, "Alien", -1)
+	empty := testFilm(9, 0, "", 2001)
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, r := range []*filmRecord{testFilm(2, 0, "Heat", 1990), heat, alien, empty, testFilm(1, 0, "Ran", 1985)} {
+			if err := tx.putFilm(r); err != nil {
+				return err
+			}
+		}
+		return tx.deleteFilm("Ran")
+	})
+	mustView(t, s, func(tx storeTx) error {
+		for _, want := range []*filmRecord{heat, alien, empty} {
+			got, err := tx.film(want.Title)
+			if err != nil {
+				return err
+			}
+			if !reflect.DeepEqual(got, want) {
+				t.Errorf("film(%q) = %+v, want %+v", want.Title, got, want)
+			}
+		}
+		if got, err := tx.film("Ran"); err != nil || got != nil {
+			t.Errorf("film of a deleted entry = %+v, %v", got, err)
+		}
+		all, err := tx.films()
+		if err != nil {
+			return err
+		}
+		if len(all) != 3 {
+			t.Errorf("films = %v, want 3 entries", titles(all))
+		}
+		return nil
+	})
+}
+
+func testStoreLedger(t *testing.T, s store) {
+	a, b := common.HexToAddress("0xa"), common.HexToAddress("0xb")
+	huge, _ := new(big.Int).SetString("115792089237316195423570985008687907853269984665640564039457584007913129639935", 10)
+	transfers := []*transferRecord{
+		{logRef: testRef(3, 0), From: common.Address{}, To: a, Value: big.NewInt(1000)},
+		{logRef: testRef(5, 2), From: a, To: b, Value: huge},
+		{logRef: testRef(5, 1), From: a, To: b, Value: big.NewInt(0)},
+		{logRef: testRef(8, 0), From: b, To: a, Value: big.NewInt(7)},
+	}
+	approvals := []*approvalRecord{
+		{logRef: testRef(4, 0), Owner: a, Spender: b, Value: huge},
+		{logRef: testRef(8, 1), Owner: a, Spender: b, Value: big.NewInt(0)},
+	}
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, r := range transfers {
+			if err := tx.putTransfer(r); err != nil {
+				return err
+			}
+		}
+		for _, r := range approvals {
+			if err := tx.putApproval(r); err != nil {
+				return err
+			}
+		}
+		return tx.putTransfer(transfers[0])
+	})
+	mustView(t, s, func(tx storeTx) error {
+		got, err := tx.transfers(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if want := []*transferRecord{transfers[0], transfers[2], transfers[1], transfers[3]}; !reflect.DeepEqual(got, want) {
+			t.Errorf("transfers = %+v, want %+v", got, want)
+		}
+		got, err = tx.transfers(4, 5)
+		if err != nil {
+			return err
+		}
+		if len(got) != 2 {
+			t.Errorf("transfers(4, 5) returned %d events, want 2", len(got))
+		}
+		approved, err := tx.approvals(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if !reflect.DeepEqual(approved, approvals) {
+			t.Errorf("approvals = %+v, want %+v", approved, approvals)
+		}
+		return nil
+	})
+	mustUpdate(t, s, func(tx storeTx) error { return tx.deleteLedger(5) })
+	mustView(t, s, func(tx storeTx) error {
+		got, err := tx.transfers(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if want := transfers[:1]; !reflect.DeepEqual(got, want) {
+			t.Errorf("transfers after deleteLedger(5) = %+v, want %+v", got, want)
+		}
+		approved, err := tx.approvals(0, math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if want := approvals[:1]; !reflect.DeepEqual(approved, want) {
+			t.Errorf("approvals after deleteLedger(5) = %+v, want %+v", approved, want)
+		}
+		return nil
+	})
+}
+
+func testStoreBalances(t *testing.T, s store) {
+	a, b := common.HexToAddress("0xa"), common.HexToAddress("0xb")
+	point := func(account common.Address, block uint64, value int64) *balancePoint {
+		return &balancePoint{Account: account, Block: block, Value: big.NewInt(value)}
+	}
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, p := range []*balancePoint{point(a, 3, 100), point(b, 5, 1), point(a, 5, 60), point(a, 9, 0), point(b, 5, 40)} {
+			if err := tx.putBalance(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	balanceOf := func(tx storeTx, account common.Address, block uint64) int64 {
+		t.Helper()
+		p, err := tx.balance(account, block)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p == nil {
+			return -1
+		}
+		return p.Value.Int64()
+	}
+	mustView(t, s, func(tx storeTx) error {
+		for _, tc := range []struct {
+			account common.Address
+			block   uint64
+			want    int64
+		}{
+			{a, 2, -1}, {a, 3, 100}, {a, 4, 100}, {a, 5, 60}, {a, 8, 60}, {a, 9, 0}, {a, math.MaxUint64, 0},
+			{b, 4, -1}, {b, 5, 40}, {common.HexToAddress("0xc"), 9, -1},
+		} {
+			if got := balanceOf(tx, tc.account, tc.block); got != tc.want {
+				t.Errorf("balance of %s at %d = %d, want %d", tc.account, tc.block, got, tc.want)
+			}
+		}
+		points, err := tx.balances(5)
+		if err != nil {
+			return err
+		}
+		got := make(map[common.Address]*balancePoint)
+		for _, p := range points {
+			got[p.Account] = p
+		}
+		want := map[common.Address]*balancePoint{a: point(a, 5, 60), b: point(b, 5, 40)}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("balances(5) = %v, want %v", got, want)
+		}
+		if points, err := tx.balances(2); err != nil || len(points) != 0 {
+			t.Errorf("balances before the first point = %v, %v", points, err)
+		}
+		return nil
+	})
+	mustUpdate(t, s, func(tx storeTx) error { return tx.deleteLedger(5) })
+	mustView(t, s, func(tx storeTx) error {
+		if got := balanceOf(tx, a, math.MaxUint64); got != 100 {
+			t.Errorf("balance of a after deleteLedger(5) = %d, want 100", got)
+		}
+		if got := balanceOf(tx, b, math.MaxUint64); got != -1 {
+			t.Errorf("balance of b after deleteLedger(5) = %d, want none", got)
+		}
+		return nil
+	})
+}
+
+func testStoreAllowances(t *testing.T, s store) {
+	a, b, c := common.HexToAddress("0xa"), common.HexToAddress("0xb"), common.HexToAddress("0xc")
+	point := func(owner, spender common.Address, block uint64, value int64) *allowancePoint {
+		return &allowancePoint{Owner: owner, Spender: spender, Block: block, Value: big.NewInt(value)}
+	}
+	mustUpdate(t, s, func(tx storeTx) error {
+		for _, p := range []*allowancePoint{point(a, b, 2, 50), point(a, c, 4, 7), point(a, b, 6, 20), point(b, a, 6, 1)} {
+			if err := tx.putAllowance(p); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	mustView(t, s, func(tx storeTx) error {
+		p, err := tx.allowance(a, b, 5)
+		if err != nil {
+			return err
+		}
+		if want := point(a, b, 2, 50); !reflect.DeepEqual(p, want) {
+			t.Errorf("allowance(a, b, 5) = %+v, want %+v", p, want)
+		}
+		if p, err := tx.allowance(b, c, 9); err != nil || p != nil {
+			t.Errorf("allowance of an unknown pair = %+v, %v", p, err)
+		}
+		points, err := tx.allowances(6)
+		if err != nil {
+			return err
+		}
+		got := make(map[[2]common.Address]*allowancePoint)
+		for _, p := range points {
+			got[[2]common.Address{p.Owner, p.Spender}] = p
+		}
+		want := map[[2]common.Address]*allowancePoint{
+			{a, b}: point(a, b, 6, 20),
+			{a, c}: point(a, c, 4, 7),
+			{b, a}: point(b, a, 6, 1),
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("allowances(6) = %v, want %v", got, want)
+		}
+		return nil
+	})
+	mustUpdate(t, s, func(tx storeTx) error { return tx.deleteLedger(6) })
+	mustView(t, s, func(tx storeTx) error {
+		points, err := tx.allowances(math.MaxUint64)
+		if err != nil {
+			return err
+		}
+		if len(points) != 2 {
+			t.Errorf("allowances after deleteLedger(6) = %v, want 2", points)
+		}
+		return nil
+	})
+}
+
+func testStoreFailedUpdate(t *testing.T, s store) {
+	mustUpdate(t, s, func(tx storeTx) error { return tx.setCheckpoint("films", 5) })
+	errAbort := errors.New("abort")
+	err := s.update(func(tx storeTx) error {
+		if err := tx.setCheckpoint("films", 9); err != nil {
+			return err
+		}
+		if err := tx.putFilm(testFilm(7, 0, "Heat", 1995)); err != nil {
+			return err
+		}
+		return errAbort
+	})
+	if !errors.Is(err, errAbort) {
+		t.Fatalf("update = %v, want the error of fn", err)
+	}
+	mustView(t, s, func(tx storeTx) error {
+		if next, _, err := tx.checkpoint("films"); err != nil || next != 5 {
+			t.Errorf("checkpoint after a failed update = %d, %v; want 5", next, err)
+		}
+		if got, err := tx.film("Heat"); err != nil || got != nil {
+			t.Errorf("film after a failed update = %+v, %v; want none", got, err)
+		}
+		return nil
+	})
+}
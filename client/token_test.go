@@ -0,0 +1,378 @@
+package main
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/accounts/abi/bind/backends"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/params"
+
+	"github.com/Ch0p1k3/hse-blockchain-lab/client/txerr"
+)
+
+// testSupply is the initial supply the contract tests deploy with, as in the
+// hardhat suite.
+const testSupply = 100000
+
+// tokenEnv is EmeraldToken deployed by owner on a fresh simulated backend,
+// with alice and bob funded with ether but holding no tokens.
+type tokenEnv struct {
+	t       *testing.T
+	backend *backends.SimulatedBackend
+	token   *Main
+	address common.Address
+
+	owner, alice, bob *bind.TransactOpts
+}
+
+func newTokenEnv(t *testing.T) *tokenEnv {
+	t.Helper()
+	keys := make([]*ecdsa.PrivateKey, 3)
+	alloc := make(core.GenesisAlloc, len(keys))
+	for i := range keys {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		keys[i] = key
+		alloc[crypto.PubkeyToAddress(key.PublicKey)] = core.GenesisAccount{Balance: big.NewInt(params.Ether)}
+	}
+	backend := backends.NewSimulatedBackend(alloc, 30_000_000)
+	t.Cleanup(func() { backend.Close() })
+
+	env := &tokenEnv{t: t, backend: backend}
+	opts := make([]*bind.TransactOpts, len(keys))
+	for i, key := range keys {
+		auth, err := bind.NewKeyedTransactorWithChainID(key, devnetChainID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		opts[i] = auth
+	}
+	env.owner, env.alice, env.bob = opts[0], opts[1], opts[2]
+
+	address, tx, token, err := DeployMain(env.owner, backend, big.NewInt(testSupply))
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.address, env.token = address, token
+	env.mined(tx, nil)
+	return env
+}
+
+// mined commits the block holding tx, whose sending returned err, and
+// returns its receipt. It fails the test unless tx succeeded.
+func (env *tokenEnv) mined(tx *types.Transaction, err error) *types.Receipt {
+	t := env.t
+	t.Helper()
+	if err != nil {
+		t.Fatal(err)
+	}
+	env.backend.Commit()
+	receipt, err := env.backend.TransactionReceipt(context.Background(), tx.Hash())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if receipt.Status != types.ReceiptStatusSuccessful {
+		t.Fatalf("transaction %s reverted", tx.Hash().Hex())
+	}
+	return receipt
+}
+
+// events decodes the token events of the mined tx.
+func (env *tokenEnv) events(tx *types.Transaction, err error) txEvents {
+	t := env.t
+	t.Helper()
+	receipt := env.mined(tx, err)
+	events, err := decodeEvents(&env.token.MainFilterer, env.address, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return events
+}
+
+func (env *tokenEnv) balance(account common.Address) int64 {
+	t := env.t
+	t.Helper()
+	balance, err := env.token.BalanceOf(nil, account)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return balance.Int64()
+}
+
+func (env *tokenEnv) allowance(owner, spender common.Address) int64 {
+	t := env.t
+	t.Helper()
+	allowance, err := env.token.Allowance(nil, owner, spender)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return allowance.Int64()
+}
+
+func (env *tokenEnv) totalSupply() int64 {
+	t := env.t
+	t.Helper()
+	supply, err := env.token.TotalSupply(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return supply.Int64()
+}
+
+// wantRevert checks that sending a transaction failed with a revert of class
+// and reason. The binding estimates gas first, so a reverting call never
+// reaches the chain.
+func wantRevert(t *testing.T, err error, class txerr.Class, reason string) {
+	t.Helper()
+	if err == nil {
+		t.Fatal("transaction did not revert")
+	}
+	err = txerr.Classify(err)
+	if got := txerr.ClassOf(err); got != class {
+		t.Fatalf("class of %q = %v, want %v", err, got, class)
+	}
+	if !errors.Is(err, txerr.ErrReverted) {
+		t.Fatalf("%q does not match txerr.ErrReverted", err)
+	}
+	if got := txerr.RevertReason(err); got != reason {
+		t.Fatalf("revert reason = %q, want %q", got, reason)
+	}
+	if txerr.Retryable(err) {
+		t.Fatalf("%q is retryable", err)
+	}
+}
+
+func TestTokenSetup(t *testing.T) {
+	env := newTokenEnv(t)
+	name, err := env.token.Name(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "Emerald" {
+		t.Errorf("name = %q, want Emerald", name)
+	}
+	symbol, err := env.token.Symbol(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if symbol != "EMD" {
+		t.Errorf("symbol = %q, want EMD", symbol)
+	}
+	owner, err := env.token.Owner(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if owner != env.owner.From {
+		t.Errorf("owner = %s, want the deployer %s", owner.Hex(), env.owner.From.Hex())
+	}
+	if got := env.totalSupply(); got != testSupply {
+		t.Errorf("total supply = %d, want %d", got, testSupply)
+	}
+	if got := env.balance(env.owner.From); got != testSupply {
+		t.Errorf("owner balance = %d, want the whole supply %d", got, testSupply)
+	}
+}
+
+func TestTokenTransfer(t *testing.T) {
+	env := newTokenEnv(t)
+	if got := env.balance(env.alice.From); got != 0 {
+		t.Fatalf("alice starts with %d", got)
+	}
+
+	events := env.events(env.token.Transfer(env.owner, env.alice.From, big.NewInt(1000)))
+	if len(events.Transfers) != 1 {
+		t.Fatalf("got %d Transfer events, want 1", len(events.Transfers))
+	}
+	ev := events.Transfers[0]
+	if ev.From != env.owner.From || ev.To != env.alice.From || ev.Value.Int64() != 1000 {
+		t.Errorf("Transfer %s -> %s: %s, want owner -> alice: 1000", ev.From.Hex(), ev.To.Hex(), ev.Value)
+	}
+	if got := env.balance(env.alice.From); got != 1000 {
+		t.Errorf("alice balance = %d, want 1000", got)
+	}
+
+	env.mined(env.token.Transfer(env.alice, env.bob.From, big.NewInt(1000)))
+	if got := env.balance(env.bob.From); got != 1000 {
+		t.Errorf("bob balance = %d, want 1000", got)
+	}
+	if got := env.balance(env.alice.From); got != 0 {
+		t.Errorf("alice balance = %d, want 0", got)
+	}
+	if got := env.totalSupply(); got != testSupply {
+		t.Errorf("total supply = %d after transfers, want %d", got, testSupply)
+	}
+}
+
+func TestTokenTransferExceedingBalance(t *testing.T) {
+	env := newTokenEnv(t)
+	_, err := env.token.Transfer(env.owner, env.alice.From, big.NewInt(testSupply+1))
+	wantRevert(t, err, txerr.InsufficientBalance, "ERC20: transfer amount exceeds balance")
+	if !errors.Is(txerr.Classify(err), txerr.ErrInsufficientBalance) {
+		t.Errorf("%q does not match txerr.ErrInsufficientBalance", err)
+	}
+}
+
+func TestTokenMint(t *testing.T) {
+	env := newTokenEnv(t)
+	events := env.events(env.token.Mint(env.owner, env.alice.From, big.NewInt(1000)))
+	if len(events.Transfers) != 1 || events.Transfers[0].From != (common.Address{}) {
+		t.Fatalf("mint emitted %+v, want one Transfer from the zero address", events.Transfers)
+	}
+	if got := env.totalSupply(); got != testSupply+1000 {
+		t.Errorf("total supply = %d, want %d", got, testSupply+1000)
+	}
+	if got := env.balance(env.alice.From); got != 1000 {
+		t.Errorf("alice balance = %d, want 1000", got)
+	}
+	if got := env.balance(env.bob.From); got != 0 {
+		t.Errorf("bob balance = %d, want 0", got)
+	}
+	if got := env.balance(env.owner.From); got != testSupply {
+		t.Errorf("owner balance = %d, want %d", got, testSupply)
+	}
+}
+
+func TestTokenMintOnlyOwner(t *testing.T) {
+	env := newTokenEnv(t)
+	_, err := env.token.Mint(env.alice, env.alice.From, big.NewInt(1000))
+	wantRevert(t, err, txerr.NotOwner, "Ownable: caller is not the owner")
+	if !errors.Is(txerr.Classify(err), txerr.ErrNotOwner) {
+		t.Errorf("%q does not match txerr.ErrNotOwner", err)
+	}
+	if got := env.totalSupply(); got != testSupply {
+		t.Errorf("total supply = %d, want %d", got, testSupply)
+	}
+}
+
+func TestTokenAllowance(t *testing.T) {
+	env := newTokenEnv(t)
+	if got := env.allowance(env.owner.From, env.alice.From); got != 0 {
+		t.Fatalf("default allowance = %d, want 0", got)
+	}
+
+	events := env.events(env.token.Approve(env.owner, env.alice.From, big.NewInt(1000)))
+	if len(events.Approvals) != 1 {
+		t.Fatalf("got %d Approval events, want 1", len(events.Approvals))
+	}
+	ev := events.Approvals[0]
+	if ev.Owner != env.owner.From || ev.Spender != env.alice.From || ev.Value.Int64() != 1000 {
+		t.Errorf("Approval %s -> %s: %s, want owner -> alice: 1000", ev.Owner.Hex(), ev.Spender.Hex(), ev.Value)
+	}
+	if got := env.allowance(env.owner.From, env.alice.From); got != 1000 {
+		t.Errorf("allowance = %d, want 1000", got)
+	}
+
+	env.mined(env.token.IncreaseAllowance(env.owner, env.alice.From, big.NewInt(1000)))
+	if got := env.allowance(env.owner.From, env.alice.From); got != 2000 {
+		t.Errorf("allowance after increaseAllowance = %d, want 2000", got)
+	}
+	env.mined(env.token.DecreaseAllowance(env.owner, env.alice.From, big.NewInt(100)))
+	if got := env.allowance(env.owner.From, env.alice.From); got != 1900 {
+		t.Errorf("allowance after decreaseAllowance = %d, want 1900", got)
+	}
+	_, err := env.token.DecreaseAllowance(env.owner, env.alice.From, big.NewInt(2000))
+	wantRevert(t, err, txerr.Reverted, "ERC20: decreased allowance below zero")
+}
+
+func TestTokenTransferFrom(t *testing.T) {
+	env := newTokenEnv(t)
+	env.mined(env.token.Transfer(env.owner, env.alice.From, big.NewInt(1000)))
+	env.mined(env.token.Approve(env.alice, env.owner.From, big.NewInt(1000)))
+
+	_, err := env.token.TransferFrom(env.owner, env.alice.From, env.owner.From, big.NewInt(1001))
+	wantRevert(t, err, txerr.Reverted, "ERC20: insufficient allowance")
+
+	events := env.events(env.token.TransferFrom(env.owner, env.alice.From, env.owner.From, big.NewInt(1000)))
+	if len(events.Transfers) != 1 {
+		t.Fatalf("got %d Transfer events, want 1", len(events.Transfers))
+	}
+	// The spent allowance is reported as an Approval of what is left.
+	if len(events.Approvals) != 1 || events.Approvals[0].Value.Sign() != 0 {
+		t.Errorf("transferFrom emitted approvals %+v, want one of 0", events.Approvals)
+	}
+	if got := env.balance(env.owner.From); got != testSupply {
+		t.Errorf("owner balance = %d, want %d", got, testSupply)
+	}
+	if got := env.balance(env.alice.From); got != 0 {
+		t.Errorf("alice balance = %d, want 0", got)
+	}
+	if got := env.allowance(env.alice.From, env.owner.From); got != 0 {
+		t.Errorf("allowance after transferFrom = %d, want 0", got)
+	}
+}
+
+func TestTokenFilms(t *testing.T) {
+	env := newTokenEnv(t)
+	storage := &filmStorage{reader: env.backend, address: env.address}
+	ctx := context.Background()
+
+	// Anyone may edit the catalog, not only the owner.
+	events := env.events(env.token.AddFilm(env.alice, "Alien", big.NewInt(1979), 0))
+	if len(events.FilmsAdded) != 1 {
+		t.Fatalf("got %d FilmAdded events, want 1", len(events.FilmsAdded))
+	}
+	added := events.FilmsAdded[0]
+	if added.Title != "Alien" || added.Year.Int64() != 1979 || added.Genre != 0 {
+		t.Errorf("FilmAdded %q year=%s genre=%d, want \"Alien\" year=1979 genre=0", added.Title, added.Year, added.Genre)
+	}
+	film, err := storage.film(ctx, "Alien", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if film == nil || film.Title != "Alien" || film.Year.Int64() != 1979 || film.Genre != 0 {
+		t.Errorf("stored film = %+v, want Alien 1979 Horror", film)
+	}
+
+	// Adding a title again replaces it.
+	env.mined(env.token.AddFilm(env.bob, "Alien", big.NewInt(1986), 2))
+	if film, err = storage.film(ctx, "Alien", nil); err != nil {
+		t.Fatal(err)
+	}
+	if film == nil || film.Year.Int64() != 1986 || film.Genre != 2 {
+		t.Errorf("stored film = %+v, want Alien 1986 Drama", film)
+	}
+
+	events = env.events(env.token.DeleteFilm(env.owner, "Alien"))
+	if len(events.FilmsDeleted) != 1 || events.FilmsDeleted[0].Title != "Alien" {
+		t.Fatalf("deleteFilm emitted %+v, want FilmDeleted \"Alien\"", events.FilmsDeleted)
+	}
+	if film, err = storage.film(ctx, "Alien", nil); err != nil {
+		t.Fatal(err)
+	}
+	if film != nil {
+		t.Errorf("deleted film is still stored: %+v", film)
+	}
+
+	// The contract has no notion of a missing film, so deleting one emits
+	// the event all the same.
+	events = env.events(env.token.DeleteFilm(env.owner, "Missing"))
+	if len(events.FilmsDeleted) != 1 {
+		t.Errorf("got %d FilmDeleted events for a missing film, want 1", len(events.FilmsDeleted))
+	}
+
+	// Genre is an enum of three values; anything else fails ABI decoding.
+	_, err = env.token.AddFilm(env.owner, "Bad", big.NewInt(2000), 3)
+	wantRevert(t, err, txerr.Reverted, "")
+}
+
+func TestDecodeEventsSkipsOtherContracts(t *testing.T) {
+	env := newTokenEnv(t)
+	receipt := env.mined(env.token.Transfer(env.owner, env.alice.From, big.NewInt(1)))
+	events, err := decodeEvents(&env.token.MainFilterer, common.HexToAddress("0x01"), receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(events.Transfers) != 0 {
+		t.Errorf("decoded %d Transfer events of another contract", len(events.Transfers))
+	}
+}
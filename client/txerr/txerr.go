@@ -0,0 +1,318 @@
+// Package txerr classifies the errors returned by Ethereum nodes and by the
+// EmeraldToken contract, so callers can decide whether a failed transaction
+// is worth retrying.
+//
+// Classify wraps an error into an *Error carrying its Class. The result keeps
+// the original error reachable through errors.Unwrap and matches the sentinel
+// values below with errors.Is:
+//
+//	if errors.Is(err, txerr.ErrNotOwner) { ... }
+//
+// Every class has a retry policy, see Class.Policy.
+package txerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// Class is the category of a failure.
+type Class int
+
+const (
+	// Unknown is any failure that matched no other class. Permanent.
+	Unknown Class = iota
+	// NonceTooLow means the nonce was already used by a mined transaction.
+	// RetryAfterResync: fetch the pending nonce again and rebuild.
+	NonceTooLow
+	// ReplacementUnderpriced means a pending transaction already holds the
+	// nonce and the new one does not pay enough more to replace it.
+	// RetryWithHigherFee: bump the fees, or resync to use a fresh nonce.
+	ReplacementUnderpriced
+	// InsufficientFunds means the sender cannot pay gas * price + value.
+	// Permanent until the account is funded.
+	InsufficientFunds
+	// Reverted means the EVM reverted the call, with Error.Reason when the
+	// node returned one. Permanent: the same call reverts again.
+	Reverted
+	// NotOwner is the OpenZeppelin Ownable revert "Ownable: caller is not the
+	// owner". Permanent; it also matches ErrReverted.
+	NotOwner
+	// InsufficientBalance is the OpenZeppelin ERC20 revert "ERC20: transfer
+	// amount exceeds balance". Permanent; it also matches ErrReverted.
+	InsufficientBalance
+	// RateLimited means the RPC provider throttled the request.
+	// RetryWithBackoff.
+	RateLimited
+	// Timeout means the request or the wait for a receipt ran out of time;
+	// the transaction may still be mined. RetryWithBackoff, after checking
+	// whether the original transaction landed.
+	Timeout
+)
+
+var classNames = [...]string{
+	Unknown:                "unknown",
+	NonceTooLow:            "nonce too low",
+	ReplacementUnderpriced: "replacement underpriced",
+	InsufficientFunds:      "insufficient funds for gas",
+	Reverted:               "execution reverted",
+	NotOwner:               "caller is not the owner",
+	InsufficientBalance:    "transfer amount exceeds balance",
+	RateLimited:            "rate limited",
+	Timeout:                "timeout",
+}
+
+func (c Class) String() string {
+	if c < 0 || int(c) >= len(classNames) {
+		return fmt.Sprintf("class(%d)", int(c))
+	}
+	return classNames[c]
+}
+
+// Policy says how a failure of some class should be retried.
+type Policy int
+
+const (
+	// Permanent failures repeat if retried unchanged.
+	Permanent Policy = iota
+	// RetryAfterResync failures succeed once the nonce is refetched.
+	RetryAfterResync
+	// RetryWithHigherFee failures succeed with bumped fees.
+	RetryWithHigherFee
+	// RetryWithBackoff failures are transient; retry after a delay.
+	RetryWithBackoff
+)
+
+func (p Policy) String() string {
+	switch p {
+	case RetryAfterResync:
+		return "retry after nonce resync"
+	case RetryWithHigherFee:
+		return "retry with higher fee"
+	case RetryWithBackoff:
+		return "retry with backoff"
+	default:
+		return "permanent"
+	}
+}
+
+// Policy returns the retry policy of the class.
+func (c Class) Policy() Policy {
+	switch c {
+	case NonceTooLow:
+		return RetryAfterResync
+	case ReplacementUnderpriced:
+		return RetryWithHigherFee
+	case RateLimited, Timeout:
+		return RetryWithBackoff
+	default:
+		return Permanent
+	}
+}
+
+// Retryable reports whether the class is worth retrying in some form.
+func (c Class) Retryable() bool {
+	return c.Policy() != Permanent
+}
+
+// Rejected reports whether a node that failed to accept a transaction with
+// an error of the class definitely refused it. For the other classes, such as
+// a timeout or a dropped connection, the transaction may be in the pool
+// anyway.
+func (c Class) Rejected() bool {
+	switch c {
+	case NonceTooLow, ReplacementUnderpriced, InsufficientFunds, Reverted, NotOwner, InsufficientBalance:
+		return true
+	default:
+		return false
+	}
+}
+
+// Error is a classified failure.
+type Error struct {
+	Class  Class
+	Reason string // revert reason for Reverted, NotOwner and InsufficientBalance
+	Err    error  // underlying error, nil for errors built from a revert reason
+}
+
+func (e *Error) Error() string {
+	switch {
+	case e.Err != nil:
+		return e.Err.Error()
+	case e.Reason != "":
+		return "execution reverted: " + e.Reason
+	default:
+		return e.Class.String()
+	}
+}
+
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is matches the sentinel of the error's class. The contract-specific revert
+// classes also match ErrReverted.
+func (e *Error) Is(target error) bool {
+	s, ok := target.(*sentinel)
+	if !ok {
+		return false
+	}
+	if s.class == e.Class {
+		return true
+	}
+	return s.class == Reverted && (e.Class == NotOwner || e.Class == InsufficientBalance)
+}
+
+type sentinel struct {
+	class Class
+}
+
+func (s *sentinel) Error() string {
+	return s.class.String()
+}
+
+// Sentinels for errors.Is, one per class.
+var (
+	ErrUnknown                = error(&sentinel{Unknown})
+	ErrNonceTooLow            = error(&sentinel{NonceTooLow})
+	ErrReplacementUnderpriced = error(&sentinel{ReplacementUnderpriced})
+	ErrInsufficientFunds      = error(&sentinel{InsufficientFunds})
+	ErrReverted               = error(&sentinel{Reverted})
+	ErrNotOwner               = error(&sentinel{NotOwner})
+	ErrInsufficientBalance    = error(&sentinel{InsufficientBalance})
+	ErrRateLimited            = error(&sentinel{RateLimited})
+	ErrTimeout                = error(&sentinel{Timeout})
+)
+
+// Revert reasons of the OpenZeppelin contracts EmeraldToken builds on.
+const (
+	reasonNotOwner            = "Ownable: caller is not the owner"
+	reasonInsufficientBalance = "ERC20: transfer amount exceeds balance"
+)
+
+// Classify wraps err into an *Error. It returns nil for nil and err itself if
+// it is already classified.
+func Classify(err error) error {
+	if err == nil {
+		return nil
+	}
+	var classified *Error
+	if errors.As(err, &classified) {
+		return err
+	}
+	class, reason := classify(err)
+	return &Error{Class: class, Reason: reason, Err: err}
+}
+
+// ClassOf returns the class of err, classifying it if needed.
+func ClassOf(err error) Class {
+	if err == nil {
+		return Unknown
+	}
+	var classified *Error
+	if errors.As(Classify(err), &classified) {
+		return classified.Class
+	}
+	return Unknown
+}
+
+// Retryable reports whether err is worth retrying in some form.
+func Retryable(err error) bool {
+	return err != nil && ClassOf(err).Retryable()
+}
+
+// FromRevertReason builds the error for a call that reverted with reason,
+// for example one recovered from a mined transaction with status 0.
+func FromRevertReason(reason string) *Error {
+	return &Error{Class: revertClass(reason), Reason: reason}
+}
+
+func revertClass(reason string) Class {
+	switch reason {
+	case reasonNotOwner:
+		return NotOwner
+	case reasonInsufficientBalance:
+		return InsufficientBalance
+	default:
+		return Reverted
+	}
+}
+
+func classify(err error) (Class, string) {
+	// A revert reason is free text, so it is recognized before any message
+	// matching could misread it.
+	if reverted(err) {
+		reason := RevertReason(err)
+		return revertClass(reason), reason
+	}
+	// Timeouts are only recognized by type: the same words in a node's
+	// message do not mean the request went unanswered.
+	if errors.Is(err, context.DeadlineExceeded) {
+		return Timeout, ""
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) && netErr.Timeout() {
+		return Timeout, ""
+	}
+	var httpErr rpc.HTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == 429 {
+		return RateLimited, ""
+	}
+	var rpcErr rpc.Error
+	if errors.As(err, &rpcErr) && rpcErr.ErrorCode() == -32005 {
+		// Infura and others use -32005 for "limit exceeded".
+		return RateLimited, ""
+	}
+
+	msg := strings.ToLower(err.Error())
+	switch {
+	case strings.Contains(msg, "nonce too low"):
+		return NonceTooLow, ""
+	case strings.Contains(msg, "replacement transaction underpriced"):
+		return ReplacementUnderpriced, ""
+	case strings.Contains(msg, "insufficient funds for gas"):
+		return InsufficientFunds, ""
+	case strings.Contains(msg, "rate limit"), strings.Contains(msg, "too many requests"):
+		return RateLimited, ""
+	}
+	return Unknown, ""
+}
+
+// reverted reports whether err is a call that reverted: a JSON-RPC error with
+// code 3, which nodes attach the revert data to, or one saying so.
+func reverted(err error) bool {
+	var dataErr rpc.DataError
+	var rpcErr rpc.Error
+	if errors.As(err, &dataErr) && errors.As(err, &rpcErr) && rpcErr.ErrorCode() == 3 {
+		return true
+	}
+	return strings.Contains(strings.ToLower(err.Error()), "execution reverted")
+}
+
+// RevertReason recovers the Error(string) reason of a revert, first from the
+// data attached to the JSON-RPC error and then from the message text. It
+// returns an empty string when err carries no reason.
+func RevertReason(err error) string {
+	var dataErr rpc.DataError
+	if errors.As(err, &dataErr) {
+		if hexData, ok := dataErr.ErrorData().(string); ok {
+			if data, err := hexutil.Decode(hexData); err == nil {
+				if reason, err := abi.UnpackRevert(data); err == nil {
+					return reason
+				}
+			}
+		}
+	}
+	msg := err.Error()
+	if i := strings.Index(msg, "execution reverted: "); i >= 0 {
+		return msg[i+len("execution reverted: "):]
+	}
+	return ""
+}
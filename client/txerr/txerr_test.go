@@ -0,0 +1,188 @@
+package txerr
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"reflect"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/rpc"
+)
+
+// rpcError is a JSON-RPC error as ethclient returns it.
+type rpcError struct {
+	code    int
+	message string
+	data    interface{}
+}
+
+func (e *rpcError) Error() string          { return e.message }
+func (e *rpcError) ErrorCode() int         { return e.code }
+func (e *rpcError) ErrorData() interface{} { return e.data }
+
+// revertData ABI-encodes reason as Error(string).
+func revertData(t *testing.T, reason string) string {
+	t.Helper()
+	stringType, err := abi.NewType("string", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	args, err := abi.Arguments{{Type: stringType}}.Pack(reason)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(append(crypto.Keccak256([]byte("Error(string)"))[:4], args...))
+}
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		name   string
+		err    error
+		class  Class
+		reason string
+	}{
+		{"nonce too low", errors.New("nonce too low: address 0x01, tx: 0 state: 1"), NonceTooLow, ""},
+		{"underpriced", errors.New("replacement transaction underpriced"), ReplacementUnderpriced, ""},
+		{"insufficient funds", errors.New("insufficient funds for gas * price + value"), InsufficientFunds, ""},
+		{"rate limit message", errors.New("daily request count exceeded, request rate limited"), RateLimited, ""},
+		{"too many requests", errors.New("429 Too Many Requests"), RateLimited, ""},
+		{"http 429", rpc.HTTPError{StatusCode: 429, Status: "429 Too Many Requests"}, RateLimited, ""},
+		{"limit exceeded", &rpcError{code: -32005, message: "limit exceeded"}, RateLimited, ""},
+		{"deadline", fmt.Errorf("waiting for transaction: %w", context.DeadlineExceeded), Timeout, ""},
+		{"net timeout", fmt.Errorf("post: %w", timeoutError{}), Timeout, ""},
+		{"timeout message", errors.New("request timed out"), Unknown, ""},
+		{"timeout reason", errors.New("execution reverted: sale timeout"), Reverted, "sale timeout"},
+		{
+			"timeout revert data",
+			&rpcError{code: 3, message: "execution reverted: request timed out", data: revertData(t, "request timed out")},
+			Reverted, "request timed out",
+		},
+		{"revert code", &rpcError{code: 3, message: "vm error", data: revertData(t, "too many requests")}, Reverted, "too many requests"},
+		{"bare revert", errors.New("execution reverted"), Reverted, ""},
+		{"revert message", errors.New("execution reverted: ERC20: insufficient allowance"), Reverted, "ERC20: insufficient allowance"},
+		{"not owner message", errors.New("execution reverted: Ownable: caller is not the owner"), NotOwner, "Ownable: caller is not the owner"},
+		{
+			"revert data",
+			&rpcError{code: 3, message: "execution reverted", data: revertData(t, "ERC20: transfer amount exceeds balance")},
+			InsufficientBalance, "ERC20: transfer amount exceeds balance",
+		},
+		{"unknown", errors.New("method not found"), Unknown, ""},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			err := Classify(tc.err)
+			var classified *Error
+			if !errors.As(err, &classified) {
+				t.Fatalf("Classify returned %T", err)
+			}
+			if classified.Class != tc.class || classified.Reason != tc.reason {
+				t.Errorf("classified as %v with reason %q, want %v with %q", classified.Class, classified.Reason, tc.class, tc.reason)
+			}
+			// rpc.HTTPError is not comparable, so errors.Is cannot find it.
+			if !reflect.DeepEqual(errors.Unwrap(err), tc.err) {
+				t.Error("the original error is not reachable")
+			}
+			if err.Error() != tc.err.Error() {
+				t.Errorf("message = %q, want the original %q", err.Error(), tc.err.Error())
+			}
+			if Classify(err) != err {
+				t.Error("classifying twice wrapped again")
+			}
+			if got := ClassOf(tc.err); got != tc.class {
+				t.Errorf("ClassOf = %v, want %v", got, tc.class)
+			}
+		})
+	}
+}
+
+func TestClassifyNil(t *testing.T) {
+	if err := Classify(nil); err != nil {
+		t.Errorf("Classify(nil) = %v", err)
+	}
+	if Retryable(nil) {
+		t.Error("nil is retryable")
+	}
+}
+
+func TestSentinels(t *testing.T) {
+	for _, tc := range []struct {
+		class   Class
+		matches []error
+		not     []error
+	}{
+		{NotOwner, []error{ErrNotOwner, ErrReverted}, []error{ErrInsufficientBalance, ErrUnknown}},
+		{InsufficientBalance, []error{ErrInsufficientBalance, ErrReverted}, []error{ErrNotOwner}},
+		{Reverted, []error{ErrReverted}, []error{ErrNotOwner, ErrInsufficientBalance}},
+		{NonceTooLow, []error{ErrNonceTooLow}, []error{ErrReverted, ErrReplacementUnderpriced}},
+	} {
+		err := &Error{Class: tc.class}
+		for _, target := range tc.matches {
+			if !errors.Is(err, target) {
+				t.Errorf("%v does not match %v", tc.class, target)
+			}
+		}
+		for _, target := range tc.not {
+			if errors.Is(err, target) {
+				t.Errorf("%v matches %v", tc.class, target)
+			}
+		}
+	}
+}
+
+func TestPolicy(t *testing.T) {
+	for class, want := range map[Class]Policy{
+		Unknown:                Permanent,
+		NonceTooLow:            RetryAfterResync,
+		ReplacementUnderpriced: RetryWithHigherFee,
+		InsufficientFunds:      Permanent,
+		Reverted:               Permanent,
+		NotOwner:               Permanent,
+		InsufficientBalance:    Permanent,
+		RateLimited:            RetryWithBackoff,
+		Timeout:                RetryWithBackoff,
+	} {
+		if got := class.Policy(); got != want {
+			t.Errorf("%v: policy %v, want %v", class, got, want)
+		}
+		if class.Retryable() != (want != Permanent) {
+			t.Errorf("%v: Retryable() = %v with policy %v", class, class.Retryable(), want)
+		}
+	}
+}
+
+func TestRejected(t *testing.T) {
+	for class, want := range map[Class]bool{
+		Unknown:                false,
+		NonceTooLow:            true,
+		ReplacementUnderpriced: true,
+		InsufficientFunds:      true,
+		Reverted:               true,
+		NotOwner:               true,
+		InsufficientBalance:    true,
+		RateLimited:            false,
+		Timeout:                false,
+	} {
+		if got := class.Rejected(); got != want {
+			t.Errorf("%v: Rejected() = %v, want %v", class, got, want)
+		}
+	}
+}
+
+func TestFromRevertReason(t *testing.T) {
+	err := FromRevertReason("Ownable: caller is not the owner")
+	if !errors.Is(err, ErrNotOwner) || err.Error() != "execution reverted: Ownable: caller is not the owner" {
+		t.Errorf("FromRevertReason = %v (%v)", err, err.Class)
+	}
+	if err := FromRevertReason(""); err.Class != Reverted || err.Error() != "execution reverted" {
+		t.Errorf("FromRevertReason(\"\") = %v (%v)", err, err.Class)
+	}
+}
@@ -0,0 +1,355 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"math/big"
+	"math/rand"
+	"sort"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+// amountAt is an amount as it stood after a block that changed it.
+type amountAt struct {
+	block uint64
+	value *big.Int
+}
+
+func amountBlock(a amountAt) uint64 { return a.block }
+
+// ledgerReplay is the ledger rebuilt from the indexed Transfer events alone,
+// independently of the projections the indexer maintains.
+type ledgerReplay struct {
+	transfers int
+	supply    []amountAt
+	balances  map[common.Address][]amountAt
+}
+
+// replayLedger replays transfers, which are in chain order. Mints come from
+// the zero address and burns go to it.
+func replayLedger(transfers []*transferRecord) *ledgerReplay {
+	r := &ledgerReplay{transfers: len(transfers), balances: make(map[common.Address][]amountAt)}
+	add := func(history []amountAt, block uint64, delta *big.Int) []amountAt {
+		value := new(big.Int).Set(delta)
+		if n := len(history); n > 0 {
+			value.Add(value, history[n-1].value)
+			if history[n-1].block == block {
+				history[n-1].value = value
+				return history
+			}
+		}
+		return append(history, amountAt{block: block, value: value})
+	}
+	for _, t := range transfers {
+		if t.From == (common.Address{}) {
+			r.supply = add(r.supply, t.Block, t.Value)
+		} else {
+			r.balances[t.From] = add(r.balances[t.From], t.Block, new(big.Int).Neg(t.Value))
+		}
+		if t.To == (common.Address{}) {
+			r.supply = add(r.supply, t.Block, new(big.Int).Neg(t.Value))
+		} else {
+			r.balances[t.To] = add(r.balances[t.To], t.Block, t.Value)
+		}
+	}
+	return r
+}
+
+func amountAtBlock(history []amountAt, block uint64) *big.Int {
+	if a, ok := pointAt(history, block, amountBlock); ok {
+		return a.value
+	}
+	return new(big.Int)
+}
+
+func (r *ledgerReplay) supplyAt(block uint64) *big.Int {
+	return amountAtBlock(r.supply, block)
+}
+
+func (r *ledgerReplay) balanceAt(account common.Address, block uint64) *big.Int {
+	return amountAtBlock(r.balances[account], block)
+}
+
+// balanceSum is the sum of the replayed balances as of block.
+func (r *ledgerReplay) balanceSum(block uint64) *big.Int {
+	sum := new(big.Int)
+	for _, history := range r.balances {
+		sum.Add(sum, amountAtBlock(history, block))
+	}
+	return sum
+}
+
+// accounts returns every account the replay saw, sorted so that a seeded
+// sample is reproducible.
+func (r *ledgerReplay) accounts() []common.Address {
+	accounts := make([]common.Address, 0, len(r.balances))
+	for a := range r.balances {
+		accounts = append(accounts, a)
+	}
+	sort.Slice(accounts, func(i, j int) bool { return accounts[i].Hex() < accounts[j].Hex() })
+	return accounts
+}
+
+// firstDivergence returns the first block of from..to at which agrees fails,
+// given that it fails at to. It assumes that a divergence persists once it
+// appears, which holds for a missed or extra event.
+func firstDivergence(from, to uint64, agrees func(block uint64) (bool, error)) (uint64, error) {
+	for from < to {
+		mid := from + (to-from)/2
+		ok, err := agrees(mid)
+		if err != nil {
+			return 0, err
+		}
+		if ok {
+			from = mid + 1
+		} else {
+			to = mid
+		}
+	}
+	return from, nil
+}
+
+// supplyReader is the part of the token binding the checker calls.
+type supplyReader interface {
+	TotalSupply(opts *bind.CallOpts) (*big.Int, error)
+	BalanceOf(opts *bind.CallOpts, account common.Address) (*big.Int, error)
+}
+
+// ledgerVerifier checks an indexed ledger against the chain.
+type ledgerVerifier struct {
+	ix      *index
+	replay  *ledgerReplay
+	token   supplyReader
+	from    uint64 // first indexed block
+	at      uint64 // block checked
+	samples int
+	rng     *rand.Rand
+	out     io.Writer
+
+	first  *uint64 // first offending block found so far
+	pruned bool    // the node has no state for the sampled earlier blocks
+}
+
+func (v *ledgerVerifier) callAt(ctx context.Context, block uint64) *bind.CallOpts {
+	return &bind.CallOpts{Context: ctx, BlockNumber: new(big.Int).SetUint64(block)}
+}
+
+func (v *ledgerVerifier) offending(block uint64) {
+	if v.first == nil || block < *v.first {
+		v.first = &block
+	}
+}
+
+// run performs every check and returns the first offending block, or nil
+// when the ledger agrees with the chain.
+func (v *ledgerVerifier) run(ctx context.Context) (*uint64, error) {
+	if err := v.checkProjections(); err != nil {
+		return nil, err
+	}
+	if err := v.checkSupply(ctx); err != nil {
+		return nil, err
+	}
+	if err := v.checkBalances(ctx); err != nil {
+		return nil, err
+	}
+	return v.first, nil
+}
+
+// checkProjections compares the balances the indexer maintains with the
+// replay.
+func (v *ledgerVerifier) checkProjections() error {
+	mismatches := 0
+	err := v.ix.view(func(tx storeTx) error {
+		points, err := tx.balances(v.at)
+		if err != nil {
+			return err
+		}
+		stored := make(map[common.Address]*balancePoint, len(points))
+		for _, p := range points {
+			stored[p.Account] = p
+		}
+		for _, account := range v.replay.accounts() {
+			want := v.replay.balanceAt(account, v.at)
+			got := new(big.Int)
+			if p := stored[account]; p != nil {
+				got = p.Value
+			}
+			if got.Cmp(want) == 0 {
+				continue
+			}
+			mismatches++
+			first, err := firstDivergence(v.from, v.at, func(block uint64) (bool, error) {
+				p, err := tx.balance(account, block)
+				if err != nil {
+					return false, err
+				}
+				got := new(big.Int)
+				if p != nil {
+					got = p.Value
+				}
+				return got.Cmp(v.replay.balanceAt(account, block)) == 0, nil
+			})
+			if err != nil {
+				return err
+			}
+			v.offending(first)
+			fmt.Fprintf(v.out, "projection of %s: stored %s, replay %s at block %d; first differs at block %d\n", account.Hex(), got, want, v.at, first)
+		}
+		return nil
+	})
+	if err == nil && mismatches == 0 {
+		fmt.Fprintf(v.out, "projections: %d accounts match the replay of %d transfers\n", len(v.replay.balances), v.replay.transfers)
+	}
+	return err
+}
+
+// checkSupply compares the sum of the replayed balances with TotalSupply.
+func (v *ledgerVerifier) checkSupply(ctx context.Context) error {
+	sum := v.replay.balanceSum(v.at)
+	if minted := v.replay.supplyAt(v.at); minted.Cmp(sum) != 0 {
+		// Every transfer moves value between two balances or the supply, so
+		// this only fails on a bug in the replay itself.
+		return fmt.Errorf("replay is inconsistent: balances add up to %s, mints less burns to %s", sum, minted)
+	}
+	supply, err := v.token.TotalSupply(v.callAt(ctx, v.at))
+	if err != nil {
+		return historicalState(v.at, err)
+	}
+	if supply.Cmp(sum) == 0 {
+		fmt.Fprintf(v.out, "supply at block %d: %s ok\n", v.at, supply)
+		return nil
+	}
+	first, err := firstDivergence(v.from, v.at, func(block uint64) (bool, error) {
+		supply, err := v.token.TotalSupply(v.callAt(ctx, block))
+		if err != nil {
+			return false, historicalState(block, err)
+		}
+		return supply.Cmp(v.replay.balanceSum(block)) == 0, nil
+	})
+	if err != nil {
+		return err
+	}
+	v.offending(first)
+	fmt.Fprintf(v.out, "supply at block %d: chain %s, indexed balances %s; first differs at block %d\n", v.at, supply, sum, first)
+	return nil
+}
+
+// checkBalances compares the replayed balances of sampled accounts with
+// BalanceOf, each at the checked block and at a random earlier one.
+func (v *ledgerVerifier) checkBalances(ctx context.Context) error {
+	accounts := v.replay.accounts()
+	v.rng.Shuffle(len(accounts), func(i, j int) { accounts[i], accounts[j] = accounts[j], accounts[i] })
+	if len(accounts) > v.samples {
+		accounts = accounts[:v.samples]
+	}
+	for _, account := range accounts {
+		agrees := func(block uint64) (bool, error) {
+			balance, err := v.token.BalanceOf(v.callAt(ctx, block), account)
+			if err != nil {
+				return false, historicalState(block, err)
+			}
+			return balance.Cmp(v.replay.balanceAt(account, block)) == 0, nil
+		}
+		earlier := v.from + uint64(v.rng.Int63n(int64(v.at-v.from+1)))
+		for _, block := range []uint64{earlier, v.at} {
+			if block == earlier && v.pruned {
+				continue
+			}
+			ok, err := agrees(block)
+			if block == earlier && err != nil && missingState(err) {
+				// Earlier blocks are a bonus; the search for the first
+				// offending block below still needs them.
+				fmt.Fprintf(v.out, "node has no state for block %d, checking balances at block %d only\n", block, v.at)
+				v.pruned = true
+				continue
+			}
+			if err != nil {
+				return err
+			}
+			if ok {
+				continue
+			}
+			first, err := firstDivergence(v.from, block, agrees)
+			if err != nil {
+				return err
+			}
+			v.offending(first)
+			fmt.Fprintf(v.out, "balance of %s at block %d: indexed %s differs from the chain; first differs at block %d\n",
+				account.Hex(), block, v.replay.balanceAt(account, block), first)
+			break
+		}
+	}
+	fmt.Fprintf(v.out, "balances: %d of %d accounts checked against BalanceOf\n", len(accounts), len(v.replay.balances))
+	return nil
+}
+
+// missingState reports whether a call failed because the node pruned the
+// state of its block.
+func missingState(err error) bool {
+	msg := strings.ToLower(err.Error())
+	for _, s := range []string{"missing trie node", "state is not available", "historical state", "state not available", "blocks other than the latest"} {
+		if strings.Contains(msg, s) {
+			return true
+		}
+	}
+	return false
+}
+
+// historicalState explains the usual reason a call at an old block fails.
+func historicalState(block uint64, err error) error {
+	return fmt.Errorf("call at block %d: %w (checking past blocks needs a node that keeps their state, such as an archive node)", block, err)
+}
+
+// verifyFilms compares every title of the indexed catalog as of block with
+// the film stored under it, and returns the titles that differ.
+func verifyFilms(ctx context.Context, ix *index, storage *filmStorage, block uint64, out io.Writer) ([]string, error) {
+	catalog, err := ix.catalogAt(block)
+	if err != nil {
+		return nil, err
+	}
+	titles := make([]string, 0, len(catalog))
+	for title := range catalog {
+		titles = append(titles, title)
+	}
+	sort.Strings(titles)
+
+	var differ []string
+	number := new(big.Int).SetUint64(block)
+	for _, title := range titles {
+		r := catalog[title]
+		stored, err := storage.film(ctx, title, number)
+		if err != nil {
+			if missingState(err) {
+				return nil, historicalState(block, err)
+			}
+			return nil, err
+		}
+		if filmMatches(r, stored) {
+			continue
+		}
+		differ = append(differ, title)
+		indexed := "deleted"
+		if !r.Deleted {
+			indexed = fmt.Sprintf("year=%s genre=%s", r.Year, genreName(r.Genre))
+		}
+		onChain := "none"
+		if stored != nil {
+			onChain = fmt.Sprintf("title=%q year=%s genre=%s", stored.Title, stored.Year, genreName(stored.Genre))
+		}
+		fmt.Fprintf(out, "%q: indexed %s (block %d tx %s), storage %s\n", title, indexed, r.Block, r.TxHash.Hex(), onChain)
+	}
+	fmt.Fprintf(out, "films: %d titles checked against storage at block %d, %d differ\n", len(titles), block, len(differ))
+	return differ, nil
+}
+
+// filmMatches reports whether the catalog entry r agrees with the stored film.
+func filmMatches(r *filmRecord, stored *storedFilm) bool {
+	if r.Deleted {
+		return stored == nil
+	}
+	return stored != nil && stored.Title == r.Title && stored.Year.Cmp(r.Year) == 0 && stored.Genre == r.Genre
+}
@@ -0,0 +1,215 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"math/big"
+	"math/rand"
+	"strings"
+	"testing"
+
+	"github.com/ethereum/go-ethereum/accounts/abi/bind"
+	"github.com/ethereum/go-ethereum/common"
+)
+
+var (
+	ledgerA = common.HexToAddress("0xa")
+	ledgerB = common.HexToAddress("0xb")
+	ledgerC = common.HexToAddress("0xc")
+)
+
+func ledgerTransfer(block uint64, from, to common.Address, value int64) *transferRecord {
+	return &transferRecord{logRef: logRef{Block: block}, From: from, To: to, Value: big.NewInt(value)}
+}
+
+// chainTransfers is the ledger of the fake token: two mints, transfers and a
+// burn.
+func chainTransfers() []*transferRecord {
+	return []*transferRecord{
+		ledgerTransfer(1, common.Address{}, ledgerA, 100),
+		ledgerTransfer(3, ledgerA, ledgerB, 30),
+		ledgerTransfer(5, ledgerA, ledgerC, 10),
+		ledgerTransfer(7, ledgerB, ledgerC, 5),
+		ledgerTransfer(9, common.Address{}, ledgerB, 50),
+		ledgerTransfer(11, ledgerC, common.Address{}, 5),
+	}
+}
+
+// withoutBlock returns transfers less the one of block.
+func withoutBlock(transfers []*transferRecord, block uint64) []*transferRecord {
+	var out []*transferRecord
+	for _, t := range transfers {
+		if t.Block != block {
+			out = append(out, t)
+		}
+	}
+	return out
+}
+
+// fakeSupply answers TotalSupply and BalanceOf at any block from a replay of
+// the chain's transfers.
+type fakeSupply struct {
+	chain *ledgerReplay
+}
+
+func callBlock(opts *bind.CallOpts) uint64 {
+	return opts.BlockNumber.Uint64()
+}
+
+func (f fakeSupply) TotalSupply(opts *bind.CallOpts) (*big.Int, error) {
+	return f.chain.supplyAt(callBlock(opts)), nil
+}
+
+func (f fakeSupply) BalanceOf(opts *bind.CallOpts, account common.Address) (*big.Int, error) {
+	return f.chain.balanceAt(account, callBlock(opts)), nil
+}
+
+func TestReplayLedger(t *testing.T) {
+	transfers := append(chainTransfers(), ledgerTransfer(13, ledgerA, ledgerB, 1), ledgerTransfer(13, ledgerA, ledgerB, 2))
+	r := replayLedger(transfers)
+	for _, tc := range []struct {
+		block   uint64
+		supply  int64
+		a, b, c int64
+	}{
+		{0, 0, 0, 0, 0},
+		{1, 100, 100, 0, 0},
+		{4, 100, 70, 30, 0},
+		{7, 100, 60, 25, 15},
+		{9, 150, 60, 75, 15},
+		{12, 145, 60, 75, 10},
+		{13, 145, 57, 78, 10},
+	} {
+		if got := r.supplyAt(tc.block); got.Int64() != tc.supply {
+			t.Errorf("supply at block %d = %v, want %d", tc.block, got, tc.supply)
+		}
+		if got := r.balanceSum(tc.block); got.Int64() != tc.supply {
+			t.Errorf("balances at block %d add up to %v, want %d", tc.block, got, tc.supply)
+		}
+		for account, want := range map[common.Address]int64{ledgerA: tc.a, ledgerB: tc.b, ledgerC: tc.c} {
+			if got := r.balanceAt(account, tc.block); got.Int64() != want {
+				t.Errorf("balance of %s at block %d = %v, want %d", account.Hex(), tc.block, got, want)
+			}
+		}
+	}
+	// Both transfers of block 13 make a single point.
+	if n := len(r.balances[ledgerB]); n != 4 {
+		t.Errorf("b has %d balance points, want one per block that changed it", n)
+	}
+	if got := r.accounts(); len(got) != 3 || got[0].Hex() > got[1].Hex() || got[1].Hex() > got[2].Hex() {
+		t.Errorf("accounts = %v, want the three sorted", got)
+	}
+}
+
+func TestFirstDivergence(t *testing.T) {
+	for _, tc := range []struct {
+		from, to, diverged uint64
+	}{
+		{0, 10, 10},
+		{0, 10, 0},
+		{0, 10, 7},
+		{5, 5, 5},
+		{3, 100, 4},
+		{3, 100, 99},
+	} {
+		calls := 0
+		got, err := firstDivergence(tc.from, tc.to, func(block uint64) (bool, error) {
+			calls++
+			if block < tc.from || block > tc.to {
+				t.Errorf("asked about block %d outside %d..%d", block, tc.from, tc.to)
+			}
+			return block < tc.diverged, nil
+		})
+		if err != nil || got != tc.diverged {
+			t.Errorf("firstDivergence(%d, %d) = %d, %v; want %d", tc.from, tc.to, got, err, tc.diverged)
+		}
+		if calls > 8 {
+			t.Errorf("firstDivergence(%d, %d) made %d calls", tc.from, tc.to, calls)
+		}
+	}
+}
+
+func TestLedgerVerifier(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		stored   []*transferRecord // applied to the index projections
+		replayed []*transferRecord // read back from the index
+		first    int64             // -1 when the ledger agrees
+		report   string
+	}{
+		{
+			name:     "complete",
+			stored:   chainTransfers(),
+			replayed: chainTransfers(),
+			first:    -1,
+			report:   "supply at block 12: 145 ok",
+		},
+		{
+			name:     "skipped transfer",
+			stored:   withoutBlock(chainTransfers(), 7),
+			replayed: withoutBlock(chainTransfers(), 7),
+			first:    7,
+			report:   "indexed 80 differs from the chain; first differs at block 7",
+		},
+		{
+			name:     "skipped early transfer",
+			stored:   withoutBlock(chainTransfers(), 5),
+			replayed: withoutBlock(chainTransfers(), 5),
+			first:    5,
+			report:   "indexed 70 differs from the chain; first differs at block 5",
+		},
+		{
+			name:     "skipped mint",
+			stored:   withoutBlock(chainTransfers(), 9),
+			replayed: withoutBlock(chainTransfers(), 9),
+			first:    9,
+			report:   "supply at block 12: chain 145, indexed balances 95; first differs at block 9",
+		},
+		{
+			name:     "stale projection",
+			stored:   withoutBlock(chainTransfers(), 5),
+			replayed: chainTransfers(),
+			first:    5,
+			report:   "stored 70, replay 60 at block 12; first differs at block 5",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			ix := &index{newMemStore()}
+			err := ix.update(func(tx storeTx) error {
+				for _, r := range tc.stored {
+					if err := applyTransfer(tx, r); err != nil {
+						return err
+					}
+				}
+				return nil
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			v := &ledgerVerifier{
+				ix:      ix,
+				replay:  replayLedger(tc.replayed),
+				token:   fakeSupply{replayLedger(chainTransfers())},
+				from:    0,
+				at:      12,
+				samples: 10,
+				rng:     rand.New(rand.NewSource(1)),
+				out:     &out,
+			}
+			first, err := v.run(context.Background())
+			if err != nil {
+				t.Fatal(err)
+			}
+			switch {
+			case tc.first < 0 && first != nil:
+				t.Errorf("first offending block %d, want none\n%s", *first, out.String())
+			case tc.first >= 0 && (first == nil || int64(*first) != tc.first):
+				t.Errorf("first offending block %v, want %d\n%s", first, tc.first, out.String())
+			}
+			if !strings.Contains(out.String(), tc.report) {
+				t.Errorf("report does not say %q:\n%s", tc.report, out.String())
+			}
+		})
+	}
+}
@@ -10,6 +10,10 @@ const etherscanKey = process.env.ETHERSCAN_KEY;
 module.exports = {
   solidity: "0.8.17",
   networks: {
+    // `emerald devnet`, which signs for its pre-funded accounts itself.
+    devnet: {
+      url: "http://127.0.0.1:8545"
+    },
     goerli: {
       url: endpoint,
       accounts: [`0x${privateKey}`]